@@ -1,26 +1,195 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
 	"github.com/roamBo/BoCloudStore/internal/access"
+	"github.com/roamBo/BoCloudStore/internal/business/apikey"
+	"github.com/roamBo/BoCloudStore/internal/business/auth"
+	"github.com/roamBo/BoCloudStore/internal/business/chunk_upload"
+	"github.com/roamBo/BoCloudStore/internal/business/download"
+	"github.com/roamBo/BoCloudStore/internal/business/namespace"
+	"github.com/roamBo/BoCloudStore/internal/business/quota"
+	"github.com/roamBo/BoCloudStore/internal/business/share"
+	"github.com/roamBo/BoCloudStore/internal/business/trash"
+	"github.com/roamBo/BoCloudStore/internal/business/version"
+	"github.com/roamBo/BoCloudStore/internal/metadata/cache"
+	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/observability/monitoring"
+	"github.com/roamBo/BoCloudStore/internal/observability/tracing"
+	"github.com/roamBo/BoCloudStore/internal/security/authn"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
 	"github.com/roamBo/BoCloudStore/internal/storage"
 	"github.com/roamBo/BoCloudStore/pkg/config"
+	"github.com/roamBo/BoCloudStore/pkg/pool"
+	"github.com/roamBo/BoCloudStore/pkg/ratelimit"
 	"github.com/roamBo/BoCloudStore/pkg/utils"
 	"go.uber.org/zap"
 )
 
+// shutdownTimeout is how long requests in flight may take to finish on shutdown
+const shutdownTimeout = 15 * time.Second
+
 func main() {
 	cfg := config.Load()
 	logger := utils.NewLogger(cfg.Env)
-	minioClient, err := storage.NewMinioClient(storage.MinioConfig(cfg.Minio))
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, logger)
+	if err != nil {
+		logger.Fatal("Unable to initialize tracing", zap.Error(err))
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Warn("failed to flush traces", zap.Error(err))
+		}
+	}()
+
+	objectStore, err := newObjectStore(cfg)
+
+	if err != nil {
+		logger.Fatal("Unable to initialize object store", zap.Error(err), zap.String("backend", cfg.Storage.Backend))
+	}
+	if cfg.Tracing.Enabled {
+		objectStore = storage.NewTracedStore(objectStore, cfg.Storage.Backend)
+	}
+
+	connector, err := pq.NewConnector(cfg.Postgres.DSN)
+	if err != nil {
+		logger.Fatal("Unable to initialize postgres connection", zap.Error(err))
+	}
+	var sqlConnector driver.Connector = connector
+	if cfg.Metrics.Enabled || cfg.Tracing.Enabled {
+		sqlConnector = monitoring.WrapConnector(connector)
+	}
+	sqlDB := sql.OpenDB(sqlConnector)
+	defer sqlDB.Close()
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	defer redisClient.Close()
+	if cfg.Tracing.Enabled {
+		redisClient.AddHook(tracing.RedisHook{})
+	}
 
+	poolOpts := []pool.Option{
+		pool.WithWorkerCount(cfg.Pool.WorkerCount),
+		pool.WithQueueSize(cfg.Pool.QueueSize),
+		pool.WithTaskObserver(monitoring.ObservePoolTask),
+		pool.WithTaskWrapper(utils.CarryLogger),
+	}
+	if cfg.Tracing.Enabled {
+		poolOpts = append(poolOpts, pool.WithTaskWrapper(tracing.WrapTask))
+	}
+	workerPool := pool.NewWorkerPool(logger, poolOpts...)
+	defer workerPool.Shutdown()
+	monitoring.RegisterWorkerPool(workerPool)
+
+	metadataSvc := service.NewService(db.NewPostgresStore(sqlDB), cache.NewRedisCache(redisClient, logger), logger,
+		service.WithMaxVersions(cfg.Versioning.MaxVersions),
+		service.WithDefaultQuota(cfg.Quota.DefaultBytes, cfg.Quota.DefaultFiles))
+	if cfg.Tracing.Enabled {
+		metadataSvc = service.NewTracedService(metadataSvc)
+	}
+	authorizer := authz.NewAuthorizer(metadataSvc, logger)
+	chunkUploadSvc := chunk_upload.NewService(metadataSvc, authorizer, objectStore, workerPool, logger,
+		cfg.Upload.ChunkSize, cfg.Upload.MergeWindow)
+	downloadSvc := download.NewService(metadataSvc, authorizer, objectStore, logger)
+	namespaceSvc := namespace.NewService(metadataSvc, authorizer, logger)
+	trashSvc := trash.NewService(metadataSvc, authorizer, objectStore, workerPool, logger)
+	versionSvc := version.NewService(metadataSvc, authorizer, objectStore, logger, cfg.Versioning.MaxVersionsLimit)
+	revocations := cache.NewRedisRevocationList(redisClient, logger)
+	authSvc := auth.NewService(metadataSvc, revocations, logger, cfg.JWT)
+	apiKeySvc := apikey.NewService(metadataSvc, authorizer, logger)
+	quotaSvc := quota.NewService(metadataSvc, logger, cfg.Quota)
+
+	var keySet *authn.KeySet
+	if cfg.JWT.JWKS.File != "" || cfg.JWT.JWKS.URL != "" {
+		keySet, err = authn.NewKeySet(cfg.JWT.JWKS, logger)
+		if err != nil {
+			logger.Fatal("Unable to load jwks", zap.Error(err))
+		}
+		keySet.Start()
+		defer keySet.Stop()
+	}
+	verifier, err := authn.NewVerifier(cfg.JWT, keySet)
 	if err != nil {
-		logger.Fatal("Unable to initialize minio client", zap.Error(err))
+		logger.Fatal("Unable to initialize token verifier", zap.Error(err))
 	}
+	var limiter ratelimit.Limiter
+	if cfg.RateLimit.Enabled {
+		limiter = ratelimit.NewMemoryLimiter()
+		if cfg.RateLimit.Backend == "redis" {
+			limiter = ratelimit.NewFallbackLimiter(ratelimit.NewRedisLimiter(redisClient), limiter, logger)
+		}
+	}
+	shareSvc := share.NewService(metadataSvc, authorizer, namespaceSvc, downloadSvc, chunkUploadSvc, logger, cfg.Upload.MaxChunkSize)
 
-	router := access.SetupRouter(minioClient, logger)
+	if cfg.Reaper.Enabled {
+		reaper := chunk_upload.NewReaper(metadataSvc, objectStore, workerPool, logger, cfg.Reaper)
+		reaper.Start()
+		defer reaper.Stop()
+	}
+	if cfg.Trash.Enabled {
+		purger := trash.NewPurger(metadataSvc, objectStore, workerPool, logger, cfg.Trash)
+		purger.Start()
+		defer purger.Stop()
+	}
+
+	router := access.SetupRouter(cfg, objectStore, metadataSvc, chunkUploadSvc, downloadSvc, namespaceSvc, trashSvc, versionSvc, shareSvc, authSvc, apiKeySvc, quotaSvc, verifier, revocations, authorizer, limiter, logger)
+
+	// the server is stopped on SIGINT/SIGTERM so that the deferred stops above run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{
+		Addr:    ":" + cfg.ServerPort,
+		Handler: router,
+	}
+	go func() {
+		logger.Info("Starting server", zap.String("port", cfg.ServerPort))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Unable to start server", zap.Error(err))
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Info("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("server did not shut down cleanly", zap.Error(err))
+	}
+}
 
-	logger.Info("Starting server", zap.String("port", cfg.ServerPort))
-	if err := router.Run(":" + cfg.ServerPort); err != nil {
-		logger.Fatal("Unable to start server", zap.Error(err))
+func newObjectStore(cfg *config.Config) (storage.ObjectStore, error) {
+	switch cfg.Storage.Backend {
+	case "filesystem":
+		return storage.NewFileSystemStore(cfg.Storage.Root)
+	case "memory":
+		return storage.NewMemoryStore(), nil
+	case "minio", "":
+		minioClient, err := storage.NewMinioClient(storage.MinioConfig(cfg.Minio))
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewMinioStore(minioClient, cfg.Minio.Bucket), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Storage.Backend)
 	}
 }
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type ACLHandler struct {
+	authorizer  authz.Authorizer
+	metadataSvc service.Service
+	logger      *zap.Logger
+}
+
+type grantRequest struct {
+	// owner, editor or viewer
+	Role string `json:"role"`
+}
+
+func NewACLHandler(authorizer authz.Authorizer, metadataSvc service.Service, logger *zap.Logger) *ACLHandler {
+	return &ACLHandler{
+		authorizer:  authorizer,
+		metadataSvc: metadataSvc,
+		logger:      logger,
+	}
+}
+
+// ListGrants lists the roles granted on the file or folder named by the route parameter param
+func (h *ACLHandler) ListGrants(resourceType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resource, err := h.authorizer.Resolve(c.Request.Context(), resourceType, c.Param(param))
+		if err != nil {
+			h.abortWithError(c, err)
+			return
+		}
+		grants, err := h.authorizer.ListGrants(c.Request.Context(), authz.User(c.GetString("user_id")), resource)
+		if err != nil {
+			h.abortWithError(c, err)
+			return
+		}
+		resp := make([]gin.H, 0, len(grants))
+		for _, grant := range grants {
+			resp = append(resp, grantResponse(grant))
+		}
+		c.JSON(http.StatusOK, gin.H{"grants": resp})
+	}
+}
+
+// Grant gives the user :user_id a role on the resource, replacing the role granted before
+func (h *ACLHandler) Grant(resourceType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req grantRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		resource, err := h.authorizer.Resolve(c.Request.Context(), resourceType, c.Param(param))
+		if err != nil {
+			h.abortWithError(c, err)
+			return
+		}
+		grant, err := h.authorizer.Grant(c.Request.Context(), authz.User(c.GetString("user_id")), resource,
+			c.Param("user_id"), req.Role)
+		if err != nil {
+			h.abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, grantResponse(grant))
+	}
+}
+
+func (h *ACLHandler) Revoke(resourceType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resource, err := h.authorizer.Resolve(c.Request.Context(), resourceType, c.Param(param))
+		if err != nil {
+			h.abortWithError(c, err)
+			return
+		}
+		err = h.authorizer.Revoke(c.Request.Context(), authz.User(c.GetString("user_id")), resource, c.Param("user_id"))
+		if err != nil {
+			h.abortWithError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// SharedWithMe lists the files and folders other users granted the user a role on
+func (h *ACLHandler) SharedWithMe(c *gin.Context) {
+	grants, err := h.metadataSvc.ListUserGrants(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	resp := make([]gin.H, 0, len(grants))
+	for _, grant := range grants {
+		resp = append(resp, grantResponse(grant))
+	}
+	c.JSON(http.StatusOK, gin.H{"grants": resp})
+}
+
+func grantResponse(grant *metadata.Grant) gin.H {
+	return gin.H{
+		"resource_type": grant.ResourceType,
+		"resource_id":   grant.ResourceID,
+		"user_id":       grant.UserID,
+		"role":          grant.Role,
+		"granted_by":    grant.GrantedBy,
+		"create_at":     grant.CreateAt,
+	}
+}
+
+func (h *ACLHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, authz.ErrInvalidRole),
+		errors.Is(err, authz.ErrSelfGrant):
+		code = http.StatusBadRequest
+	case errors.Is(err, service.ErrFileNotFound),
+		errors.Is(err, service.ErrFolderNotFound),
+		errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrGrantNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, authz.ErrForbidden):
+		code = http.StatusForbidden
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("acl request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
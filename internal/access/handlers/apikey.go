@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/apikey"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type APIKeyHandler struct {
+	apiKeySvc apikey.Service
+	logger    *zap.Logger
+}
+
+type createAPIKeyRequest struct {
+	Name string `json:"name"`
+	// "files:read", "files:write" and "admin", defaults to files:read and files:write
+	Scopes []string `json:"scopes"`
+	// unix seconds, 0 never expires
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+type createServiceKeyRequest struct {
+	createAPIKeyRequest
+	// the service account the key acts as
+	UserID string `json:"user_id"`
+}
+
+func NewAPIKeyHandler(apiKeySvc apikey.Service, logger *zap.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeySvc: apiKeySvc,
+		logger:    logger,
+	}
+}
+
+// CreateKey creates a personal key of the user, the key itself is only part of this response
+func (h *APIKeyHandler) CreateKey(c *gin.Context) {
+	var req createAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	key, rawKey, err := h.apiKeySvc.CreatePersonalKey(c.Request.Context(), &apikey.CreateRequest{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}, c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	resp := apiKeyResponse(key)
+	resp["key"] = rawKey
+	c.JSON(http.StatusCreated, resp)
+}
+
+// CreateServiceKey creates a key for the service account user_id, admins only
+func (h *APIKeyHandler) CreateServiceKey(c *gin.Context) {
+	var req createServiceKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserID == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	key, rawKey, err := h.apiKeySvc.CreateServiceKey(c.Request.Context(), &apikey.CreateRequest{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}, req.UserID, c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	resp := apiKeyResponse(key)
+	resp["key"] = rawKey
+	c.JSON(http.StatusCreated, resp)
+}
+
+// ListKeys lists the keys of the user
+func (h *APIKeyHandler) ListKeys(c *gin.Context) {
+	h.listKeys(c, c.GetString("user_id"))
+}
+
+// ListAllKeys lists the keys of every user or of ?user_id=, admins only
+func (h *APIKeyHandler) ListAllKeys(c *gin.Context) {
+	h.listKeys(c, c.Query("user_id"))
+}
+
+func (h *APIKeyHandler) listKeys(c *gin.Context, userID string) {
+	keys, err := h.apiKeySvc.ListKeys(c.Request.Context(), userID)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	resp := make([]gin.H, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, apiKeyResponse(key))
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": resp})
+}
+
+// RevokeKey revokes :key_id, a key of the user or any key for admins
+func (h *APIKeyHandler) RevokeKey(c *gin.Context) {
+	if err := h.apiKeySvc.RevokeKey(c.Request.Context(), c.Param("key_id"), c.GetString("user_id")); err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func apiKeyResponse(key *metadata.APIKey) gin.H {
+	return gin.H{
+		"key_id":       key.KeyID,
+		"prefix":       key.Prefix,
+		"user_id":      key.UserID,
+		"name":         key.Name,
+		"kind":         key.Kind,
+		"scopes":       key.Scopes,
+		"created_by":   key.CreatedBy,
+		"expires_at":   key.ExpiresAt,
+		"last_used_at": key.LastUsedAt,
+		"revoked_at":   key.RevokedAt,
+		"create_at":    key.CreateAt,
+	}
+}
+
+func (h *APIKeyHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, apikey.ErrInvalidName),
+		errors.Is(err, apikey.ErrInvalidScope),
+		errors.Is(err, apikey.ErrInvalidExpiry):
+		code = http.StatusBadRequest
+	case errors.Is(err, apikey.ErrScopeNotAllowed):
+		code = http.StatusForbidden
+	case errors.Is(err, service.ErrAPIKeyNotFound),
+		errors.Is(err, service.ErrUserNotFound):
+		code = http.StatusNotFound
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("api key request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/auth"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type AuthHandler struct {
+	authSvc auth.Service
+	logger  *zap.Logger
+}
+
+type credentialsRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type logoutRequest struct {
+	// revokes the session this refresh token belongs to
+	RefreshToken string `json:"refresh_token"`
+	// revokes every session of the user
+	All bool `json:"all"`
+}
+
+func NewAuthHandler(authSvc auth.Service, logger *zap.Logger) *AuthHandler {
+	return &AuthHandler{
+		authSvc: authSvc,
+		logger:  logger,
+	}
+}
+
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req credentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	user, err := h.authSvc.Register(c.Request.Context(), req.Username, req.Password)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, userResponse(user))
+}
+
+// Login exchanges a username and password for a bearer access token
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req credentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	user, token, err := h.authSvc.Login(c.Request.Context(), req.Username, req.Password)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	resp := tokenResponse(token)
+	resp["user"] = userResponse(user)
+	c.JSON(http.StatusOK, resp)
+}
+
+// Refresh rotates a refresh token into a new token pair
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	token, err := h.authSvc.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse(token))
+}
+
+// Logout revokes the access token of the request, the session of refresh_token and with all
+// every other session of the user. The body is optional.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req logoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+	}
+	session := &auth.Session{
+		UserID:    c.GetString("user_id"),
+		JTI:       c.GetString("jti"),
+		ExpiresAt: c.GetTime("token_expires_at"),
+	}
+	if err := h.authSvc.Logout(c.Request.Context(), session, req.RefreshToken, req.All); err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func tokenResponse(token *auth.Token) gin.H {
+	return gin.H{
+		"access_token":       token.AccessToken,
+		"token_type":         "Bearer",
+		"expires_in":         int64(time.Until(token.ExpiresAt).Seconds()),
+		"expires_at":         token.ExpiresAt.Unix(),
+		"refresh_token":      token.RefreshToken,
+		"refresh_expires_at": token.RefreshExpiresAt.Unix(),
+	}
+}
+
+// Me returns the account of the token's subject
+func (h *AuthHandler) Me(c *gin.Context) {
+	user, err := h.authSvc.GetUser(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, userResponse(user))
+}
+
+type userRoleRequest struct {
+	// "user" or "admin"
+	Role string `json:"role"`
+}
+
+// SetUserRole changes the role of :user_id, admins only
+func (h *AuthHandler) SetUserRole(c *gin.Context) {
+	var req userRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	user, err := h.authSvc.SetUserRole(c.Request.Context(), c.Param("user_id"), req.Role)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, userResponse(user))
+}
+
+func userResponse(user *metadata.User) gin.H {
+	return gin.H{
+		"user_id":   user.UserID,
+		"username":  user.Username,
+		"role":      user.Role,
+		"create_at": user.CreateAt,
+	}
+}
+
+func (h *AuthHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, auth.ErrInvalidUsername),
+		errors.Is(err, auth.ErrInvalidPassword),
+		errors.Is(err, auth.ErrInvalidRole):
+		code = http.StatusBadRequest
+	case errors.Is(err, auth.ErrInvalidCredentials),
+		errors.Is(err, auth.ErrInvalidToken),
+		errors.Is(err, auth.ErrTokenReused):
+		code = http.StatusUnauthorized
+	case errors.Is(err, service.ErrUserNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, service.ErrUserExists):
+		code = http.StatusConflict
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("auth request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
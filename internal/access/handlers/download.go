@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/download"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/internal/storage"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type DownloadHandler struct {
+	downloadSvc download.Service
+	presignCfg  config.PresignConfig
+	logger      *zap.Logger
+}
+
+func NewDownloadHandler(downloadSvc download.Service, presignCfg config.PresignConfig, logger *zap.Logger) *DownloadHandler {
+	return &DownloadHandler{
+		downloadSvc: downloadSvc,
+		presignCfg:  presignCfg,
+		logger:      logger,
+	}
+}
+
+// Download streams a merged file, serving GET and HEAD with Range (including multi-range),
+// If-None-Match and If-Modified-Since support
+func (h *DownloadHandler) Download(c *gin.Context) {
+	file, err := h.downloadSvc.OpenFile(c.Request.Context(), c.Param("file_id"), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	serveFile(c, file)
+}
+
+// DownloadVersion streams a previous version of a file with the same semantics as Download
+func (h *DownloadHandler) DownloadVersion(c *gin.Context) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+	file, err := h.downloadSvc.OpenVersion(c.Request.Context(), c.Param("file_id"), version, c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	serveFile(c, file)
+}
+
+func serveFile(c *gin.Context, file *download.File) {
+	defer file.Content.Close()
+
+	disposition := "attachment"
+	if c.Query("inline") == "true" {
+		disposition = "inline"
+	}
+	contentType := mime.TypeByExtension(path.Ext(file.Meta.FileName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": file.Meta.FileName}))
+	if file.Meta.ETag != "" {
+		header.Set("ETag", `"`+file.Meta.ETag+`"`)
+	}
+	// ServeContent answers conditional and range requests from the headers above and only
+	// seeks to and reads the requested byte ranges
+	http.ServeContent(c.Writer, c.Request, file.Meta.FileName, time.Unix(file.Meta.UpdateAt, 0), file.Content)
+}
+
+// PresignDownload returns a time limited url to GET the file straight from the object store
+func (h *DownloadHandler) PresignDownload(c *gin.Context) {
+	expiry, err := presignExpiry(c, h.presignCfg)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	url, err := h.downloadSvc.PresignDownload(c.Request.Context(), c.Param("file_id"), c.GetString("user_id"), expiry)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	presignResponse(c, http.MethodGet, url, expiry)
+}
+
+func (h *DownloadHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errInvalidExpiry):
+		code = http.StatusBadRequest
+	case errors.Is(err, service.ErrFileNotFound),
+		errors.Is(err, service.ErrVersionNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, authz.ErrForbidden):
+		code = http.StatusForbidden
+	case errors.Is(err, download.ErrFileNotReady):
+		code = http.StatusConflict
+	case errors.Is(err, storage.ErrObjectNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, storage.ErrPresignNotSupported):
+		code = http.StatusNotImplemented
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("download request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/namespace"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errInvalidFilter = errors.New("invalid listing filter")
+)
+
+var fileStatuses = map[string]bool{
+	metadata.StatusUploading: true,
+	metadata.StatusMerged:    true,
+	metadata.StatusExpiring:  true,
+	metadata.StatusExpired:   true,
+	metadata.StatusTrashed:   true,
+	metadata.StatusPurging:   true,
+}
+
+type FileHandler struct {
+	metadataSvc service.Service
+	authorizer  authz.Authorizer
+	logger      *zap.Logger
+}
+
+func NewFileHandler(metadataSvc service.Service, authorizer authz.Authorizer, logger *zap.Logger) *FileHandler {
+	return &FileHandler{
+		metadataSvc: metadataSvc,
+		authorizer:  authorizer,
+		logger:      logger,
+	}
+}
+
+// ListFiles returns one page of the user's files. Query parameters:
+// sort=name|size|date, order=asc|desc, limit, cursor (next_cursor of the previous page),
+// status (comma separated), prefix, folder_id, min_size, max_size,
+// created_after and created_before (unix seconds or RFC 3339). Admins may list the files of
+// another user with owner_id.
+func (h *FileHandler) ListFiles(c *gin.Context) {
+	query, err := h.listQuery(c)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	page, err := h.metadataSvc.ListFiles(c.Request.Context(), query)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	files := make([]gin.H, 0, len(page.Files))
+	for _, file := range page.Files {
+		files = append(files, fileEntryResponse(file))
+	}
+	resp := gin.H{"files": files, "next_cursor": nil}
+	if page.Next != nil {
+		resp["next_cursor"] = encodeCursor(page.Next)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListTrash lists the user's trashed files, accepting the same parameters as ListFiles except status
+func (h *FileHandler) ListTrash(c *gin.Context) {
+	query, err := h.listQuery(c)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	query.Statuses = []string{metadata.StatusTrashed}
+
+	page, err := h.metadataSvc.ListFiles(c.Request.Context(), query)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	files := make([]gin.H, 0, len(page.Files))
+	for _, file := range page.Files {
+		entry := fileEntryResponse(file)
+		entry["trashed_at"] = file.UpdateAt
+		files = append(files, entry)
+	}
+	resp := gin.H{"files": files, "next_cursor": nil}
+	if page.Next != nil {
+		resp["next_cursor"] = encodeCursor(page.Next)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// listQuery parses the listing parameters and checks access to the listed namespace
+func (h *FileHandler) listQuery(c *gin.Context) (*metadata.FileListQuery, error) {
+	query, err := parseFileListQuery(c)
+	if err != nil {
+		return nil, err
+	}
+	if ownerID := c.Query("owner_id"); ownerID != "" && ownerID != query.UserID {
+		err := h.authorizer.Authorize(c.Request.Context(), authz.User(query.UserID), authz.ActionRead, authz.RootFolder(ownerID))
+		if err != nil {
+			return nil, err
+		}
+		query.UserID = ownerID
+	}
+	return query, nil
+}
+
+func parseFileListQuery(c *gin.Context) (*metadata.FileListQuery, error) {
+	query := &metadata.FileListQuery{
+		UserID:     c.GetString("user_id"),
+		SortBy:     c.DefaultQuery("sort", metadata.SortByDate),
+		NamePrefix: c.Query("prefix"),
+		Limit:      defaultPageSize,
+	}
+
+	switch query.SortBy {
+	case metadata.SortByName, metadata.SortBySize, metadata.SortByDate:
+	default:
+		return nil, errInvalidFilter
+	}
+	// newest first unless asked otherwise, names sort ascending by default
+	order := c.Query("order")
+	if order == "" && query.SortBy != metadata.SortByName {
+		order = "desc"
+	}
+	switch order {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return nil, errInvalidFilter
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return nil, errInvalidFilter
+		}
+		query.Limit = limit
+	}
+	if raw := c.Query("status"); raw != "" {
+		for _, status := range strings.Split(raw, ",") {
+			if !fileStatuses[status] {
+				return nil, errInvalidFilter
+			}
+			query.Statuses = append(query.Statuses, status)
+		}
+	}
+	if folderID, ok := c.GetQuery("folder_id"); ok {
+		folderID = namespace.NormalizeFolderID(folderID)
+		query.FolderID = &folderID
+	}
+
+	var err error
+	if query.MinSize, err = parseSize(c.Query("min_size")); err != nil {
+		return nil, err
+	}
+	if query.MaxSize, err = parseSize(c.Query("max_size")); err != nil {
+		return nil, err
+	}
+	if query.CreatedAfter, err = parseTimestamp(c.Query("created_after")); err != nil {
+		return nil, err
+	}
+	if query.CreatedBefore, err = parseTimestamp(c.Query("created_before")); err != nil {
+		return nil, err
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw)
+		// a cursor is only valid for the ordering it was issued for
+		if err != nil || cursor.SortBy != query.SortBy || cursor.Desc != query.Desc {
+			return nil, errInvalidCursor
+		}
+		query.After = cursor
+	}
+	return query, nil
+}
+
+func parseSize(raw string) (*int64, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	size, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || size < 0 {
+		return nil, errInvalidFilter
+	}
+	return &size, nil
+}
+
+func parseTimestamp(raw string) (*int64, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if unix, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return &unix, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, errInvalidFilter
+	}
+	unix := t.Unix()
+	return &unix, nil
+}
+
+// the cursor is opaque to clients
+func encodeCursor(cursor *metadata.FileCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (*metadata.FileCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cursor metadata.FileCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.FileID == "" {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func (h *FileHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errInvalidCursor),
+		errors.Is(err, errInvalidFilter):
+		code = http.StatusBadRequest
+	case errors.Is(err, authz.ErrForbidden):
+		code = http.StatusForbidden
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("file request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/namespace"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type NamespaceHandler struct {
+	namespaceSvc namespace.Service
+	logger       *zap.Logger
+}
+
+type createFolderRequest struct {
+	// parent folder id, empty or "root" for the user's root
+	ParentID string `json:"parent_id"`
+	Name     string `json:"name"`
+}
+
+type renameRequest struct {
+	Name string `json:"name"`
+}
+
+type moveRequest struct {
+	// target folder id, empty or "root" for the user's root
+	FolderID string `json:"folder_id"`
+}
+
+func NewNamespaceHandler(namespaceSvc namespace.Service, logger *zap.Logger) *NamespaceHandler {
+	return &NamespaceHandler{
+		namespaceSvc: namespaceSvc,
+		logger:       logger,
+	}
+}
+
+// CreateFolder creates a folder below parent_id
+func (h *NamespaceHandler) CreateFolder(c *gin.Context) {
+	var req createFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	folder, err := h.namespaceSvc.CreateFolder(c.Request.Context(), c.GetString("user_id"), req.ParentID, req.Name)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, folderResponse(folder))
+}
+
+// ListFolder returns the sub folders and merged files of a folder, "root" lists the user's root
+func (h *NamespaceHandler) ListFolder(c *gin.Context) {
+	listing, err := h.namespaceSvc.ListFolder(c.Request.Context(), c.GetString("user_id"), c.Param("folder_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	folders := make([]gin.H, 0, len(listing.Folders))
+	for _, folder := range listing.Folders {
+		folders = append(folders, folderResponse(folder))
+	}
+	files := make([]gin.H, 0, len(listing.Files))
+	for _, file := range listing.Files {
+		files = append(files, fileEntryResponse(file))
+	}
+	resp := gin.H{
+		"folder_id": namespace.RootFolderID,
+		"folders":   folders,
+		"files":     files,
+	}
+	if listing.Folder != nil {
+		resp = folderResponse(listing.Folder)
+		resp["folders"] = folders
+		resp["files"] = files
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *NamespaceHandler) RenameFolder(c *gin.Context) {
+	var req renameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	folder, err := h.namespaceSvc.RenameFolder(c.Request.Context(), c.GetString("user_id"), c.Param("folder_id"), req.Name)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, folderResponse(folder))
+}
+
+// MoveFolder moves a folder and everything below it into folder_id
+func (h *NamespaceHandler) MoveFolder(c *gin.Context) {
+	var req moveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	folder, err := h.namespaceSvc.MoveFolder(c.Request.Context(), c.GetString("user_id"), c.Param("folder_id"), req.FolderID)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, folderResponse(folder))
+}
+
+func (h *NamespaceHandler) RenameFile(c *gin.Context) {
+	var req renameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	file, err := h.namespaceSvc.RenameFile(c.Request.Context(), c.GetString("user_id"), c.Param("file_id"), req.Name)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fileEntryResponse(file))
+}
+
+func (h *NamespaceHandler) MoveFile(c *gin.Context) {
+	var req moveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	file, err := h.namespaceSvc.MoveFile(c.Request.Context(), c.GetString("user_id"), c.Param("file_id"), req.FolderID)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fileEntryResponse(file))
+}
+
+// ResolvePath looks up a folder or file by its path, e.g. GET /paths/docs/2026/report.pdf
+func (h *NamespaceHandler) ResolvePath(c *gin.Context) {
+	entry, err := h.namespaceSvc.Resolve(c.Request.Context(), c.GetString("user_id"), c.Param("path"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	switch {
+	case entry.File != nil:
+		resp := fileEntryResponse(entry.File)
+		resp["type"] = "file"
+		c.JSON(http.StatusOK, resp)
+	case entry.Folder != nil:
+		resp := folderResponse(entry.Folder)
+		resp["type"] = "folder"
+		c.JSON(http.StatusOK, resp)
+	default:
+		c.JSON(http.StatusOK, gin.H{"type": "folder", "folder_id": namespace.RootFolderID})
+	}
+}
+
+func folderResponse(folder *metadata.Folder) gin.H {
+	return gin.H{
+		"folder_id": folder.FolderID,
+		"parent_id": apiFolderID(folder.ParentID),
+		"name":      folder.Name,
+		"create_at": folder.CreateAt,
+		"update_at": folder.UpdateAt,
+	}
+}
+
+func fileEntryResponse(file *metadata.FileMetadata) gin.H {
+	return gin.H{
+		"file_id":   file.FileID,
+		"folder_id": apiFolderID(file.FolderID),
+		"file_name": file.FileName,
+		"size":      file.TotalSize,
+		"etag":      file.ETag,
+		"status":    file.Status,
+		"version":   file.Version,
+		"create_at": file.CreateAt,
+		"update_at": file.UpdateAt,
+	}
+}
+
+func apiFolderID(folderID string) string {
+	if folderID == "" {
+		return namespace.RootFolderID
+	}
+	return folderID
+}
+
+func (h *NamespaceHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, namespace.ErrInvalidName),
+		errors.Is(err, namespace.ErrInvalidPath),
+		errors.Is(err, service.ErrInvalidMove):
+		code = http.StatusBadRequest
+	case errors.Is(err, service.ErrFolderNotFound),
+		errors.Is(err, service.ErrFileNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, authz.ErrForbidden):
+		code = http.StatusForbidden
+	case errors.Is(err, service.ErrNameConflict),
+		errors.Is(err, namespace.ErrFileUnavailable):
+		code = http.StatusConflict
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("namespace request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
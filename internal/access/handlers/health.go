@@ -3,20 +3,21 @@ package handlers
 import (
 	"context"
 	"github.com/gin-gonic/gin"
-	"github.com/minio/minio-go/v7"
+	"github.com/roamBo/BoCloudStore/internal/storage"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
 	"go.uber.org/zap"
 	"net/http"
 	"time"
 )
 
 type HealthHandler struct {
-	minioClient *minio.Client
+	objectStore storage.ObjectStore
 	logger      *zap.Logger
 }
 
-func NewHealthHandler(minioClient *minio.Client, logger *zap.Logger) *HealthHandler {
+func NewHealthHandler(objectStore storage.ObjectStore, logger *zap.Logger) *HealthHandler {
 	return &HealthHandler{
-		minioClient: minioClient,
+		objectStore: objectStore,
 		logger:      logger,
 	}
 }
@@ -25,13 +26,13 @@ func (h *HealthHandler) HealthCheck(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	_, err := h.minioClient.ListBuckets(ctx)
-	if err != nil {
-		h.logger.Error("Failed to list buckets", zap.Error(err))
+	if err := h.objectStore.Ping(ctx); err != nil {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("Failed to reach object store", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status": "unhealthy",
 			"error":  "storage service unavailable",
 		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
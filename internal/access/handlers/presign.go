@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+)
+
+var errInvalidExpiry = errors.New("invalid presign expiry")
+
+type presignRequest struct {
+	// optional lifetime in seconds, capped by the configured maximum
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// presignExpiry reads the requested url lifetime from the optional json body
+func presignExpiry(c *gin.Context, cfg config.PresignConfig) (time.Duration, error) {
+	var req presignRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return 0, errInvalidExpiry
+		}
+	}
+	if req.ExpiresIn == 0 {
+		return cfg.Expiry, nil
+	}
+	expiry := time.Duration(req.ExpiresIn) * time.Second
+	if req.ExpiresIn < 0 || expiry > cfg.MaxExpiry {
+		return 0, errInvalidExpiry
+	}
+	return expiry, nil
+}
+
+func presignResponse(c *gin.Context, method, url string, expiry time.Duration) {
+	c.JSON(http.StatusOK, gin.H{
+		"url":        url,
+		"method":     method,
+		"expires_at": time.Now().Add(expiry).UTC().Format(time.RFC3339),
+	})
+}
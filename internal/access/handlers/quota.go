@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/quota"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type QuotaHandler struct {
+	quotaSvc quota.Service
+	logger   *zap.Logger
+}
+
+type setQuotaRequest struct {
+	// 0 is unlimited
+	MaxBytes int64 `json:"max_bytes"`
+	MaxFiles int64 `json:"max_files"`
+}
+
+func NewQuotaHandler(quotaSvc quota.Service, logger *zap.Logger) *QuotaHandler {
+	return &QuotaHandler{
+		quotaSvc: quotaSvc,
+		logger:   logger,
+	}
+}
+
+// MyUsage returns the usage of the user and the quotas it is subject to
+func (h *QuotaHandler) MyUsage(c *gin.Context) {
+	report, err := h.quotaSvc.GetReport(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reportResponse(report))
+}
+
+// UserUsage returns the usage of :user_id, admins only
+func (h *QuotaHandler) UserUsage(c *gin.Context) {
+	report, err := h.quotaSvc.GetReport(c.Request.Context(), c.Param("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reportResponse(report))
+}
+
+// RecomputeUsage rebuilds the usage of :user_id from its files, admins only
+func (h *QuotaHandler) RecomputeUsage(c *gin.Context) {
+	report, err := h.quotaSvc.RecomputeUsage(c.Request.Context(), c.Param("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reportResponse(report))
+}
+
+// SetUserQuota sets the quota of :user_id, admins only
+func (h *QuotaHandler) SetUserQuota(c *gin.Context) {
+	var req setQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	q, err := h.quotaSvc.SetUserQuota(c.Request.Context(), c.Param("user_id"), req.MaxBytes, req.MaxFiles)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, quotaResponse(q))
+}
+
+// ResetUserQuota removes the quota of :user_id so the default applies again, admins only
+func (h *QuotaHandler) ResetUserQuota(c *gin.Context) {
+	if err := h.quotaSvc.ResetUserQuota(c.Request.Context(), c.Param("user_id")); err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetGroup returns the members, quota and usage of :group, admins only
+func (h *QuotaHandler) GetGroup(c *gin.Context) {
+	group, err := h.quotaSvc.GetGroup(c.Request.Context(), c.Param("group"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	resp := gin.H{
+		"group":   group.Name,
+		"members": group.Members,
+		"usage":   usageResponse(group.Usage),
+		"quota":   nil,
+	}
+	if group.Quota != nil {
+		resp["quota"] = quotaResponse(group.Quota)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// SetGroupQuota sets the quota shared by the members of :group, admins only
+func (h *QuotaHandler) SetGroupQuota(c *gin.Context) {
+	var req setQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	q, err := h.quotaSvc.SetGroupQuota(c.Request.Context(), c.Param("group"), req.MaxBytes, req.MaxFiles)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, quotaResponse(q))
+}
+
+// DeleteGroupQuota removes the quota of :group, admins only
+func (h *QuotaHandler) DeleteGroupQuota(c *gin.Context) {
+	if err := h.quotaSvc.DeleteGroupQuota(c.Request.Context(), c.Param("group")); err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// AddGroupMember adds :user_id to :group, admins only
+func (h *QuotaHandler) AddGroupMember(c *gin.Context) {
+	if err := h.quotaSvc.AddMember(c.Request.Context(), c.Param("group"), c.Param("user_id")); err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RemoveGroupMember removes :user_id from :group, admins only
+func (h *QuotaHandler) RemoveGroupMember(c *gin.Context) {
+	if err := h.quotaSvc.RemoveMember(c.Request.Context(), c.Param("group"), c.Param("user_id")); err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func reportResponse(report *quota.Report) gin.H {
+	limits := make([]gin.H, 0, len(report.Limits))
+	for _, limit := range report.Limits {
+		limits = append(limits, gin.H{
+			"subject_type": limit.SubjectType,
+			"subject_id":   limit.SubjectID,
+			"max_bytes":    limit.MaxBytes,
+			"max_files":    limit.MaxFiles,
+			"default":      limit.Default,
+			"usage":        usageResponse(limit.Usage),
+		})
+	}
+	return gin.H{
+		"user_id": report.UserID,
+		"usage":   usageResponse(report.Usage),
+		"limits":  limits,
+	}
+}
+
+func usageResponse(usage *metadata.Usage) gin.H {
+	return gin.H{
+		"used_bytes":     usage.UsedBytes,
+		"used_files":     usage.UsedFiles,
+		"reserved_bytes": usage.ReservedBytes,
+		"reserved_files": usage.ReservedFiles,
+		"update_at":      usage.UpdateAt,
+	}
+}
+
+func quotaResponse(q *metadata.Quota) gin.H {
+	return gin.H{
+		"subject_type": q.SubjectType,
+		"subject_id":   q.SubjectID,
+		"max_bytes":    q.MaxBytes,
+		"max_files":    q.MaxFiles,
+		"update_at":    q.UpdateAt,
+	}
+}
+
+func (h *QuotaHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, quota.ErrInvalidQuota),
+		errors.Is(err, quota.ErrInvalidGroup):
+		code = http.StatusBadRequest
+	case errors.Is(err, service.ErrQuotaNotFound),
+		errors.Is(err, service.ErrGroupMemberNotFound),
+		errors.Is(err, service.ErrUserNotFound):
+		code = http.StatusNotFound
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("quota request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/chunk_upload"
+	"github.com/roamBo/BoCloudStore/internal/business/download"
+	"github.com/roamBo/BoCloudStore/internal/business/namespace"
+	"github.com/roamBo/BoCloudStore/internal/business/share"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/internal/storage"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type ShareHandler struct {
+	shareSvc share.Service
+	logger   *zap.Logger
+}
+
+type createShareRequest struct {
+	// "file" or "folder"
+	ResourceType string `json:"resource_type"`
+	ResourceID   string `json:"resource_id"`
+	Password     string `json:"password"`
+	// "read" (default) or "upload", upload is only available for folders
+	Mode string `json:"mode"`
+	// unix seconds, 0 never expires
+	ExpiresAt int64 `json:"expires_at"`
+	// 0 is unlimited
+	MaxDownloads int `json:"max_downloads"`
+}
+
+func NewShareHandler(shareSvc share.Service, logger *zap.Logger) *ShareHandler {
+	return &ShareHandler{
+		shareSvc: shareSvc,
+		logger:   logger,
+	}
+}
+
+// CreateShare creates a share link for a file or folder of the user
+func (h *ShareHandler) CreateShare(c *gin.Context) {
+	var req createShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	created, err := h.shareSvc.CreateShare(c.Request.Context(), &share.CreateRequest{
+		ResourceType: req.ResourceType,
+		ResourceID:   req.ResourceID,
+		Password:     req.Password,
+		Mode:         req.Mode,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
+	}, c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, shareResponse(created))
+}
+
+// ListShares lists the user's active shares
+func (h *ShareHandler) ListShares(c *gin.Context) {
+	shares, err := h.shareSvc.ListShares(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	resp := make([]gin.H, 0, len(shares))
+	for _, s := range shares {
+		resp = append(resp, shareResponse(s))
+	}
+	c.JSON(http.StatusOK, gin.H{"shares": resp})
+}
+
+func (h *ShareHandler) RevokeShare(c *gin.Context) {
+	if err := h.shareSvc.RevokeShare(c.Request.Context(), c.Param("share_id"), c.GetString("user_id")); err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ShareInfo describes an opened share, folder shares also list the shared folder or,
+// with folder_id, one of its sub folders
+func (h *ShareHandler) ShareInfo(c *gin.Context) {
+	opened := c.MustGet("share").(*metadata.Share)
+	resp := gin.H{
+		"resource_type": opened.ResourceType,
+		"mode":          opened.Mode,
+		"expires_at":    opened.ExpiresAt,
+	}
+	if opened.MaxDownloads != 0 {
+		resp["downloads_left"] = max(opened.MaxDownloads-opened.DownloadCount, 0)
+	}
+
+	if opened.ResourceType == metadata.ShareTypeFile {
+		file, err := h.shareSvc.GetFile(c.Request.Context(), opened, "")
+		if err != nil {
+			h.abortWithError(c, err)
+			return
+		}
+		resp["file"] = sharedFileResponse(file)
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	listing, err := h.shareSvc.ListFolder(c.Request.Context(), opened, c.Query("folder_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	folders := make([]gin.H, 0, len(listing.Folders))
+	for _, folder := range listing.Folders {
+		folders = append(folders, gin.H{"folder_id": folder.FolderID, "name": folder.Name})
+	}
+	files := make([]gin.H, 0, len(listing.Files))
+	for _, file := range listing.Files {
+		files = append(files, sharedFileResponse(file))
+	}
+	resp["folder"] = gin.H{
+		"folder_id": listing.Folder.FolderID,
+		"name":      listing.Folder.Name,
+		"folders":   folders,
+		"files":     files,
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// DownloadShared streams a file of the share like Download does. Every GET answered with content
+// (200 or 206, whatever range it asks for) counts against the download limit. HEAD and conditional
+// requests answered without a body are free.
+func (h *ShareHandler) DownloadShared(c *gin.Context) {
+	opened := c.MustGet("share").(*metadata.Share)
+	file, err := h.shareSvc.OpenFile(c.Request.Context(), opened, c.Param("file_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	if c.Request.Method != http.MethodGet {
+		serveFile(c, file)
+		return
+	}
+
+	counting := &downloadCountingWriter{
+		ResponseWriter: c.Writer,
+		consume: func() error {
+			return h.shareSvc.ConsumeDownload(c.Request.Context(), opened)
+		},
+	}
+	c.Writer = counting
+	serveFile(c, file)
+	c.Writer = counting.ResponseWriter
+	if counting.err != nil {
+		// the limit was used up in the meantime, nothing of the file was written
+		header := c.Writer.Header()
+		for _, key := range []string{"Content-Type", "Content-Length", "Content-Range", "Content-Disposition", "Accept-Ranges", "ETag", "Last-Modified"} {
+			header.Del(key)
+		}
+		h.abortWithError(c, counting.err)
+	}
+}
+
+// downloadCountingWriter consumes a share download when the response turns out to carry content.
+// When that fails the status and body are withheld and err is set for the handler to report.
+type downloadCountingWriter struct {
+	gin.ResponseWriter
+	consume func() error
+	err     error
+}
+
+func (w *downloadCountingWriter) WriteHeader(code int) {
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		if err := w.consume(); err != nil {
+			w.err = err
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *downloadCountingWriter) Write(data []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *downloadCountingWriter) WriteString(s string) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// UploadShared stores the request body as a new file in an upload share, the name is taken
+// from the name query parameter and the target from folder_id (default: the shared folder)
+func (h *ShareHandler) UploadShared(c *gin.Context) {
+	opened := c.MustGet("share").(*metadata.Share)
+	file, err := h.shareSvc.Upload(c.Request.Context(), opened, c.Query("folder_id"), c.Query("name"),
+		c.Request.ContentLength, c.Request.Body)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, sharedFileResponse(file))
+}
+
+func shareResponse(s *metadata.Share) gin.H {
+	return gin.H{
+		"share_id":       s.ShareID,
+		"token":          s.Token,
+		"resource_type":  s.ResourceType,
+		"resource_id":    s.ResourceID,
+		"mode":           s.Mode,
+		"has_password":   s.PasswordHash != "",
+		"expires_at":     s.ExpiresAt,
+		"max_downloads":  s.MaxDownloads,
+		"download_count": s.DownloadCount,
+		"create_at":      s.CreateAt,
+	}
+}
+
+// sharedFileResponse leaves out what only concerns the owner
+func sharedFileResponse(file *metadata.FileMetadata) gin.H {
+	return gin.H{
+		"file_id":   file.FileID,
+		"file_name": file.FileName,
+		"size":      file.TotalSize,
+		"etag":      file.ETag,
+		"update_at": file.UpdateAt,
+	}
+}
+
+func (h *ShareHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, share.ErrInvalidShare),
+		errors.Is(err, namespace.ErrInvalidName):
+		code = http.StatusBadRequest
+	case errors.Is(err, service.ErrShareNotFound),
+		errors.Is(err, service.ErrFileNotFound),
+		errors.Is(err, service.ErrFolderNotFound),
+		errors.Is(err, share.ErrOutsideShare),
+		errors.Is(err, storage.ErrObjectNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, share.ErrUploadNotAllowed),
+		errors.Is(err, authz.ErrForbidden):
+		code = http.StatusForbidden
+	case errors.Is(err, share.ErrNotShareable),
+		errors.Is(err, download.ErrFileNotReady),
+		errors.Is(err, service.ErrNameConflict),
+		errors.Is(err, chunk_upload.ErrFileSizeMismatch):
+		code = http.StatusConflict
+	case errors.Is(err, service.ErrShareLimitReached):
+		code = http.StatusGone
+	case errors.Is(err, share.ErrLengthRequired):
+		code = http.StatusLengthRequired
+	case errors.Is(err, share.ErrUploadTooLarge),
+		errors.Is(err, chunk_upload.ErrChunkTooLarge),
+		errors.Is(err, service.ErrQuotaExceeded):
+		code = http.StatusRequestEntityTooLarge
+	case errors.Is(err, chunk_upload.ErrChunkIncomplete):
+		code = http.StatusBadRequest
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("share request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/trash"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type TrashHandler struct {
+	trashSvc trash.Service
+	logger   *zap.Logger
+}
+
+func NewTrashHandler(trashSvc trash.Service, logger *zap.Logger) *TrashHandler {
+	return &TrashHandler{
+		trashSvc: trashSvc,
+		logger:   logger,
+	}
+}
+
+// DeleteFile moves a file to the trash, it is purged once the retention period ends
+func (h *TrashHandler) DeleteFile(c *gin.Context) {
+	file, err := h.trashSvc.TrashFile(c.Request.Context(), c.Param("file_id"), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fileEntryResponse(file))
+}
+
+func (h *TrashHandler) RestoreFile(c *gin.Context) {
+	file, err := h.trashSvc.RestoreFile(c.Request.Context(), c.Param("file_id"), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fileEntryResponse(file))
+}
+
+// EmptyTrash permanently deletes every trashed file, the objects are removed in the background
+func (h *TrashHandler) EmptyTrash(c *gin.Context) {
+	count, err := h.trashSvc.EmptyTrash(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"purged": count})
+}
+
+func (h *TrashHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrFileNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, authz.ErrForbidden):
+		code = http.StatusForbidden
+	case errors.Is(err, trash.ErrNotTrashable),
+		errors.Is(err, service.ErrFileNotTrashed),
+		errors.Is(err, service.ErrNameConflict):
+		code = http.StatusConflict
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("trash request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
@@ -1 +1,323 @@
 package handlers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/roamBo/BoCloudStore/internal/business/chunk_upload"
+	"github.com/roamBo/BoCloudStore/internal/business/namespace"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/internal/storage"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var (
+	errInvalidFileSize  = errors.New("invalid file size")
+	errInvalidChunkSize = errors.New("invalid chunk size")
+	errFileTooLarge     = errors.New("file exceeds maximum size")
+	errInvalidHash      = errors.New("invalid content hash")
+	errInvalidChecksum  = errors.New("invalid chunk checksum header")
+)
+
+type UploadHandler struct {
+	chunkUploadSvc chunk_upload.Service
+	metadataSvc    service.Service
+	namespaceSvc   namespace.Service
+	uploadCfg      config.UploadConfig
+	presignCfg     config.PresignConfig
+	logger         *zap.Logger
+}
+
+type initUploadRequest struct {
+	FileName  string `json:"file_name"`
+	TotalSize int64  `json:"total_size"`
+	ChunkSize int64  `json:"chunk_size"`
+	// target folder, empty or "root" for the user's root
+	FolderID string `json:"folder_id"`
+	// optional sha256 (hex) of the whole file, enables instant upload
+	ContentHash string `json:"content_hash"`
+}
+
+func NewUploadHandler(
+	chunkUploadSvc chunk_upload.Service,
+	metadataSvc service.Service,
+	namespaceSvc namespace.Service,
+	uploadCfg config.UploadConfig,
+	presignCfg config.PresignConfig,
+	logger *zap.Logger,
+) *UploadHandler {
+	return &UploadHandler{
+		chunkUploadSvc: chunkUploadSvc,
+		metadataSvc:    metadataSvc,
+		namespaceSvc:   namespaceSvc,
+		uploadCfg:      uploadCfg,
+		presignCfg:     presignCfg,
+		logger:         logger,
+	}
+}
+
+// InitUpload validates the upload request and creates the file record in uploading state
+func (h *UploadHandler) InitUpload(c *gin.Context) {
+	var req initUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if req.ChunkSize == 0 {
+		req.ChunkSize = h.uploadCfg.ChunkSize
+	}
+	req.ContentHash = strings.ToLower(req.ContentHash)
+	if err := h.validateInitUpload(&req); err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	folderID := namespace.NormalizeFolderID(req.FolderID)
+	// files uploaded into a folder shared with the user belong to the owner of the folder
+	ownerID, err := h.namespaceSvc.CheckFolder(c.Request.Context(), c.GetString("user_id"), folderID)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	chunkCount := int((req.TotalSize + req.ChunkSize - 1) / req.ChunkSize)
+	fileMeta := &metadata.FileMetadata{
+		FileID:      uuid.NewString(),
+		FileName:    req.FileName,
+		TotalSize:   req.TotalSize,
+		ChunkCount:  chunkCount,
+		ChunkSize:   req.ChunkSize,
+		Status:      metadata.StatusUploading,
+		UserID:      ownerID,
+		FolderID:    folderID,
+		ContentHash: req.ContentHash,
+	}
+	instant, err := h.chunkUploadSvc.InstantUpload(c.Request.Context(), fileMeta, c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	if !instant {
+		if err := h.metadataSvc.CreateFileMetadata(c.Request.Context(), fileMeta); err != nil {
+			h.abortWithError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"file_id":     fileMeta.FileID,
+		"file_name":   fileMeta.FileName,
+		"folder_id":   apiFolderID(fileMeta.FolderID),
+		"total_size":  fileMeta.TotalSize,
+		"chunk_size":  fileMeta.ChunkSize,
+		"chunk_count": fileMeta.ChunkCount,
+		"status":      fileMeta.Status,
+		"instant":     instant,
+	})
+}
+
+// UploadChunk streams the request body into object storage as one chunk
+func (h *UploadHandler) UploadChunk(c *gin.Context) {
+	fileID := c.Param("file_id")
+	chunkID, err := strconv.Atoi(c.Param("chunk_id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid chunk id"})
+		return
+	}
+
+	checksum, err := parseChunkChecksum(c)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	chunkMeta, err := h.chunkUploadSvc.UploadChunk(c.Request.Context(), fileID, chunkID, c.Request.Body, checksum, c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":  chunkMeta.FileID,
+		"chunk_id": chunkMeta.ChunkID,
+		"etag":     chunkMeta.ETag,
+		"size":     chunkMeta.Size,
+	})
+}
+
+// PresignChunk returns a time limited url to PUT one chunk straight into the object store
+func (h *UploadHandler) PresignChunk(c *gin.Context) {
+	chunkID, err := strconv.Atoi(c.Param("chunk_id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid chunk id"})
+		return
+	}
+	expiry, err := presignExpiry(c, h.presignCfg)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	url, err := h.chunkUploadSvc.PresignChunkUpload(c.Request.Context(), c.Param("file_id"), chunkID, c.GetString("user_id"), expiry)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	presignResponse(c, http.MethodPut, url, expiry)
+}
+
+// RegisterChunk records a chunk that was PUT through a presigned url, so that the merge sees it
+func (h *UploadHandler) RegisterChunk(c *gin.Context) {
+	chunkID, err := strconv.Atoi(c.Param("chunk_id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid chunk id"})
+		return
+	}
+	checksum, err := parseChunkChecksum(c)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	chunkMeta, err := h.chunkUploadSvc.RegisterChunk(c.Request.Context(), c.Param("file_id"), chunkID, checksum, c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":  chunkMeta.FileID,
+		"chunk_id": chunkMeta.ChunkID,
+		"etag":     chunkMeta.ETag,
+		"size":     chunkMeta.Size,
+	})
+}
+
+// MergeChunks assembles the uploaded chunks and returns the final object info
+func (h *UploadHandler) MergeChunks(c *gin.Context) {
+	fileMeta, err := h.chunkUploadSvc.MergeChunks(c.Request.Context(), c.Param("file_id"), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":   fileMeta.FileID,
+		"file_name": fileMeta.FileName,
+		"size":      fileMeta.TotalSize,
+		"etag":      fileMeta.ETag,
+		"status":    fileMeta.Status,
+	})
+}
+
+// UploadStatus reports the stored chunk indexes so clients can resume an interrupted upload
+func (h *UploadHandler) UploadStatus(c *gin.Context) {
+	fileID := c.Param("file_id")
+	userID := c.GetString("user_id")
+
+	status, err := h.chunkUploadSvc.GetUploadStatus(c.Request.Context(), fileID, userID)
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":     status.File.FileID,
+		"status":      status.File.Status,
+		"total_size":  status.File.TotalSize,
+		"chunk_size":  status.File.ChunkSize,
+		"chunk_count": status.File.ChunkCount,
+		"uploaded":    status.Uploaded,
+		"missing":     status.Missing,
+	})
+}
+
+func (h *UploadHandler) validateInitUpload(req *initUploadRequest) error {
+	if err := namespace.ValidateName(req.FileName); err != nil {
+		return err
+	}
+	if req.TotalSize < 0 {
+		return errInvalidFileSize
+	}
+	if req.TotalSize > h.uploadCfg.MaxFileSize {
+		return errFileTooLarge
+	}
+	if req.ChunkSize <= 0 || req.ChunkSize > h.uploadCfg.MaxChunkSize {
+		return errInvalidChunkSize
+	}
+	if req.ContentHash != "" {
+		if decoded, err := hex.DecodeString(req.ContentHash); err != nil || len(decoded) != sha256.Size {
+			return errInvalidHash
+		}
+	}
+	return nil
+}
+
+// parseChunkChecksum reads the optional Content-MD5 (base64, RFC 1864) and X-Chunk-SHA256 (hex) headers
+func parseChunkChecksum(c *gin.Context) (chunk_upload.ChunkChecksum, error) {
+	var checksum chunk_upload.ChunkChecksum
+	if contentMD5 := c.GetHeader("Content-MD5"); contentMD5 != "" {
+		decoded, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(decoded) != md5.Size {
+			return checksum, errInvalidChecksum
+		}
+		checksum.MD5 = hex.EncodeToString(decoded)
+	}
+	if chunkSHA256 := strings.ToLower(c.GetHeader("X-Chunk-SHA256")); chunkSHA256 != "" {
+		if decoded, err := hex.DecodeString(chunkSHA256); err != nil || len(decoded) != sha256.Size {
+			return checksum, errInvalidChecksum
+		}
+		checksum.SHA256 = chunkSHA256
+	}
+	return checksum, nil
+}
+
+func (h *UploadHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, namespace.ErrInvalidName),
+		errors.Is(err, errInvalidFileSize),
+		errors.Is(err, errInvalidChunkSize),
+		errors.Is(err, errInvalidHash),
+		errors.Is(err, errInvalidChecksum),
+		errors.Is(err, errInvalidExpiry),
+		errors.Is(err, chunk_upload.ErrChecksumMismatch),
+		errors.Is(err, chunk_upload.ErrHashMismatch),
+		errors.Is(err, chunk_upload.ErrInvalidChunkID),
+		errors.Is(err, chunk_upload.ErrChunkIncomplete):
+		code = http.StatusBadRequest
+	case errors.Is(err, service.ErrFileNotFound),
+		errors.Is(err, service.ErrFolderNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, authz.ErrForbidden):
+		code = http.StatusForbidden
+	case errors.Is(err, chunk_upload.ErrAlreadyMerged),
+		errors.Is(err, chunk_upload.ErrUploadNotActive),
+		errors.Is(err, chunk_upload.ErrChunkMissing),
+		errors.Is(err, chunk_upload.ErrChunkCorrupted),
+		errors.Is(err, chunk_upload.ErrFileSizeMismatch),
+		errors.Is(err, service.ErrNameConflict):
+		code = http.StatusConflict
+	case errors.Is(err, errFileTooLarge),
+		errors.Is(err, chunk_upload.ErrChunkTooLarge),
+		errors.Is(err, service.ErrQuotaExceeded):
+		code = http.StatusRequestEntityTooLarge
+	case errors.Is(err, storage.ErrPresignNotSupported):
+		code = http.StatusNotImplemented
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("upload request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/version"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type VersionHandler struct {
+	versionSvc version.Service
+	logger     *zap.Logger
+}
+
+type versionPolicyRequest struct {
+	MaxVersions *int `json:"max_versions"`
+}
+
+func NewVersionHandler(versionSvc version.Service, logger *zap.Logger) *VersionHandler {
+	return &VersionHandler{
+		versionSvc: versionSvc,
+		logger:     logger,
+	}
+}
+
+// ListVersions returns the current version of a file followed by the previous ones, newest first
+func (h *VersionHandler) ListVersions(c *gin.Context) {
+	history, err := h.versionSvc.ListVersions(c.Request.Context(), c.Param("file_id"), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+
+	versions := make([]gin.H, 0, len(history.Previous)+1)
+	versions = append(versions, gin.H{
+		"version":    history.Current.Version,
+		"size":       history.Current.TotalSize,
+		"etag":       history.Current.ETag,
+		"current":    true,
+		"updated_at": history.Current.UpdateAt,
+	})
+	for _, v := range history.Previous {
+		versions = append(versions, gin.H{
+			"version":     v.Version,
+			"size":        v.TotalSize,
+			"etag":        v.ETag,
+			"current":     false,
+			"replaced_at": v.ReplacedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":  history.Current.FileID,
+		"versions": versions,
+	})
+}
+
+// RestoreVersion makes a previous version current, the content it replaces is kept as the newest version
+func (h *VersionHandler) RestoreVersion(c *gin.Context) {
+	v, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+	file, err := h.versionSvc.RestoreVersion(c.Request.Context(), c.Param("file_id"), v, c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fileEntryResponse(file))
+}
+
+func (h *VersionHandler) GetPolicy(c *gin.Context) {
+	maxVersions, err := h.versionSvc.GetMaxVersions(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"max_versions": maxVersions})
+}
+
+// SetPolicy sets how many previous versions are kept per file, older ones are pruned on the next change
+func (h *VersionHandler) SetPolicy(c *gin.Context) {
+	var req versionPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.MaxVersions == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if err := h.versionSvc.SetMaxVersions(c.Request.Context(), c.GetString("user_id"), *req.MaxVersions); err != nil {
+		h.abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"max_versions": *req.MaxVersions})
+}
+
+func (h *VersionHandler) abortWithError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, version.ErrInvalidPolicy):
+		code = http.StatusBadRequest
+	case errors.Is(err, service.ErrFileNotFound),
+		errors.Is(err, service.ErrVersionNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, authz.ErrForbidden):
+		code = http.StatusForbidden
+	case errors.Is(err, service.ErrFileNotMerged):
+		code = http.StatusConflict
+	case errors.Is(err, service.ErrQuotaExceeded):
+		code = http.StatusRequestEntityTooLarge
+	}
+	if code == http.StatusInternalServerError {
+		utils.LoggerFrom(c.Request.Context(), h.logger).Error("version request failed", zap.Error(err), zap.String("path", c.FullPath()))
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"go.uber.org/zap"
+)
+
+// RequireAdmin lets only users with the admin role pass, it runs after Authenticate
+func RequireAdmin(logger *zap.Logger, authorizer authz.Authorizer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		admin, err := authorizer.IsAdmin(c.Request.Context(), userID)
+		if err != nil {
+			logger.Error("failed to check admin role", zap.Error(err), zap.String("userID", userID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			c.Abort()
+			return
+		}
+		if !admin {
+			logger.Warn("admin access denied", zap.String("userID", userID), zap.String("path", c.FullPath()))
+			c.JSON(http.StatusForbidden, gin.H{"error": authz.ErrForbidden.Error()})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,51 +1,105 @@
 package middleware
 
 import (
-	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/roamBo/BoCloudStore/pkg/config"
-	"go.uber.org/zap"
+	"errors"
 	"net/http"
 	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/apikey"
+	"github.com/roamBo/BoCloudStore/internal/metadata/cache"
+	"github.com/roamBo/BoCloudStore/internal/security/authn"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"go.uber.org/zap"
+)
+
+// authentication methods stored as "auth_method"
+const (
+	AuthMethodToken  = "token"
+	AuthMethodAPIKey = "api_key"
 )
 
-func JWTAuth(logger *zap.Logger, cfg *config.Config) gin.HandlerFunc {
+// Authenticate accepts either an X-API-Key header or a bearer access token that passes the
+// verifier and is not revoked. It sets "user_id" to the authenticated user, "scopes"
+// to the scopes of the credential and "auth_method". Access tokens also set "jti" and
+// "token_expires_at", api keys set "api_key_id".
+func Authenticate(logger *zap.Logger, verifier authn.Verifier, revocations cache.RevocationList, apiKeySvc apikey.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			logger.Warn("missing authorization header")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
-			c.Abort()
+		if rawKey := c.GetHeader("X-API-Key"); rawKey != "" {
+			authenticateAPIKey(c, logger, apiKeySvc, rawKey)
 			return
 		}
+		authenticateToken(c, logger, verifier, revocations)
+	}
+}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			logger.Warn("invalid authorization format")
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token format"})
-			c.Abort()
-			return
+func authenticateAPIKey(c *gin.Context, logger *zap.Logger, apiKeySvc apikey.Service, rawKey string) {
+	key, err := apiKeySvc.Authenticate(c.Request.Context(), rawKey)
+	if err != nil {
+		if errors.Is(err, apikey.ErrInvalidKey) || errors.Is(err, apikey.ErrKeyExpired) {
+			logger.Warn("invalid api key", zap.Error(err))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		}
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", key.UserID)
+	c.Set("scopes", key.Scopes)
+	c.Set("auth_method", AuthMethodAPIKey)
+	c.Set("api_key_id", key.KeyID)
+	c.Next()
+}
 
-		token, err := jwt.ParseWithClaims(parts[1], &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.JWT.Secret), nil
-		})
-		if err != nil || !token.Valid {
-			logger.Warn("invalid token", zap.Error(err))
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+func authenticateToken(c *gin.Context, logger *zap.Logger, verifier authn.Verifier, revocations cache.RevocationList) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		logger.Warn("missing authorization header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+		c.Abort()
+		return
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		logger.Warn("invalid authorization format")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token format"})
+		c.Abort()
+		return
+	}
+
+	claims, err := verifier.Verify(parts[1])
+	if err != nil {
+		logger.Warn("invalid token", zap.Error(err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		c.Abort()
+		return
+	}
+
+	if claims.ID != "" {
+		revoked, err := revocations.IsRevoked(c.Request.Context(), claims.ID)
+		// fail closed, a revoked token must not slip through while redis is unavailable
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "token revocation check failed"})
 			c.Abort()
 			return
 		}
-
-		claims, ok := token.Claims.(*jwt.RegisteredClaims)
-		if !ok {
-			logger.Warn("invalid token claims", zap.Error(err))
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
+		if revoked {
+			logger.Warn("revoked token", zap.String("jti", claims.ID), zap.String("userID", claims.Subject))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token revoked"})
 			c.Abort()
 			return
 		}
+	}
 
-		c.Set("user_id", claims.Subject)
-		c.Next()
+	c.Set("user_id", claims.Subject)
+	c.Set("scopes", authz.AllScopes)
+	c.Set("auth_method", AuthMethodToken)
+	c.Set("jti", claims.ID)
+	if claims.ExpiresAt != nil {
+		c.Set("token_expires_at", claims.ExpiresAt.Time)
 	}
+	c.Next()
 }
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/observability/monitoring"
+)
+
+// Metrics records the count, latency and in-flight requests of every route. Requests that match
+// no route are recorded under "unmatched" so scanners cannot blow up the label set.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		done := monitoring.RequestStarted(c.Request.Method, route)
+		c.Next()
+		done(c.Writer.Status())
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"github.com/roamBo/BoCloudStore/pkg/ratelimit"
+	"go.uber.org/zap"
+)
+
+// RateLimit limits the requests to a route group per client ip and, when it runs after Authenticate,
+// per user. Request bodies and responses are throttled to the byte rates of rule. A nil limiter
+// disables rate limiting. A limiter error lets the request through.
+func RateLimit(logger *zap.Logger, limiter ratelimit.Limiter, group string, rule config.RateLimitRule) gin.HandlerFunc {
+	if limiter == nil {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	ipLimit := ratelimit.PerSecond(rule.IPRate, rule.IPBurst)
+	userLimit := ratelimit.PerSecond(rule.UserRate, rule.UserBurst)
+	uploadLimit := ratelimit.PerSecond(float64(rule.UploadRate), rule.ByteBurst)
+	downloadLimit := ratelimit.PerSecond(float64(rule.DownloadRate), rule.ByteBurst)
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		client := "ip:" + c.ClientIP()
+		wait, err := limiter.Take(ctx, group+":"+client, ipLimit, 1)
+		if err == nil && wait == 0 {
+			if userID := c.GetString("user_id"); userID != "" {
+				client = "user:" + userID
+				wait, err = limiter.Take(ctx, group+":"+client, userLimit, 1)
+			}
+		}
+		if err != nil {
+			logger.Error("failed to check rate limit", zap.Error(err), zap.String("group", group))
+		} else if wait > 0 {
+			logger.Warn("rate limit exceeded",
+				zap.String("group", group),
+				zap.String("client", client),
+				zap.Duration("retryAfter", wait))
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			c.Abort()
+			return
+		}
+
+		if !uploadLimit.Unlimited() && c.Request.Body != nil && c.Request.Body != http.NoBody {
+			c.Request.Body = ratelimit.NewReader(ctx, c.Request.Body, limiter, group+":up:"+client, uploadLimit)
+		}
+		if !downloadLimit.Unlimited() {
+			c.Writer = &throttledWriter{ResponseWriter: c.Writer, ctx: ctx, limiter: limiter, key: group + ":down:" + client, limit: downloadLimit}
+		}
+		c.Next()
+	}
+}
+
+// throttledWriter paces the response body, headers are written without delay
+type throttledWriter struct {
+	gin.ResponseWriter
+	ctx     context.Context
+	limiter ratelimit.Limiter
+	key     string
+	limit   ratelimit.Limit
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	return ratelimit.WriteThrottled(w.ctx, w.ResponseWriter, w.limiter, w.key, w.limit, p)
+}
+
+func (w *throttledWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"github.com/roamBo/BoCloudStore/pkg/ratelimit"
+	"go.uber.org/zap"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// failingLimiter stands for a redis limiter that cannot be reached
+type failingLimiter struct{}
+
+func (failingLimiter) Take(ctx context.Context, key string, limit ratelimit.Limit, n int64) (time.Duration, error) {
+	return 0, errors.New("connection refused")
+}
+
+// newRateLimitedRouter serves /echo behind RateLimit, a user_id query parameter stands for an authenticated user
+func newRateLimitedRouter(limiter ratelimit.Limiter, rule config.RateLimitRule) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if userID := c.Query("user_id"); userID != "" {
+			c.Set("user_id", userID)
+		}
+	})
+	router.Use(RateLimit(zap.NewNop(), limiter, "test", rule))
+	router.POST("/echo", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Data(http.StatusOK, "text/plain", body)
+	})
+	return router
+}
+
+func doRequest(router *gin.Engine, ip, query, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/echo"+query, strings.NewReader(body))
+	req.RemoteAddr = ip + ":12345"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimit(t *testing.T) {
+	rule := config.RateLimitRule{IPRate: 1, IPBurst: 2, UserRate: 0.4, UserBurst: 1}
+	// each step sends one request after advancing the clock by elapsed
+	steps := []struct {
+		name           string
+		elapsed        time.Duration
+		ip             string
+		query          string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{name: "ip burst 1", ip: "10.0.0.1", wantStatus: http.StatusOK},
+		{name: "ip burst 2", ip: "10.0.0.1", wantStatus: http.StatusOK},
+		{name: "ip bucket empty", ip: "10.0.0.1", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "1"},
+		{name: "other ip", ip: "10.0.0.2", wantStatus: http.StatusOK},
+		{name: "ip refilled", elapsed: time.Second, ip: "10.0.0.1", wantStatus: http.StatusOK},
+
+		{name: "user burst", ip: "10.0.0.3", query: "?user_id=u1", wantStatus: http.StatusOK},
+		// 1 token at 0.4 per second is 2.5s away, Retry-After is rounded up
+		{name: "user bucket empty", ip: "10.0.0.4", query: "?user_id=u1", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "3"},
+		{name: "other user", ip: "10.0.0.4", query: "?user_id=u2", wantStatus: http.StatusOK},
+		{name: "user refilled", elapsed: 2500 * time.Millisecond, ip: "10.0.0.5", query: "?user_id=u1", wantStatus: http.StatusOK},
+	}
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	router := newRateLimitedRouter(ratelimit.NewMemoryLimiter(ratelimit.WithClock(clock.Now)), rule)
+	for _, step := range steps {
+		clock.Advance(step.elapsed)
+		w := doRequest(router, step.ip, step.query, "")
+		if w.Code != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", step.name, w.Code, step.wantStatus)
+		}
+		if got := w.Header().Get("Retry-After"); got != step.wantRetryAfter {
+			t.Fatalf("%s: Retry-After = %q, want %q", step.name, got, step.wantRetryAfter)
+		}
+	}
+}
+
+func TestRateLimitFallback(t *testing.T) {
+	rule := config.RateLimitRule{IPRate: 1, IPBurst: 1}
+
+	// a failing limiter lets requests through rather than taking the service down
+	router := newRateLimitedRouter(failingLimiter{}, rule)
+	for i := 0; i < 3; i++ {
+		if w := doRequest(router, "10.0.0.1", "", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d with a failing limiter: status = %d", i, w.Code)
+		}
+	}
+
+	// with the in-process fallback the limits keep holding
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	limiter := ratelimit.NewFallbackLimiter(failingLimiter{}, ratelimit.NewMemoryLimiter(ratelimit.WithClock(clock.Now)), zap.NewNop())
+	router = newRateLimitedRouter(limiter, rule)
+	if w := doRequest(router, "10.0.0.1", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d", w.Code)
+	}
+	if w := doRequest(router, "10.0.0.1", "", ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitThrottlesBodies(t *testing.T) {
+	// the request body is paced to 1000 bytes per second, the first 100 pass at once
+	rule := config.RateLimitRule{UploadRate: 1000, DownloadRate: 1000000, ByteBurst: 100}
+	router := newRateLimitedRouter(ratelimit.NewMemoryLimiter(), rule)
+	body := strings.Repeat("z", 300)
+
+	start := time.Now()
+	w := doRequest(router, "10.0.0.1", "", body)
+	if w.Code != http.StatusOK || w.Body.String() != body {
+		t.Fatalf("status = %d with %d bytes echoed, want 200 with %d", w.Code, w.Body.Len(), len(body))
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("300 bytes were uploaded in %v, faster than the limit", elapsed)
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	router := newRateLimitedRouter(nil, config.RateLimitRule{IPRate: 1, IPBurst: 1})
+	for i := 0; i < 3; i++ {
+		if w := doRequest(router, "10.0.0.1", "", "body"); w.Code != http.StatusOK {
+			t.Fatalf("request %d without limiter: status = %d", i, w.Code)
+		}
+	}
+}
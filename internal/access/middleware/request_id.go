@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// a client supplied id is only kept if it cannot forge log lines or blow up the header
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID takes the request id from X-Request-ID or generates one, echoes it in the response
+// and stores it in the context under "request_id". The request context carries the id and a
+// logger tagged with it, and with the trace id when the request is traced, for
+// utils.LoggerFrom.
+func RequestID(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+		c.Set("request_id", requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		fields := []zap.Field{zap.String("requestID", requestID)}
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			fields = append(fields, zap.String("traceID", sc.TraceID().String()))
+		}
+		ctx := utils.WithRequestID(c.Request.Context(), requestID)
+		ctx = utils.WithLogger(ctx, logger.With(fields...))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
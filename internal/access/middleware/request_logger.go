@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AccessLog writes one line per request through the request logger set by RequestID. The file
+// id of upload and file routes is logged too, so the chunk requests of one upload can be found.
+// Server errors are logged at error level and client errors at warn level.
+func AccessLog(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("route", c.FullPath()),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", status),
+			zap.Duration("latency", time.Since(start)),
+			zap.Int64("bytesIn", max(c.Request.ContentLength, 0)),
+			zap.Int("bytesOut", max(c.Writer.Size(), 0)),
+			zap.String("clientIP", c.ClientIP()),
+		}
+		if userID := c.GetString("user_id"); userID != "" {
+			fields = append(fields, zap.String("userID", userID))
+		}
+		if fileID := c.Param("file_id"); fileID != "" {
+			fields = append(fields, zap.String("fileID", fileID))
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.String("errors", c.Errors.String()))
+		}
+
+		level := zapcore.InfoLevel
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = zapcore.ErrorLevel
+		case status >= http.StatusBadRequest:
+			level = zapcore.WarnLevel
+		}
+		utils.LoggerFrom(c.Request.Context(), logger).Log(level, "request", fields...)
+	}
+}
+
+// Recovery turns a panic in a handler into a 500 response and logs it with the stack
+func Recovery(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				// the client is gone, there is nobody to answer
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+				utils.LoggerFrom(c.Request.Context(), logger).Error("handler panic",
+					zap.Any("panic", r),
+					zap.String("path", c.Request.URL.Path),
+					zap.Stack("stack"))
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			}
+		}()
+		c.Next()
+	}
+}
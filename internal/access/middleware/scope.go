@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"go.uber.org/zap"
+)
+
+// RequireScope lets only credentials holding scope pass, it runs after Authenticate
+func RequireScope(logger *zap.Logger, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checkScope(c, logger, scope)
+	}
+}
+
+// RequireMethodScope requires files:read for GET and HEAD requests and files:write for everything else
+func RequireMethodScope(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := authz.ScopeFilesWrite
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = authz.ScopeFilesRead
+		}
+		checkScope(c, logger, scope)
+	}
+}
+
+// RequireSession rejects api keys, a leaked key must not be able to mint or revoke other keys
+func RequireSession(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("auth_method") != AuthMethodToken {
+			logger.Warn("api key used for a session only endpoint",
+				zap.String("keyID", c.GetString("api_key_id")),
+				zap.String("path", c.FullPath()))
+			c.JSON(http.StatusForbidden, gin.H{"error": "endpoint requires a login session"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func checkScope(c *gin.Context, logger *zap.Logger, scope string) {
+	if !authz.HasScope(c.GetStringSlice("scopes"), scope) {
+		logger.Warn("missing scope",
+			zap.String("userID", c.GetString("user_id")),
+			zap.String("scope", scope),
+			zap.String("path", c.FullPath()))
+		c.JSON(http.StatusForbidden, gin.H{"error": "credential lacks scope " + scope})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
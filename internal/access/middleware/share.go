@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roamBo/BoCloudStore/internal/business/share"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"go.uber.org/zap"
+)
+
+// ShareAccess authorizes requests to /s/:token by the share alone, it replaces Authenticate on those
+// routes. The share's password is sent in the X-Share-Password header. The opened share is
+// stored in the context under "share".
+func ShareAccess(logger *zap.Logger, shareSvc share.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opened, err := shareSvc.Open(c.Request.Context(), c.Param("token"), c.GetHeader("X-Share-Password"))
+		if err != nil {
+			code := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, service.ErrShareNotFound):
+				code = http.StatusNotFound
+			case errors.Is(err, share.ErrShareExpired):
+				code = http.StatusGone
+			case errors.Is(err, share.ErrPasswordRequired),
+				errors.Is(err, share.ErrWrongPassword):
+				code = http.StatusUnauthorized
+			}
+			if code == http.StatusInternalServerError {
+				logger.Error("failed to open share", zap.Error(err))
+			} else {
+				logger.Warn("share access denied", zap.Error(err), zap.String("clientIP", c.ClientIP()))
+			}
+			c.JSON(code, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.Set("share", opened)
+		c.Next()
+	}
+}
@@ -2,37 +2,196 @@ package access
 
 import (
 	"github.com/gin-gonic/gin"
-	"github.com/minio/minio-go/v7"
 	"github.com/roamBo/BoCloudStore/internal/access/handlers"
 	"github.com/roamBo/BoCloudStore/internal/access/middleware"
+	"github.com/roamBo/BoCloudStore/internal/business/apikey"
+	"github.com/roamBo/BoCloudStore/internal/business/auth"
 	"github.com/roamBo/BoCloudStore/internal/business/chunk_upload"
+	"github.com/roamBo/BoCloudStore/internal/business/download"
+	"github.com/roamBo/BoCloudStore/internal/business/namespace"
+	"github.com/roamBo/BoCloudStore/internal/business/quota"
+	"github.com/roamBo/BoCloudStore/internal/business/share"
+	"github.com/roamBo/BoCloudStore/internal/business/trash"
+	"github.com/roamBo/BoCloudStore/internal/business/version"
+	"github.com/roamBo/BoCloudStore/internal/metadata/cache"
 	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/observability/monitoring"
+	"github.com/roamBo/BoCloudStore/internal/security/authn"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/internal/storage"
 	"github.com/roamBo/BoCloudStore/pkg/config"
+	"github.com/roamBo/BoCloudStore/pkg/ratelimit"
 	"go.uber.org/zap"
 )
 
 func SetupRouter(
 	cfg *config.Config,
-	minioClient *minio.Client,
+	objectStore storage.ObjectStore,
 	metadataSvc service.Service,
 	chunkUploadSvc chunk_upload.Service,
+	downloadSvc download.Service,
+	namespaceSvc namespace.Service,
+	trashSvc trash.Service,
+	versionSvc version.Service,
+	shareSvc share.Service,
+	authSvc auth.Service,
+	apiKeySvc apikey.Service,
+	quotaSvc quota.Service,
+	verifier authn.Verifier,
+	revocations cache.RevocationList,
+	authorizer authz.Authorizer,
+	limiter ratelimit.Limiter,
 	logger *zap.Logger,
 ) *gin.Engine {
-	router := gin.Default()
+	if cfg.Env == "production" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	router := gin.New()
+	// 链路追踪在最前, 请求日志才能带上 trace id
+	if cfg.Tracing.Enabled {
+		router.Use(middleware.Tracing())
+	}
+	router.Use(middleware.RequestID(logger), middleware.AccessLog(logger), middleware.Recovery(logger))
+	if cfg.Metrics.Enabled {
+		router.Use(middleware.Metrics())
+		router.GET(cfg.Metrics.Path, gin.WrapH(monitoring.Handler())) // Prometheus 指标
+	}
 
-	healthHandler := handlers.NewHealthHandler(minioClient, logger)
+	healthHandler := handlers.NewHealthHandler(objectStore, logger)
 
 	router.GET("/health", healthHandler.HealthCheck)
 
-	authMiddleware := middleware.JWTAuth(logger, cfg)
+	authMiddleware := middleware.Authenticate(logger, verifier, revocations, apiKeySvc)
+	// api keys are limited to their scopes, GET and HEAD need files:read, everything else files:write
+	scopeMiddleware := middleware.RequireMethodScope(logger)
+	sessionMiddleware := middleware.RequireSession(logger)
+	// 限流, 没有单独规则的路由组使用 default 规则
+	rateLimit := func(group string) gin.HandlerFunc {
+		return middleware.RateLimit(logger, limiter, group, cfg.RateLimit.Rule(group))
+	}
+
+	authHandler := handlers.NewAuthHandler(authSvc, logger)
+	authGroup := router.Group("/auth")
+	authGroup.Use(rateLimit("auth"))
+	{
+		authGroup.POST("/register", authHandler.Register)             // 注册
+		authGroup.POST("/login", authHandler.Login)                   // 登录
+		authGroup.POST("/refresh", authHandler.Refresh)               // 刷新令牌
+		authGroup.POST("/logout", authMiddleware, authHandler.Logout) // 注销
+		authGroup.GET("/me", authMiddleware, authHandler.Me)          // 当前用户
+	}
 
 	uploadGroup := router.Group("/upload")
-	uploadGroup.Use(authMiddleware)
+	uploadGroup.Use(authMiddleware, rateLimit("upload"), scopeMiddleware)
+	{
+		uploadHandler := handlers.NewUploadHandler(chunkUploadSvc, metadataSvc, namespaceSvc, cfg.Upload, cfg.Presign, logger)
+		uploadGroup.POST("/init", uploadHandler.InitUpload)                                 // 初始化上传
+		uploadGroup.POST("/:file_id/chunk/:chunk_id", uploadHandler.UploadChunk)            // 上传分块
+		uploadGroup.POST("/:file_id/merge", uploadHandler.MergeChunks)                      // 合并分块
+		uploadGroup.GET("/:file_id/status", uploadHandler.UploadStatus)                     // 断点续传状态
+		uploadGroup.POST("/:file_id/chunk/:chunk_id/presign", uploadHandler.PresignChunk)   // 分块直传地址
+		uploadGroup.POST("/:file_id/chunk/:chunk_id/complete", uploadHandler.RegisterChunk) // 登记直传分块
+	}
+
+	namespaceHandler := handlers.NewNamespaceHandler(namespaceSvc, logger)
+	fileHandler := handlers.NewFileHandler(metadataSvc, authorizer, logger)
+	trashHandler := handlers.NewTrashHandler(trashSvc, logger)
+	versionHandler := handlers.NewVersionHandler(versionSvc, logger)
+	aclHandler := handlers.NewACLHandler(authorizer, metadataSvc, logger)
+
+	filesGroup := router.Group("/files")
+	filesGroup.Use(authMiddleware, rateLimit("files"), scopeMiddleware)
+	{
+		downloadHandler := handlers.NewDownloadHandler(downloadSvc, cfg.Presign, logger)
+		filesGroup.GET("", fileHandler.ListFiles)                                                    // 文件列表
+		filesGroup.GET("/:file_id", downloadHandler.Download)                                        // 下载文件
+		filesGroup.HEAD("/:file_id", downloadHandler.Download)                                       // 文件元信息
+		filesGroup.POST("/:file_id/presign", downloadHandler.PresignDownload)                        // 下载直链
+		filesGroup.POST("/:file_id/rename", namespaceHandler.RenameFile)                             // 重命名文件
+		filesGroup.POST("/:file_id/move", namespaceHandler.MoveFile)                                 // 移动文件
+		filesGroup.DELETE("/:file_id", trashHandler.DeleteFile)                                      // 移入回收站
+		filesGroup.GET("/:file_id/versions", versionHandler.ListVersions)                            // 历史版本
+		filesGroup.GET("/:file_id/versions/:version", downloadHandler.DownloadVersion)               // 下载历史版本
+		filesGroup.HEAD("/:file_id/versions/:version", downloadHandler.DownloadVersion)              // 历史版本元信息
+		filesGroup.POST("/:file_id/versions/:version/restore", versionHandler.RestoreVersion)        // 回滚版本
+		filesGroup.GET("/:file_id/grants", aclHandler.ListGrants(authz.TypeFile, "file_id"))         // 文件授权列表
+		filesGroup.PUT("/:file_id/grants/:user_id", aclHandler.Grant(authz.TypeFile, "file_id"))     // 授予文件角色
+		filesGroup.DELETE("/:file_id/grants/:user_id", aclHandler.Revoke(authz.TypeFile, "file_id")) // 撤销文件角色
+	}
+
+	foldersGroup := router.Group("/folders")
+	foldersGroup.Use(authMiddleware, rateLimit("default"), scopeMiddleware)
+	{
+		foldersGroup.POST("", namespaceHandler.CreateFolder)                                                 // 创建文件夹
+		foldersGroup.GET("/:folder_id", namespaceHandler.ListFolder)                                         // 列出文件夹内容
+		foldersGroup.POST("/:folder_id/rename", namespaceHandler.RenameFolder)                               // 重命名文件夹
+		foldersGroup.POST("/:folder_id/move", namespaceHandler.MoveFolder)                                   // 移动文件夹
+		foldersGroup.GET("/:folder_id/grants", aclHandler.ListGrants(authz.TypeFolder, "folder_id"))         // 文件夹授权列表
+		foldersGroup.PUT("/:folder_id/grants/:user_id", aclHandler.Grant(authz.TypeFolder, "folder_id"))     // 授予文件夹角色
+		foldersGroup.DELETE("/:folder_id/grants/:user_id", aclHandler.Revoke(authz.TypeFolder, "folder_id")) // 撤销文件夹角色
+	}
+
+	trashGroup := router.Group("/trash")
+	trashGroup.Use(authMiddleware, rateLimit("default"), scopeMiddleware)
+	{
+		trashGroup.GET("", fileHandler.ListTrash)                      // 回收站列表
+		trashGroup.POST("/:file_id/restore", trashHandler.RestoreFile) // 还原文件
+		trashGroup.DELETE("", trashHandler.EmptyTrash)                 // 清空回收站
+	}
+
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeySvc, logger)
+	quotaHandler := handlers.NewQuotaHandler(quotaSvc, logger)
+
+	meGroup := router.Group("/me")
+	meGroup.Use(authMiddleware, rateLimit("default"))
+	{
+		meGroup.GET("/version-policy", scopeMiddleware, versionHandler.GetPolicy)       // 版本保留策略
+		meGroup.PUT("/version-policy", scopeMiddleware, versionHandler.SetPolicy)       // 修改版本保留策略
+		meGroup.GET("/shared", scopeMiddleware, aclHandler.SharedWithMe)                // 共享给我的
+		meGroup.GET("/usage", scopeMiddleware, quotaHandler.MyUsage)                    // 存储用量与配额
+		meGroup.POST("/api-keys", sessionMiddleware, apiKeyHandler.CreateKey)           // 创建 API 密钥
+		meGroup.GET("/api-keys", sessionMiddleware, apiKeyHandler.ListKeys)             // API 密钥列表
+		meGroup.DELETE("/api-keys/:key_id", sessionMiddleware, apiKeyHandler.RevokeKey) // 吊销 API 密钥
+	}
+
+	router.GET("/paths/*path", authMiddleware, rateLimit("default"), scopeMiddleware, namespaceHandler.ResolvePath) // 按路径查找
+
+	adminGroup := router.Group("/admin")
+	adminGroup.Use(authMiddleware, rateLimit("default"), middleware.RequireScope(logger, authz.ScopeAdmin), middleware.RequireAdmin(logger, authorizer))
+	{
+		adminGroup.PUT("/users/:user_id/role", authHandler.SetUserRole)                      // 设置用户角色
+		adminGroup.POST("/api-keys", sessionMiddleware, apiKeyHandler.CreateServiceKey)      // 创建服务密钥
+		adminGroup.GET("/api-keys", apiKeyHandler.ListAllKeys)                               // 全部 API 密钥
+		adminGroup.DELETE("/api-keys/:key_id", sessionMiddleware, apiKeyHandler.RevokeKey)   // 吊销任意密钥
+		adminGroup.GET("/users/:user_id/usage", quotaHandler.UserUsage)                      // 用户存储用量
+		adminGroup.POST("/users/:user_id/usage/recompute", quotaHandler.RecomputeUsage)      // 重新统计用量
+		adminGroup.PUT("/users/:user_id/quota", quotaHandler.SetUserQuota)                   // 设置用户配额
+		adminGroup.DELETE("/users/:user_id/quota", quotaHandler.ResetUserQuota)              // 恢复默认配额
+		adminGroup.GET("/groups/:group", quotaHandler.GetGroup)                              // 用户组信息
+		adminGroup.PUT("/groups/:group/quota", quotaHandler.SetGroupQuota)                   // 设置用户组配额
+		adminGroup.DELETE("/groups/:group/quota", quotaHandler.DeleteGroupQuota)             // 删除用户组配额
+		adminGroup.PUT("/groups/:group/members/:user_id", quotaHandler.AddGroupMember)       // 加入用户组
+		adminGroup.DELETE("/groups/:group/members/:user_id", quotaHandler.RemoveGroupMember) // 移出用户组
+	}
+
+	shareHandler := handlers.NewShareHandler(shareSvc, logger)
+
+	sharesGroup := router.Group("/shares")
+	sharesGroup.Use(authMiddleware, rateLimit("default"), scopeMiddleware)
+	{
+		sharesGroup.POST("", shareHandler.CreateShare)             // 创建分享
+		sharesGroup.GET("", shareHandler.ListShares)               // 分享列表
+		sharesGroup.DELETE("/:share_id", shareHandler.RevokeShare) // 取消分享
+	}
+
+	// 分享链接无需登录, 由分享自身的密码、有效期和下载次数限制访问
+	publicShareGroup := router.Group("/s/:token")
+	publicShareGroup.Use(rateLimit("share"), middleware.ShareAccess(logger, shareSvc))
 	{
-		uploadHandler := handlers.NewUploadHandler(chunkUploadSvc, metadataSvc, logger)
-		uploadGroup.POST("/init", uploadHandler.InitUpload)                      // 初始化上传
-		uploadGroup.POST("/:file_id/chunk/:chunk_id", uploadHandler.UploadChunk) // 上传分块
-		uploadGroup.POST("/:file_id/merge", uploadHandler.MergeChunks)           // 合并分块
+		publicShareGroup.GET("", shareHandler.ShareInfo)                      // 分享信息
+		publicShareGroup.GET("/files/:file_id", shareHandler.DownloadShared)  // 下载分享文件
+		publicShareGroup.HEAD("/files/:file_id", shareHandler.DownloadShared) // 分享文件元信息
+		publicShareGroup.POST("/upload", shareHandler.UploadShared)           // 上传到分享文件夹
 	}
 	return router
 }
@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/cache"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72 //bcrypt ignores everything past 72 bytes
+	refreshTokenBytes = 32
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{2,63}$`)
+
+// for error
+var (
+	ErrInvalidUsername    = errors.New("username must be 3-64 letters, digits, '.', '_' or '-'")
+	ErrInvalidPassword    = errors.New("password must be 8-72 bytes long")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidToken       = errors.New("invalid refresh token")
+	ErrTokenReused        = errors.New("refresh token reuse detected, session revoked")
+	ErrInvalidRole        = errors.New("role must be user or admin")
+)
+
+// Token is a signed access token for the Authenticate middleware and the refresh token to renew it
+type Token struct {
+	AccessToken      string
+	ExpiresAt        time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// Session identifies the access token a request was made with
+type Session struct {
+	UserID    string
+	JTI       string
+	ExpiresAt time.Time
+}
+
+type Service interface {
+	Register(ctx context.Context, username, password string) (*metadata.User, error)
+	Login(ctx context.Context, username, password string) (*metadata.User, *Token, error)
+	GetUser(ctx context.Context, userID string) (*metadata.User, error)
+	// Refresh trades a refresh token for a new token pair. A refresh token can be used once,
+	// presenting it again revokes every token rotated from the same login.
+	Refresh(ctx context.Context, refreshToken string) (*Token, error)
+	// Logout revokes the session's access token and the refresh token family of refreshToken,
+	// with all every session of the user is revoked
+	Logout(ctx context.Context, session *Session, refreshToken string, all bool) error
+	// SetUserRole makes a user an admin or a regular user
+	SetUserRole(ctx context.Context, userID, role string) (*metadata.User, error)
+}
+
+type authService struct {
+	metadataSvc   service.Service
+	revocations   cache.RevocationList
+	logger        *zap.Logger
+	secret        []byte
+	expiry        time.Duration
+	refreshExpiry time.Duration
+	// compared against when the user does not exist, so unknown usernames take as long as wrong passwords
+	dummyHash []byte
+}
+
+func NewService(metadataSvc service.Service, revocations cache.RevocationList, logger *zap.Logger, cfg config.JWTConfig) Service {
+	expiry := time.Duration(cfg.Expiry) * time.Hour
+	if expiry <= 0 {
+		expiry = 24 * time.Hour
+	}
+	refreshExpiry := time.Duration(cfg.RefreshExpiry) * time.Hour
+	if refreshExpiry <= 0 {
+		refreshExpiry = 30 * 24 * time.Hour
+	}
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte(uuid.NewString()), bcrypt.DefaultCost)
+	return &authService{
+		metadataSvc:   metadataSvc,
+		revocations:   revocations,
+		logger:        logger,
+		secret:        []byte(cfg.Secret),
+		expiry:        expiry,
+		refreshExpiry: refreshExpiry,
+		dummyHash:     dummyHash,
+	}
+}
+
+// NormalizeUsername makes usernames case insensitive
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func (s *authService) Register(ctx context.Context, username, password string) (*metadata.User, error) {
+	username = NormalizeUsername(username)
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return nil, ErrInvalidPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user := &metadata.User{
+		UserID:       uuid.NewString(),
+		Username:     username,
+		PasswordHash: string(hash),
+	}
+	if err := s.metadataSvc.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Login checks the credentials and issues an access token whose subject is the user id
+func (s *authService) Login(ctx context.Context, username, password string) (*metadata.User, *Token, error) {
+	user, err := s.metadataSvc.GetUserByName(ctx, NormalizeUsername(username))
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			_ = bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+			return nil, nil, ErrInvalidCredentials
+		}
+		return nil, nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		utils.LoggerFrom(ctx, s.logger).Warn("login failed", zap.String("userID", user.UserID))
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	token, refresh, err := s.issueTokens(user.UserID, uuid.NewString())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.metadataSvc.CreateRefreshToken(ctx, refresh); err != nil {
+		return nil, nil, err
+	}
+	utils.LoggerFrom(ctx, s.logger).Info("user logged in", zap.String("userID", user.UserID), zap.String("familyID", refresh.FamilyID))
+	return user, token, nil
+}
+
+func (s *authService) GetUser(ctx context.Context, userID string) (*metadata.User, error) {
+	return s.metadataSvc.GetUser(ctx, userID)
+}
+
+func (s *authService) SetUserRole(ctx context.Context, userID, role string) (*metadata.User, error) {
+	if role != metadata.UserRoleUser && role != metadata.UserRoleAdmin {
+		return nil, ErrInvalidRole
+	}
+	if err := s.metadataSvc.SetUserRole(ctx, userID, role); err != nil {
+		return nil, err
+	}
+	return s.metadataSvc.GetUser(ctx, userID)
+}
+
+func (s *authService) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	current, err := s.metadataSvc.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, service.ErrRefreshTokenNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if current.UsedAt != 0 || current.RevokedAt != 0 {
+		return nil, s.handleReuse(ctx, current)
+	}
+	if time.Now().Unix() >= current.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+
+	token, next, err := s.issueTokens(current.UserID, current.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.metadataSvc.RotateRefreshToken(ctx, current.TokenID, next); err != nil {
+		// a concurrent refresh won the race for this token, which is reuse as well
+		if errors.Is(err, service.ErrRefreshTokenUsed) {
+			return nil, s.handleReuse(ctx, current)
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *authService) Logout(ctx context.Context, session *Session, refreshToken string, all bool) error {
+	if session.JTI != "" {
+		if err := s.revocations.Revoke(ctx, session.JTI, time.Until(session.ExpiresAt)); err != nil {
+			return err
+		}
+	}
+
+	var revoked []*metadata.RefreshToken
+	switch {
+	case all:
+		tokens, err := s.metadataSvc.RevokeUserTokens(ctx, session.UserID)
+		if err != nil {
+			return err
+		}
+		revoked = tokens
+	case refreshToken != "":
+		current, err := s.metadataSvc.GetRefreshToken(ctx, hashToken(refreshToken))
+		if err != nil {
+			if errors.Is(err, service.ErrRefreshTokenNotFound) {
+				return ErrInvalidToken
+			}
+			return err
+		}
+		if current.UserID != session.UserID {
+			return ErrInvalidToken
+		}
+		tokens, err := s.metadataSvc.RevokeTokenFamily(ctx, current.FamilyID)
+		if err != nil {
+			return err
+		}
+		revoked = tokens
+	}
+	utils.LoggerFrom(ctx, s.logger).Info("user logged out", zap.String("userID", session.UserID), zap.Bool("all", all))
+	return s.revokeAccessTokens(ctx, revoked)
+}
+
+// handleReuse revokes the family of a refresh token that was presented after it had been used,
+// either the legitimate client or an attacker holds a stolen copy and there is no telling which
+func (s *authService) handleReuse(ctx context.Context, reused *metadata.RefreshToken) error {
+	utils.LoggerFrom(ctx, s.logger).Warn("refresh token reuse detected",
+		zap.String("userID", reused.UserID),
+		zap.String("familyID", reused.FamilyID))
+	revoked, err := s.metadataSvc.RevokeTokenFamily(ctx, reused.FamilyID)
+	if err != nil {
+		return err
+	}
+	if err := s.revokeAccessTokens(ctx, revoked); err != nil {
+		return err
+	}
+	return ErrTokenReused
+}
+
+// revokeAccessTokens blocks the access tokens issued together with the given refresh tokens
+// for the rest of their lifetime
+func (s *authService) revokeAccessTokens(ctx context.Context, tokens []*metadata.RefreshToken) error {
+	for _, token := range tokens {
+		accessExpiresAt := time.Unix(token.CreateAt, 0).Add(s.expiry)
+		if err := s.revocations.Revoke(ctx, token.AccessJTI, time.Until(accessExpiresAt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// issueTokens signs an access token and creates the refresh token issued with it in familyID
+func (s *authService) issueTokens(userID, familyID string) (*Token, *metadata.RefreshToken, error) {
+	now := time.Now()
+	jti := uuid.NewString()
+	signed, expiresAt, err := s.signAccessToken(userID, jti, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, nil, err
+	}
+	rawRefresh := base64.RawURLEncoding.EncodeToString(buf)
+	refresh := &metadata.RefreshToken{
+		TokenID:   uuid.NewString(),
+		TokenHash: hashToken(rawRefresh),
+		FamilyID:  familyID,
+		UserID:    userID,
+		AccessJTI: jti,
+		ExpiresAt: now.Add(s.refreshExpiry).Unix(),
+		CreateAt:  now.Unix(),
+	}
+	return &Token{
+		AccessToken:      signed,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     rawRefresh,
+		RefreshExpiresAt: time.Unix(refresh.ExpiresAt, 0),
+	}, refresh, nil
+}
+
+func (s *authService) signAccessToken(userID, jti string, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(s.expiry)
+	claims := jwt.RegisteredClaims{
+		ID:        jti,
+		Subject:   userID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// refresh tokens are random, an unsalted sha256 is enough to keep them useless when the table leaks
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,160 @@
+package chunk_upload
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/storage"
+	"github.com/roamBo/BoCloudStore/pkg/pool"
+	"go.uber.org/zap"
+)
+
+// chunkResult is the outcome of reading one chunk in the worker pool
+type chunkResult struct {
+	buf *[]byte
+	n   int64
+	err error
+}
+
+// mergePipeline assembles the chunks into destPath in three stages:
+//  1. a producer submits chunk reads to the worker pool, at most mergeWindow chunks ahead of the writer
+//  2. pool workers read each chunk into a pooled buffer and verify its md5
+//  3. a writer consumes the buffers strictly in chunk order and streams them into a single object
+//
+// Any failure cancels the whole pipeline and the partially written object is removed.
+// The returned digest is the sha256 (hex) of the assembled content.
+func (s *chunkUploadService) mergePipeline(
+	ctx context.Context,
+	destPath string,
+	chunks []*metadata.ChunkMetadata,
+	totalSize int64,
+) (*storage.ObjectInfo, string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan chunkResult, len(chunks))
+	for i := range results {
+		results[i] = make(chan chunkResult, 1)
+	}
+	window := make(chan struct{}, s.mergeWindow)
+	pr, pw := io.Pipe()
+	var wg sync.WaitGroup
+
+	// stage 1: fan the reads out to the worker pool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, chunk := range chunks {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			result := results[i]
+			chunk := chunk
+			err := s.workerPool.SubmitWait(ctx, func(context.Context) (err error) {
+				// the writer waits for this result, it has to be sent even if the read panics
+				var res chunkResult
+				defer func() {
+					if r := recover(); r != nil {
+						res = chunkResult{err: fmt.Errorf("read chunk %d: panic: %v", chunk.ChunkID, r)}
+						err = res.err
+					}
+					result <- res
+				}()
+				res = s.readChunk(ctx, chunk)
+				return res.err
+			})
+			if err != nil {
+				result <- chunkResult{err: err}
+				return
+			}
+		}
+	}()
+
+	// stage 3: write the chunks in sequence
+	hash := sha256.New()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range chunks {
+			var res chunkResult
+			select {
+			case res = <-results[i]:
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			case <-s.workerPool.Done():
+				// queued reads are dropped by a pool that shuts down
+				cancel()
+				pw.CloseWithError(pool.ErrPoolClosed)
+				return
+			}
+			if res.err != nil {
+				cancel()
+				pw.CloseWithError(res.err)
+				return
+			}
+			data := (*res.buf)[:res.n]
+			hash.Write(data)
+			_, err := pw.Write(data)
+			s.bufferPool.Put(res.buf)
+			<-window
+			if err != nil {
+				cancel()
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	info, err := s.objectStore.Put(ctx, destPath, pr, totalSize)
+	pr.CloseWithError(err)
+	if err != nil {
+		cancel()
+	}
+	wg.Wait()
+	if err != nil {
+		s.removeObject(context.WithoutCancel(ctx), destPath)
+		return nil, "", err
+	}
+	return info, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readChunk reads a whole chunk into a buffer from the pool and checks it against the md5 recorded at upload
+func (s *chunkUploadService) readChunk(ctx context.Context, chunk *metadata.ChunkMetadata) chunkResult {
+	r, _, err := s.objectStore.Get(ctx, chunk.StoragePath, 0, -1)
+	if err != nil {
+		return chunkResult{err: err}
+	}
+	defer r.Close()
+
+	buf := s.bufferPool.Get().(*[]byte)
+	if int64(cap(*buf)) < chunk.Size {
+		// chunk size is chosen per upload, the pool only holds buffers of the default size
+		s.bufferPool.Put(buf)
+		larger := make([]byte, chunk.Size)
+		buf = &larger
+	}
+	*buf = (*buf)[:cap(*buf)]
+	n, err := io.ReadFull(r, (*buf)[:chunk.Size])
+	if err != nil {
+		s.bufferPool.Put(buf)
+		return chunkResult{err: fmt.Errorf("read chunk %d: %w", chunk.ChunkID, err)}
+	}
+	sum := md5.Sum((*buf)[:n])
+	if hex.EncodeToString(sum[:]) != chunk.ETag {
+		s.bufferPool.Put(buf)
+		s.log(ctx).Warn("chunk content does not match its md5",
+			zap.String("fileID", chunk.FileID),
+			zap.Int("chunkID", chunk.ChunkID))
+		return chunkResult{err: fmt.Errorf("%w: chunk %d", ErrChunkCorrupted, chunk.ChunkID)}
+	}
+	return chunkResult{buf: buf, n: int64(n)}
+}
@@ -0,0 +1,130 @@
+package chunk_upload
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/storage"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"github.com/roamBo/BoCloudStore/pkg/pool"
+	"go.uber.org/zap"
+)
+
+// Reaper periodically expires uploads that were initialized but never merged,
+// removing their chunk objects and chunk records
+type Reaper struct {
+	metadataSvc service.Service
+	objectStore storage.ObjectStore
+	workerPool  *pool.WorkerPool
+	logger      *zap.Logger
+	cfg         config.ReaperConfig
+	stop        chan struct{}
+	wg          sync.WaitGroup
+	sweeping    atomic.Bool //a sweep is queued or running
+}
+
+func NewReaper(
+	metadataSvc service.Service,
+	objectStore storage.ObjectStore,
+	workerPool *pool.WorkerPool,
+	logger *zap.Logger,
+	cfg config.ReaperConfig,
+) *Reaper {
+	return &Reaper{
+		metadataSvc: metadataSvc,
+		objectStore: objectStore,
+		workerPool:  workerPool,
+		logger:      logger,
+		cfg:         cfg,
+		stop:        make(chan struct{}),
+	}
+}
+
+// Start schedules a sweep on the worker pool every interval until Stop is called
+func (r *Reaper) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				// a sweep still queued or running from the last tick makes this one redundant
+				if !r.sweeping.CompareAndSwap(false, true) {
+					continue
+				}
+				err := r.workerPool.Submit(func(ctx context.Context) error {
+					defer r.sweeping.Store(false)
+					return r.Sweep(ctx)
+				})
+				if err != nil {
+					r.sweeping.Store(false)
+					r.logger.Warn("failed to schedule upload reaper", zap.Error(err))
+				}
+			}
+		}
+	}()
+	r.logger.Info("Upload reaper started",
+		zap.Duration("interval", r.cfg.Interval),
+		zap.Duration("uploadTTL", r.cfg.UploadTTL))
+}
+
+func (r *Reaper) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+// Sweep expires one batch of abandoned uploads
+func (r *Reaper) Sweep(ctx context.Context) error {
+	files, err := r.metadataSvc.ClaimStaleUploads(ctx, time.Now().Add(-r.cfg.UploadTTL), r.cfg.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, file := range files {
+		if err := r.expire(ctx, file); err != nil {
+			failed++
+			r.logger.Warn("failed to expire upload",
+				zap.Error(err),
+				zap.String("fileID", file.FileID))
+		}
+	}
+	if len(files) > 0 {
+		r.logger.Info("Expired abandoned uploads",
+			zap.Int("claimed", len(files)),
+			zap.Int("failed", failed))
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to expire %d of %d uploads", failed, len(files))
+	}
+	return nil
+}
+
+// expire deletes the chunks of an upload and releases the quota it reserved. A failed upload stays
+// in expiring state and is claimed again once the claim goes stale.
+func (r *Reaper) expire(ctx context.Context, file *metadata.FileMetadata) error {
+	// chunks are listed from storage as well, a chunk may be stored without its record
+	objects, err := r.objectStore.List(ctx, ChunkPrefix(file.UserID, file.FileID))
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := r.objectStore.Delete(ctx, obj.Key); err != nil {
+			return err
+		}
+	}
+	if err := r.metadataSvc.DeleteAllChunkMetadata(ctx, file.FileID); err != nil {
+		return err
+	}
+	return r.metadataSvc.ExpireUpload(ctx, file.FileID)
+}
@@ -3,56 +3,205 @@ package chunk_upload
 import (
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"hash"
 	"io"
-	"sort"
 	"sync"
 	"time"
 
-	"github.com/minio/minio-go/v7"
+	"github.com/google/uuid"
 	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/observability/monitoring"
 	"github.com/roamBo/BoCloudStore/internal/storage"
 	"github.com/roamBo/BoCloudStore/pkg/pool"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
 	"go.uber.org/zap"
 )
 
+// for error
+var (
+	ErrAlreadyMerged    = errors.New("file already merged")
+	ErrUploadNotActive  = errors.New("upload is not in progress")
+	ErrInvalidChunkID   = errors.New("chunk id out of range")
+	ErrChunkTooLarge    = errors.New("chunk exceeds declared size")
+	ErrChunkIncomplete  = errors.New("chunk is smaller than declared size")
+	ErrChunkMissing     = errors.New("chunk count mismatch")
+	ErrFileSizeMismatch = errors.New("file size mismatch")
+	ErrHashMismatch     = errors.New("content hash mismatch")
+	ErrChecksumMismatch = errors.New("chunk checksum mismatch")
+	ErrChunkCorrupted   = errors.New("stored chunk changed since upload")
+)
+
+// ChunkChecksum holds the digests supplied by the client for one chunk (hex encoded),
+// an empty field is not verified
+type ChunkChecksum struct {
+	MD5    string
+	SHA256 string
+}
+
+// UploadStatus describes which chunks of an upload are already stored,
+// so that an interrupted upload can be resumed by sending only the missing ones
+type UploadStatus struct {
+	File     *metadata.FileMetadata
+	Uploaded []int
+	Missing  []int
+}
+
+// chunkDigest hashes a chunk body while it is written, sha256 is only computed when the client supplied one
+type chunkDigest struct {
+	io.Writer
+	md5      hash.Hash
+	sha256   hash.Hash
+	checksum ChunkChecksum
+}
+
+func newChunkDigest(checksum ChunkChecksum) *chunkDigest {
+	d := &chunkDigest{md5: md5.New(), checksum: checksum}
+	d.Writer = d.md5
+	if checksum.SHA256 != "" {
+		d.sha256 = sha256.New()
+		d.Writer = io.MultiWriter(d.md5, d.sha256)
+	}
+	return d
+}
+
+// verify returns the md5 (hex) of the content and whether it matches the client checksums
+func (d *chunkDigest) verify() (string, bool) {
+	md5Sum := hex.EncodeToString(d.md5.Sum(nil))
+	if d.checksum.MD5 != "" && d.checksum.MD5 != md5Sum {
+		return md5Sum, false
+	}
+	if d.sha256 != nil && d.checksum.SHA256 != hex.EncodeToString(d.sha256.Sum(nil)) {
+		return md5Sum, false
+	}
+	return md5Sum, true
+}
+
 type Service interface {
-	UploadChunk(ctx context.Context, fileID string, chunkID int, data io.Reader, userID string) (*metadata.ChunkMetadata, error)
-	MergeChunks(ctx context.Context, fileID string, userID string) error
+	InstantUpload(ctx context.Context, file *metadata.FileMetadata, userID string) (bool, error)
+	GetUploadStatus(ctx context.Context, fileID string, userID string) (*UploadStatus, error)
+	UploadChunk(ctx context.Context, fileID string, chunkID int, data io.Reader, checksum ChunkChecksum, userID string) (*metadata.ChunkMetadata, error)
+	PresignChunkUpload(ctx context.Context, fileID string, chunkID int, userID string, expiry time.Duration) (string, error)
+	RegisterChunk(ctx context.Context, fileID string, chunkID int, checksum ChunkChecksum, userID string) (*metadata.ChunkMetadata, error)
+	MergeChunks(ctx context.Context, fileID string, userID string) (*metadata.FileMetadata, error)
 }
 
 type chunkUploadService struct {
-	metadataSvc service.Service  //metadata service
-	minioClient *minio.Client    //minio client
-	bufferPool  *sync.Pool       //memory pool(for optimize performance)
-	workerPool  *pool.WorkerPool //goroutines pool(for union chunk)
+	metadataSvc service.Service     //metadata service
+	authorizer  authz.Authorizer    //access control
+	objectStore storage.ObjectStore //object storage engine
+	bufferPool  *sync.Pool          //memory pool(for optimize performance)
+	workerPool  *pool.WorkerPool    //goroutines pool(for union chunk)
 	logger      *zap.Logger
 	chunkSize   int64 //chunk size
+	mergeWindow int   //chunks read ahead of the merge writer
+}
+
+// log is the logger of the request ctx belongs to, see utils.LoggerFrom
+func (s *chunkUploadService) log(ctx context.Context) *zap.Logger {
+	return utils.LoggerFrom(ctx, s.logger)
 }
 
 func NewService(
 	metadataSvc service.Service,
-	minioClient *minio.Client,
+	authorizer authz.Authorizer,
+	objectStore storage.ObjectStore,
 	workerPool *pool.WorkerPool,
 	logger *zap.Logger,
 	chunkSize int64,
+	mergeWindow int,
 ) Service {
+	if mergeWindow <= 0 {
+		mergeWindow = 1
+	}
 	return &chunkUploadService{
 		metadataSvc: metadataSvc,
-		minioClient: minioClient,
+		authorizer:  authorizer,
+		objectStore: objectStore,
 		bufferPool: &sync.Pool{
 			New: func() interface{} {
 				buf := make([]byte, chunkSize)
 				return &buf
 			},
 		},
-		workerPool: workerPool,
-		logger:     logger,
-		chunkSize:  chunkSize,
+		workerPool:  workerPool,
+		logger:      logger,
+		chunkSize:   chunkSize,
+		mergeWindow: mergeWindow,
+	}
+}
+
+// InstantUpload (秒传) creates file as a merged file sharing an already stored object
+// with the same content hash and size. It reports false when no such object exists,
+// in which case the caller falls back to a regular chunked upload.
+// Only the uploading user's own files are matched: the client supplied hash proves nothing,
+// so matching other users' objects would hand out content the caller never had.
+func (s *chunkUploadService) InstantUpload(ctx context.Context, file *metadata.FileMetadata, userID string) (bool, error) {
+	// a file created for another user than the uploader would match that user's files
+	if file.ContentHash == "" || file.UserID != userID {
+		return false, nil
+	}
+	existing, err := s.metadataSvc.FindFileByContentHash(ctx, userID, file.ContentHash, file.TotalSize)
+	if err != nil {
+		if errors.Is(err, service.ErrFileNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	file.Status = metadata.StatusMerged
+	file.StoragePath = existing.StoragePath
+	file.ETag = existing.ETag
+	result, orphans, err := s.metadataSvc.CreateFileReference(ctx, file)
+	if err != nil {
+		// the object was released in the meantime
+		if errors.Is(err, service.ErrObjectNotFound) {
+			file.Status, file.StoragePath, file.ETag = "", "", ""
+			return false, nil
+		}
+		return false, err
 	}
+	s.removeObjects(ctx, orphans)
+	// an existing file with the same name got the content as a new version
+	*file = *result
+	return true, nil
+}
+
+func (s *chunkUploadService) GetUploadStatus(ctx context.Context, fileID string, userID string) (*UploadStatus, error) {
+	fileMeta, err := s.metadataSvc.GetFileMetadata(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizer.Authorize(ctx, authz.User(userID), authz.ActionWrite, authz.FileResource(fileMeta)); err != nil {
+		return nil, err
+	}
+	chunks, err := s.metadataSvc.ListChunkMetadata(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[int]bool, len(chunks))
+	for _, chunk := range chunks {
+		stored[chunk.ChunkID] = true
+	}
+	status := &UploadStatus{
+		File:     fileMeta,
+		Uploaded: make([]int, 0, len(chunks)),
+		Missing:  make([]int, 0),
+	}
+	for i := 0; i < fileMeta.ChunkCount; i++ {
+		if stored[i] {
+			status.Uploaded = append(status.Uploaded, i)
+		} else {
+			status.Missing = append(status.Missing, i)
+		}
+	}
+	return status, nil
 }
 
 func (s *chunkUploadService) UploadChunk(
@@ -60,25 +209,32 @@ func (s *chunkUploadService) UploadChunk(
 	fileID string,
 	chunkID int,
 	data io.Reader,
+	checksum ChunkChecksum,
 	userID string,
-) (*metadata.ChunkMetadata, error) {
+) (chunkMeta *metadata.ChunkMetadata, err error) {
+	start := time.Now()
+	defer func() {
+		var size int64
+		if chunkMeta != nil {
+			size = chunkMeta.Size
+		}
+		monitoring.ObserveChunkUpload(size, time.Since(start), err)
+	}()
 	// 1. verify if file metadata exists (ensure initialized upload)
-	fileMeta, err := s.metadataSvc.GetFileMetadata(ctx, fileID)
+	fileMeta, err := s.chunkTarget(ctx, fileID, chunkID, userID)
 	if err != nil {
 		return nil, err
 	}
-	if fileMeta.UserID != userID {
-		return nil, errors.New("permission denied: not file owner")
-	}
-	// 2.calculate chunk hash (for verification)
-	hash := md5.New()
-	tee := io.TeeReader(data, hash)
+	expectedSize := expectedChunkSize(fileMeta, chunkID)
+	// 2.calculate chunk hash (for verification), reading one byte past the declared size to detect oversize bodies
+	digest := newChunkDigest(checksum)
+	tee := io.TeeReader(io.LimitReader(data, expectedSize+1), digest)
 
 	// 3.store chunks to MinIO (path:{UserID}/{fileID}/chunk_{chunkID}
-	storagePath := fmt.Sprintf("%s%s/chunk_%d", userID, fileID, chunkID)
-	_, err = s.minioClient.PutObject(ctx, "cloudstor", storagePath, tee, -1, minio.PutObjectOptions{})
+	storagePath := chunkPath(fileMeta.UserID, fileID, chunkID)
+	info, err := s.objectStore.Put(ctx, storagePath, tee, -1)
 	if err != nil {
-		s.logger.Error("failed to upload chunk to minio",
+		s.log(ctx).Error("failed to upload chunk to object store",
 			zap.Error(err),
 			zap.String("fileID", fileID),
 			zap.Int("chunkID", chunkID))
@@ -86,11 +242,110 @@ func (s *chunkUploadService) UploadChunk(
 	}
 
 	// 4. record chunks metadata
+	return s.recordChunk(ctx, fileMeta, chunkID, info, digest)
+}
+
+// PresignChunkUpload returns a url the client can PUT the chunk to directly,
+// the chunk has to be registered with RegisterChunk afterwards
+func (s *chunkUploadService) PresignChunkUpload(
+	ctx context.Context,
+	fileID string,
+	chunkID int,
+	userID string,
+	expiry time.Duration,
+) (string, error) {
+	fileMeta, err := s.chunkTarget(ctx, fileID, chunkID, userID)
+	if err != nil {
+		return "", err
+	}
+	return s.objectStore.PresignPut(ctx, chunkPath(fileMeta.UserID, fileID, chunkID), expiry)
+}
+
+// RegisterChunk verifies a chunk written through a presigned url and records its metadata,
+// the chunk is read back once since the object store does not give us a reliable md5
+func (s *chunkUploadService) RegisterChunk(
+	ctx context.Context,
+	fileID string,
+	chunkID int,
+	checksum ChunkChecksum,
+	userID string,
+) (*metadata.ChunkMetadata, error) {
+	fileMeta, err := s.chunkTarget(ctx, fileID, chunkID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	storagePath := chunkPath(fileMeta.UserID, fileID, chunkID)
+	body, info, err := s.objectStore.Get(ctx, storagePath, 0, -1)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, ErrChunkMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	digest := newChunkDigest(checksum)
+	if info.Size <= expectedChunkSize(fileMeta, chunkID) {
+		if _, err := io.Copy(digest, body); err != nil {
+			return nil, err
+		}
+	}
+	return s.recordChunk(ctx, fileMeta, chunkID, info, digest)
+}
+
+// chunkTarget loads the upload a chunk belongs to and checks that the chunk may be stored
+func (s *chunkUploadService) chunkTarget(ctx context.Context, fileID string, chunkID int, userID string) (*metadata.FileMetadata, error) {
+	fileMeta, err := s.metadataSvc.GetFileMetadata(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizer.Authorize(ctx, authz.User(userID), authz.ActionWrite, authz.FileResource(fileMeta)); err != nil {
+		return nil, err
+	}
+	if fileMeta.Status != metadata.StatusUploading {
+		return nil, ErrUploadNotActive
+	}
+	if chunkID < 0 || chunkID >= fileMeta.ChunkCount {
+		return nil, ErrInvalidChunkID
+	}
+	return fileMeta, nil
+}
+
+// recordChunk checks a stored chunk against its declared size and the client checksums,
+// then saves its metadata. A rejected chunk is removed.
+func (s *chunkUploadService) recordChunk(
+	ctx context.Context,
+	fileMeta *metadata.FileMetadata,
+	chunkID int,
+	info *storage.ObjectInfo,
+	digest *chunkDigest,
+) (*metadata.ChunkMetadata, error) {
+	fileID := fileMeta.FileID
+	expectedSize := expectedChunkSize(fileMeta, chunkID)
+	if info.Size != expectedSize {
+		s.discardChunk(ctx, fileID, chunkID, info.Key)
+		if info.Size > expectedSize {
+			return nil, ErrChunkTooLarge
+		}
+		return nil, ErrChunkIncomplete
+	}
+	md5Sum, ok := digest.verify()
+	if !ok {
+		s.log(ctx).Warn("chunk checksum mismatch",
+			zap.String("fileID", fileID),
+			zap.Int("chunkID", chunkID))
+		s.discardChunk(ctx, fileID, chunkID, info.Key)
+		return nil, ErrChecksumMismatch
+	}
+
 	chunkMeta := &metadata.ChunkMetadata{
 		FileID:      fileID,
 		ChunkID:     chunkID,
-		ETag:        hex.EncodeToString(hash.Sum(nil)),
-		StoragePath: storagePath,
+		ETag:        md5Sum,
+		ObjectETag:  info.ETag,
+		Size:        info.Size,
+		StoragePath: info.Key,
 	}
 	if err := s.metadataSvc.SaveChunkMetadata(ctx, chunkMeta); err != nil {
 		return nil, err
@@ -98,32 +353,157 @@ func (s *chunkUploadService) UploadChunk(
 	return chunkMeta, nil
 }
 
-func (s *chunkUploadService) MergeChunks(ctx context.Context, fileID string, userID string) error {
+func (s *chunkUploadService) MergeChunks(ctx context.Context, fileID string, userID string) (*metadata.FileMetadata, error) {
 	// 1. verify file status and permissions
 	fileMeta, err := s.metadataSvc.GetFileMetadata(ctx, fileID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if fileMeta.Status == metadata.StatusMerged {
+		return nil, ErrAlreadyMerged
 	}
-	if fileMeta.Status == "merged" {
-		return errors.New("file already merged")
+	if err := s.authorizer.Authorize(ctx, authz.User(userID), authz.ActionWrite, authz.FileResource(fileMeta)); err != nil {
+		return nil, err
 	}
-	if fileMeta.UserID != userID {
-		return errors.New("permission denied: not file owner")
+	if fileMeta.Status != metadata.StatusUploading {
+		return nil, ErrUploadNotActive
 	}
-	// 2. retrieve all chunk metadata (sorted by sequence number)
-	chunks, err := s.metadataSvc.GetAll
+	// 2. retrieve all chunk metadata (sorted by sequence number) and check the total size
+	chunks, err := s.metadataSvc.ListChunkMetadata(ctx, fileID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(chunks) != fileMeta.ChunkCount {
-		return errors.New("chunk count mismatch")
+		return nil, ErrChunkMissing
 	}
-	sort.Slice(chunks, func(i, j int) bool {
-		return chunks[i].ChunkID < chunks[j].ChunkID
-	})
-	// 3. merge partitions (using goroutines pool to read partitions in parallel and write them to the target file in sequence)
-	destPath := fmt.Sprintf("%s%s/chunk_%d", userID, fileID, fileMeta.ChunkCount)
-	// 4. update file status as merged
+	var totalSize int64
+	for i, chunk := range chunks {
+		if chunk.ChunkID != i {
+			return nil, ErrChunkMissing
+		}
+		totalSize += chunk.Size
+	}
+	if totalSize != fileMeta.TotalSize {
+		s.log(ctx).Warn("merged size does not match declared size",
+			zap.String("fileID", fileID),
+			zap.Int64("expected", fileMeta.TotalSize),
+			zap.Int64("actual", totalSize))
+		return nil, ErrFileSizeMismatch
+	}
+	// 3. make sure no chunk was replaced or truncated since it was verified at upload
+	if err := s.verifyStoredChunks(ctx, chunks); err != nil {
+		return nil, err
+	}
+	// 4. merge partitions (using goroutines pool to read partitions in parallel and write them to the target file in sequence)
+	destPath := objectPath(fileMeta.UserID, fileID)
+	start := time.Now()
+	info, contentHash, err := s.mergePipeline(ctx, destPath, chunks, totalSize)
+	monitoring.ObserveMerge(totalSize, time.Since(start), err)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, ErrChunkMissing
+	}
+	if err != nil {
+		s.log(ctx).Error("failed to merge chunks",
+			zap.Error(err),
+			zap.String("fileID", fileID),
+			zap.String("destPath", destPath))
+		return nil, err
+	}
+	if info.Size != fileMeta.TotalSize {
+		s.removeObject(ctx, destPath)
+		return nil, ErrFileSizeMismatch
+	}
+	// the client supplied hash makes the object eligible for instant upload, so it must be trustworthy
+	if fileMeta.ContentHash != "" && fileMeta.ContentHash != contentHash {
+		s.removeObject(ctx, destPath)
+		return nil, ErrHashMismatch
+	}
+	// 5. update file status as merged
+	// a merged file with the same name keeps its id and gets the content as a new version
+	merged, orphans, err := s.metadataSvc.CompleteFile(ctx, fileID, destPath, info.ETag)
+	if err != nil {
+		// the object belongs to this attempt alone, nothing else would ever remove it. The chunks are
+		// kept, so the upload can be merged again (after a rename on a name conflict).
+		s.removeObject(ctx, destPath)
+		// a concurrent merge completed the upload first
+		if errors.Is(err, service.ErrUploadNotActive) {
+			return nil, ErrUploadNotActive
+		}
+		return nil, err
+	}
+	// 6. chunks are no longer needed once the final object exists, nor are pruned versions
+	for _, chunk := range chunks {
+		s.removeObject(ctx, chunk.StoragePath)
+	}
+	s.removeObjects(ctx, orphans)
+	return merged, nil
+}
+
+func (s *chunkUploadService) verifyStoredChunks(ctx context.Context, chunks []*metadata.ChunkMetadata) error {
+	for _, chunk := range chunks {
+		info, err := s.objectStore.Stat(ctx, chunk.StoragePath)
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return ErrChunkMissing
+		}
+		if err != nil {
+			return err
+		}
+		if info.Size != chunk.Size || (chunk.ObjectETag != "" && info.ETag != chunk.ObjectETag) {
+			s.log(ctx).Warn("stored chunk does not match its metadata",
+				zap.String("fileID", chunk.FileID),
+				zap.Int("chunkID", chunk.ChunkID),
+				zap.String("expectedETag", chunk.ObjectETag),
+				zap.String("actualETag", info.ETag))
+			return fmt.Errorf("%w: chunk %d", ErrChunkCorrupted, chunk.ChunkID)
+		}
+	}
+	return nil
+}
+
+// discardChunk removes a rejected chunk, including the record of an earlier upload it replaced
+func (s *chunkUploadService) discardChunk(ctx context.Context, fileID string, chunkID int, storagePath string) {
+	s.removeObject(ctx, storagePath)
+	if err := s.metadataSvc.DeleteChunkMetadata(ctx, fileID, chunkID); err != nil {
+		s.log(ctx).Warn("failed to delete rejected chunk metadata",
+			zap.Error(err),
+			zap.String("fileID", fileID),
+			zap.Int("chunkID", chunkID))
+	}
+}
+
+func (s *chunkUploadService) removeObject(ctx context.Context, path string) {
+	if err := s.objectStore.Delete(ctx, path); err != nil {
+		s.log(ctx).Warn("failed to remove object from object store",
+			zap.Error(err),
+			zap.String("path", path))
+	}
+}
+
+// removeObjects deletes objects no file or version references anymore
+func (s *chunkUploadService) removeObjects(ctx context.Context, paths []string) {
+	for _, path := range paths {
+		s.removeObject(ctx, path)
+	}
+}
+
+// expectedChunkSize every chunk is ChunkSize long except the last one, which holds the remainder
+func expectedChunkSize(fileMeta *metadata.FileMetadata, chunkID int) int64 {
+	if chunkID == fileMeta.ChunkCount-1 {
+		return fileMeta.TotalSize - int64(chunkID)*fileMeta.ChunkSize
+	}
+	return fileMeta.ChunkSize
+}
+
+func chunkPath(userID, fileID string, chunkID int) string {
+	return fmt.Sprintf("%s%d", ChunkPrefix(userID, fileID), chunkID)
+}
+
+// ChunkPrefix is the key prefix shared by all chunk objects of an upload
+func ChunkPrefix(userID, fileID string) string {
+	return fmt.Sprintf("%s/%s/chunk_", userID, fileID)
+}
 
-	return s.metadataSvc.UpdateFileStatus(ctx, fileID, "merged")
+// objectPath is unique per merge attempt so concurrent merges of one upload never share a destination
+func objectPath(userID, fileID string) string {
+	return fmt.Sprintf("%s/%s/object_%s", userID, fileID, uuid.NewString())
 }
@@ -0,0 +1,392 @@
+package chunk_upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/internal/storage"
+	"github.com/roamBo/BoCloudStore/pkg/pool"
+	"go.uber.org/zap"
+)
+
+const (
+	testUserID    = "user-1"
+	testChunkSize = 4
+)
+
+// fakeMetadata keeps uploads and chunks in memory, only the calls made by an upload are implemented
+type fakeMetadata struct {
+	service.Service
+
+	mu     sync.Mutex
+	files  map[string]*metadata.FileMetadata
+	chunks map[string]map[int]*metadata.ChunkMetadata
+	//completeBarrier holds CompleteFile until every merge expected to race has reached it
+	completeBarrier *sync.WaitGroup
+}
+
+func newFakeMetadata() *fakeMetadata {
+	return &fakeMetadata{
+		files:  make(map[string]*metadata.FileMetadata),
+		chunks: make(map[string]map[int]*metadata.ChunkMetadata),
+	}
+}
+
+func (f *fakeMetadata) CreateFileMetadata(ctx context.Context, file *metadata.FileMetadata) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored := *file
+	f.files[file.FileID] = &stored
+	return nil
+}
+
+func (f *fakeMetadata) GetFileMetadata(ctx context.Context, fileID string) (*metadata.FileMetadata, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, ok := f.files[fileID]
+	if !ok {
+		return nil, service.ErrFileNotFound
+	}
+	copied := *file
+	return &copied, nil
+}
+
+func (f *fakeMetadata) SaveChunkMetadata(ctx context.Context, chunk *metadata.ChunkMetadata) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.chunks[chunk.FileID] == nil {
+		f.chunks[chunk.FileID] = make(map[int]*metadata.ChunkMetadata)
+	}
+	stored := *chunk
+	f.chunks[chunk.FileID][chunk.ChunkID] = &stored
+	return nil
+}
+
+func (f *fakeMetadata) ListChunkMetadata(ctx context.Context, fileID string) ([]*metadata.ChunkMetadata, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	chunks := make([]*metadata.ChunkMetadata, 0, len(f.chunks[fileID]))
+	for _, chunk := range f.chunks[fileID] {
+		copied := *chunk
+		chunks = append(chunks, &copied)
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].ChunkID < chunks[j].ChunkID })
+	return chunks, nil
+}
+
+func (f *fakeMetadata) DeleteChunkMetadata(ctx context.Context, fileID string, chunkID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.chunks[fileID], chunkID)
+	return nil
+}
+
+// CompleteFile checks the upload status under the lock like the database row lock does
+func (f *fakeMetadata) CompleteFile(ctx context.Context, fileID, storagePath, etag string) (*metadata.FileMetadata, []string, error) {
+	if f.completeBarrier != nil {
+		f.completeBarrier.Done()
+		f.completeBarrier.Wait()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, ok := f.files[fileID]
+	if !ok {
+		return nil, nil, service.ErrFileNotFound
+	}
+	if file.Status != metadata.StatusUploading {
+		return nil, nil, service.ErrUploadNotActive
+	}
+	file.Status = metadata.StatusMerged
+	file.StoragePath = storagePath
+	file.ETag = etag
+	copied := *file
+	return &copied, nil, nil
+}
+
+type testEnv struct {
+	svc   Service
+	meta  *fakeMetadata
+	store *storage.MemoryStore
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	logger := zap.NewNop()
+	meta := newFakeMetadata()
+	store := storage.NewMemoryStore()
+	workerPool := pool.NewWorkerPool(logger)
+	t.Cleanup(workerPool.Shutdown)
+	return &testEnv{
+		svc:   NewService(meta, authz.NewAuthorizer(meta, logger), store, workerPool, logger, testChunkSize, 2),
+		meta:  meta,
+		store: store,
+	}
+}
+
+// initUpload registers an upload of content the way the init handler does
+func (e *testEnv) initUpload(t *testing.T, fileID string, content []byte, contentHash string) {
+	t.Helper()
+	file := &metadata.FileMetadata{
+		FileID:      fileID,
+		FileName:    fileID + ".bin",
+		TotalSize:   int64(len(content)),
+		ChunkCount:  (len(content) + testChunkSize - 1) / testChunkSize,
+		ChunkSize:   testChunkSize,
+		Status:      metadata.StatusUploading,
+		UserID:      testUserID,
+		ContentHash: contentHash,
+	}
+	if err := e.meta.CreateFileMetadata(context.Background(), file); err != nil {
+		t.Fatalf("init upload: %v", err)
+	}
+}
+
+func (e *testEnv) uploadChunks(t *testing.T, fileID string, content []byte, chunkIDs ...int) {
+	t.Helper()
+	for _, chunkID := range chunkIDs {
+		data := chunkOf(content, chunkID)
+		_, err := e.svc.UploadChunk(context.Background(), fileID, chunkID, bytes.NewReader(data), ChunkChecksum{MD5: md5Hex(data)}, testUserID)
+		if err != nil {
+			t.Fatalf("upload chunk %d: %v", chunkID, err)
+		}
+	}
+}
+
+// objectKeys lists the stored objects of an upload
+func (e *testEnv) objectKeys(t *testing.T, fileID string) []string {
+	t.Helper()
+	objects, err := e.store.List(context.Background(), testUserID+"/"+fileID+"/")
+	if err != nil {
+		t.Fatalf("list objects: %v", err)
+	}
+	keys := make([]string, 0, len(objects))
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func chunkOf(content []byte, chunkID int) []byte {
+	end := min((chunkID+1)*testChunkSize, len(content))
+	return content[chunkID*testChunkSize : end]
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMergeChunks(t *testing.T) {
+	env := newTestEnv(t)
+	content := []byte("hello, chunked world")
+	env.initUpload(t, "file-1", content, sha256Hex(content))
+	env.uploadChunks(t, "file-1", content, 4, 0, 2, 1, 3)
+
+	merged, err := env.svc.MergeChunks(context.Background(), "file-1", testUserID)
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if merged.Status != metadata.StatusMerged {
+		t.Fatalf("status = %q, want %q", merged.Status, metadata.StatusMerged)
+	}
+	body, _, err := env.store.Get(context.Background(), merged.StoragePath, 0, -1)
+	if err != nil {
+		t.Fatalf("get merged object: %v", err)
+	}
+	defer body.Close()
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("read merged object: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("merged content = %q, want %q", got, content)
+	}
+	// the chunks are removed once the object exists
+	if keys := env.objectKeys(t, "file-1"); len(keys) != 1 || keys[0] != merged.StoragePath {
+		t.Fatalf("objects after merge = %v, want only %s", keys, merged.StoragePath)
+	}
+}
+
+func TestMergeChunksMissingChunk(t *testing.T) {
+	env := newTestEnv(t)
+	content := []byte("0123456789")
+	env.initUpload(t, "file-1", content, "")
+	env.uploadChunks(t, "file-1", content, 0, 2)
+
+	if _, err := env.svc.MergeChunks(context.Background(), "file-1", testUserID); !errors.Is(err, ErrChunkMissing) {
+		t.Fatalf("merge err = %v, want %v", err, ErrChunkMissing)
+	}
+
+	// a chunk whose object disappeared after it was recorded is missing as well
+	env.uploadChunks(t, "file-1", content, 1)
+	if err := env.store.Delete(context.Background(), chunkPath(testUserID, "file-1", 1)); err != nil {
+		t.Fatalf("delete chunk: %v", err)
+	}
+	if _, err := env.svc.MergeChunks(context.Background(), "file-1", testUserID); !errors.Is(err, ErrChunkMissing) {
+		t.Fatalf("merge err = %v, want %v", err, ErrChunkMissing)
+	}
+}
+
+func TestUploadChunkSizeMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunkID int
+		data    string
+		wantErr error
+	}{
+		{name: "too large", chunkID: 0, data: "01234", wantErr: ErrChunkTooLarge},
+		{name: "incomplete", chunkID: 0, data: "012", wantErr: ErrChunkIncomplete},
+		{name: "last chunk too large", chunkID: 2, data: "8901", wantErr: ErrChunkTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			env.initUpload(t, "file-1", []byte("0123456789"), "")
+
+			_, err := env.svc.UploadChunk(context.Background(), "file-1", tt.chunkID, strings.NewReader(tt.data), ChunkChecksum{}, testUserID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("upload err = %v, want %v", err, tt.wantErr)
+			}
+			if keys := env.objectKeys(t, "file-1"); len(keys) != 0 {
+				t.Fatalf("rejected chunk was kept: %v", keys)
+			}
+		})
+	}
+}
+
+func TestMergeChunksStoredChunkChanged(t *testing.T) {
+	env := newTestEnv(t)
+	content := []byte("0123456789")
+	env.initUpload(t, "file-1", content, "")
+	env.uploadChunks(t, "file-1", content, 0, 1, 2)
+	// replaced behind our back with a chunk of another size
+	if _, err := env.store.Put(context.Background(), chunkPath(testUserID, "file-1", 1), strings.NewReader("45"), 2); err != nil {
+		t.Fatalf("replace chunk: %v", err)
+	}
+
+	if _, err := env.svc.MergeChunks(context.Background(), "file-1", testUserID); !errors.Is(err, ErrChunkCorrupted) {
+		t.Fatalf("merge err = %v, want %v", err, ErrChunkCorrupted)
+	}
+}
+
+func TestUploadChunkChecksumMismatch(t *testing.T) {
+	env := newTestEnv(t)
+	content := []byte("0123456789")
+	env.initUpload(t, "file-1", content, "")
+
+	data := chunkOf(content, 0)
+	_, err := env.svc.UploadChunk(context.Background(), "file-1", 0, bytes.NewReader(data), ChunkChecksum{MD5: md5Hex([]byte("other"))}, testUserID)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("upload err = %v, want %v", err, ErrChecksumMismatch)
+	}
+	_, err = env.svc.UploadChunk(context.Background(), "file-1", 0, bytes.NewReader(data), ChunkChecksum{SHA256: sha256Hex([]byte("other"))}, testUserID)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("upload err = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if keys := env.objectKeys(t, "file-1"); len(keys) != 0 {
+		t.Fatalf("rejected chunk was kept: %v", keys)
+	}
+}
+
+func TestMergeChunksContentHashMismatch(t *testing.T) {
+	env := newTestEnv(t)
+	content := []byte("0123456789")
+	env.initUpload(t, "file-1", content, sha256Hex([]byte("something else")))
+	env.uploadChunks(t, "file-1", content, 0, 1, 2)
+
+	if _, err := env.svc.MergeChunks(context.Background(), "file-1", testUserID); !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("merge err = %v, want %v", err, ErrHashMismatch)
+	}
+	// the merged object is dropped, the chunks are kept for another attempt
+	want := []string{
+		chunkPath(testUserID, "file-1", 0),
+		chunkPath(testUserID, "file-1", 1),
+		chunkPath(testUserID, "file-1", 2),
+	}
+	if keys := env.objectKeys(t, "file-1"); strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Fatalf("objects after failed merge = %v, want %v", keys, want)
+	}
+}
+
+func TestMergeChunksTwice(t *testing.T) {
+	env := newTestEnv(t)
+	content := []byte("0123456789")
+	env.initUpload(t, "file-1", content, "")
+	env.uploadChunks(t, "file-1", content, 0, 1, 2)
+
+	if _, err := env.svc.MergeChunks(context.Background(), "file-1", testUserID); err != nil {
+		t.Fatalf("first merge: %v", err)
+	}
+	if _, err := env.svc.MergeChunks(context.Background(), "file-1", testUserID); !errors.Is(err, ErrAlreadyMerged) {
+		t.Fatalf("second merge err = %v, want %v", err, ErrAlreadyMerged)
+	}
+}
+
+func TestMergeChunksConcurrently(t *testing.T) {
+	env := newTestEnv(t)
+	content := []byte("0123456789")
+	env.initUpload(t, "file-1", content, "")
+	env.uploadChunks(t, "file-1", content, 0, 1, 2)
+
+	// both merges pass the status check and write their object before either completes the upload
+	const merges = 2
+	env.meta.completeBarrier = &sync.WaitGroup{}
+	env.meta.completeBarrier.Add(merges)
+	results := make([]*metadata.FileMetadata, merges)
+	errs := make([]error, merges)
+	var wg sync.WaitGroup
+	for i := range merges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = env.svc.MergeChunks(context.Background(), "file-1", testUserID)
+		}()
+	}
+	wg.Wait()
+
+	var winner *metadata.FileMetadata
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			if winner != nil {
+				t.Fatal("both merges completed the upload")
+			}
+			winner = results[i]
+		case !errors.Is(err, ErrUploadNotActive):
+			t.Fatalf("losing merge err = %v, want %v", err, ErrUploadNotActive)
+		}
+	}
+	if winner == nil {
+		t.Fatal("no merge completed the upload")
+	}
+	// the loser removed only its own object, the winner's object stays intact
+	if keys := env.objectKeys(t, "file-1"); len(keys) != 1 || keys[0] != winner.StoragePath {
+		t.Fatalf("objects after merges = %v, want only %s", keys, winner.StoragePath)
+	}
+	body, _, err := env.store.Get(context.Background(), winner.StoragePath, 0, -1)
+	if err != nil {
+		t.Fatalf("get merged object: %v", err)
+	}
+	defer body.Close()
+	if got, _ := io.ReadAll(body); !bytes.Equal(got, content) {
+		t.Fatalf("merged content = %q, want %q", got, content)
+	}
+}
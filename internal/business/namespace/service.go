@@ -0,0 +1,290 @@
+package namespace
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"go.uber.org/zap"
+)
+
+// RootFolderID addresses the user's root folder in the api, it is stored as an empty parent
+const RootFolderID = "root"
+
+const maxNameLength = 255
+
+// for error
+var (
+	ErrInvalidName     = errors.New("invalid name")
+	ErrInvalidPath     = errors.New("invalid path")
+	ErrFileUnavailable = errors.New("file is no longer available")
+)
+
+// Listing is the content of one folder, Folder is nil for the root
+type Listing struct {
+	Folder  *metadata.Folder
+	Folders []*metadata.Folder
+	Files   []*metadata.FileMetadata
+}
+
+// Entry is what a path resolves to, exactly one of Folder and File is set unless the path is the root
+type Entry struct {
+	Folder *metadata.Folder
+	File   *metadata.FileMetadata
+}
+
+type Service interface {
+	CreateFolder(ctx context.Context, userID, parentID, name string) (*metadata.Folder, error)
+	ListFolder(ctx context.Context, userID, folderID string) (*Listing, error)
+	RenameFolder(ctx context.Context, userID, folderID, name string) (*metadata.Folder, error)
+	MoveFolder(ctx context.Context, userID, folderID, parentID string) (*metadata.Folder, error)
+	RenameFile(ctx context.Context, userID, fileID, name string) (*metadata.FileMetadata, error)
+	MoveFile(ctx context.Context, userID, fileID, folderID string) (*metadata.FileMetadata, error)
+	Resolve(ctx context.Context, userID, path string) (*Entry, error)
+	// CheckFolder makes sure userID may add entries to folderID and returns the owner of the folder,
+	// whom new entries belong to. The root is the root of userID.
+	CheckFolder(ctx context.Context, userID, folderID string) (string, error)
+}
+
+type namespaceService struct {
+	metadataSvc service.Service
+	authorizer  authz.Authorizer
+	logger      *zap.Logger
+}
+
+func NewService(metadataSvc service.Service, authorizer authz.Authorizer, logger *zap.Logger) Service {
+	return &namespaceService{
+		metadataSvc: metadataSvc,
+		authorizer:  authorizer,
+		logger:      logger,
+	}
+}
+
+// ValidateName checks a single file or folder name, names are path components and may not contain separators
+func ValidateName(name string) error {
+	if name == "" || len(name) > maxNameLength || name == "." || name == ".." ||
+		strings.ContainsAny(name, "/\\\x00") {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// NormalizeFolderID maps the api's root alias to the stored empty parent
+func NormalizeFolderID(folderID string) string {
+	if folderID == RootFolderID {
+		return ""
+	}
+	return folderID
+}
+
+func (s *namespaceService) CreateFolder(ctx context.Context, userID, parentID, name string) (*metadata.Folder, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	parentID = NormalizeFolderID(parentID)
+	ownerID, err := s.CheckFolder(ctx, userID, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	folder := &metadata.Folder{
+		FolderID: uuid.NewString(),
+		UserID:   ownerID,
+		ParentID: parentID,
+		Name:     name,
+	}
+	if err := s.metadataSvc.CreateFolder(ctx, folder); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+// ListFolder lists a folder userID may read, the root is the root of userID
+func (s *namespaceService) ListFolder(ctx context.Context, userID, folderID string) (*Listing, error) {
+	resource, folder, err := s.authorizedFolder(ctx, userID, userID, NormalizeFolderID(folderID), authz.ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	listing := &Listing{Folder: folder}
+
+	folders, err := s.metadataSvc.ListFolders(ctx, resource.OwnerID, resource.ID)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.metadataSvc.ListFolderFiles(ctx, resource.OwnerID, resource.ID)
+	if err != nil {
+		return nil, err
+	}
+	listing.Folders = folders
+	listing.Files = files
+	return listing, nil
+}
+
+func (s *namespaceService) RenameFolder(ctx context.Context, userID, folderID, name string) (*metadata.Folder, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	_, folder, err := s.authorizedFolder(ctx, userID, userID, folderID, authz.ActionWrite)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.metadataSvc.UpdateFolder(ctx, folderID, folder.ParentID, name); err != nil {
+		return nil, err
+	}
+	folder.Name = name
+	return folder, nil
+}
+
+// MoveFolder re-parents the folder, its whole subtree follows without touching any object.
+// Folders stay within the namespace of their owner, the root is the root of the owner.
+func (s *namespaceService) MoveFolder(ctx context.Context, userID, folderID, parentID string) (*metadata.Folder, error) {
+	if NormalizeFolderID(folderID) == "" {
+		return nil, service.ErrInvalidMove
+	}
+	_, folder, err := s.authorizedFolder(ctx, userID, userID, folderID, authz.ActionWrite)
+	if err != nil {
+		return nil, err
+	}
+	parentID = NormalizeFolderID(parentID)
+	if parentID == folderID {
+		return nil, service.ErrInvalidMove
+	}
+	if err := s.checkTarget(ctx, userID, folder.UserID, parentID); err != nil {
+		return nil, err
+	}
+	if err := s.metadataSvc.UpdateFolder(ctx, folderID, parentID, folder.Name); err != nil {
+		return nil, err
+	}
+	folder.ParentID = parentID
+	return folder, nil
+}
+
+func (s *namespaceService) RenameFile(ctx context.Context, userID, fileID, name string) (*metadata.FileMetadata, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	file, err := s.writableFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.metadataSvc.UpdateFileLocation(ctx, fileID, file.FolderID, name); err != nil {
+		return nil, err
+	}
+	file.FileName = name
+	return file, nil
+}
+
+// MoveFile moves a file within the namespace of its owner, the root is the root of the owner
+func (s *namespaceService) MoveFile(ctx context.Context, userID, fileID, folderID string) (*metadata.FileMetadata, error) {
+	file, err := s.writableFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	folderID = NormalizeFolderID(folderID)
+	if err := s.checkTarget(ctx, userID, file.UserID, folderID); err != nil {
+		return nil, err
+	}
+	if err := s.metadataSvc.UpdateFileLocation(ctx, fileID, folderID, file.FileName); err != nil {
+		return nil, err
+	}
+	file.FolderID = folderID
+	return file, nil
+}
+
+// Resolve walks a slash separated path like /docs/2026/report.pdf from the user's root,
+// the last component may name a folder or a merged file
+func (s *namespaceService) Resolve(ctx context.Context, userID, path string) (*Entry, error) {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		if err := ValidateName(name); err != nil {
+			return nil, ErrInvalidPath
+		}
+		names = append(names, name)
+	}
+
+	entry := &Entry{}
+	parentID := ""
+	for i, name := range names {
+		folder, err := s.metadataSvc.FindFolderByName(ctx, userID, parentID, name)
+		if err == nil {
+			entry.Folder = folder
+			parentID = folder.FolderID
+			continue
+		}
+		if !errors.Is(err, service.ErrFolderNotFound) {
+			return nil, err
+		}
+		if i < len(names)-1 {
+			return nil, service.ErrFolderNotFound
+		}
+		file, err := s.metadataSvc.FindFileByName(ctx, userID, parentID, name)
+		if err != nil {
+			return nil, err
+		}
+		return &Entry{File: file}, nil
+	}
+	return entry, nil
+}
+
+func (s *namespaceService) CheckFolder(ctx context.Context, userID, folderID string) (string, error) {
+	resource, _, err := s.authorizedFolder(ctx, userID, userID, NormalizeFolderID(folderID), authz.ActionWrite)
+	if err != nil {
+		return "", err
+	}
+	return resource.OwnerID, nil
+}
+
+// checkTarget makes sure userID may move entries of ownerID into folderID, the root is the root of ownerID
+func (s *namespaceService) checkTarget(ctx context.Context, userID, ownerID, folderID string) error {
+	resource, _, err := s.authorizedFolder(ctx, userID, ownerID, folderID, authz.ActionWrite)
+	if err != nil {
+		return err
+	}
+	if resource.OwnerID != ownerID {
+		return service.ErrInvalidMove
+	}
+	return nil
+}
+
+// authorizedFolder loads folderID and checks that userID may perform action on it,
+// the empty id is the root of ownerID and has no folder record
+func (s *namespaceService) authorizedFolder(ctx context.Context, userID, ownerID, folderID, action string) (authz.Resource, *metadata.Folder, error) {
+	if folderID == "" {
+		resource := authz.RootFolder(ownerID)
+		if err := s.authorizer.Authorize(ctx, authz.User(userID), action, resource); err != nil {
+			return authz.Resource{}, nil, err
+		}
+		return resource, nil, nil
+	}
+	folder, err := s.metadataSvc.GetFolder(ctx, folderID)
+	if err != nil {
+		return authz.Resource{}, nil, err
+	}
+	resource := authz.FolderResource(folder)
+	if err := s.authorizer.Authorize(ctx, authz.User(userID), action, resource); err != nil {
+		return authz.Resource{}, nil, err
+	}
+	return resource, folder, nil
+}
+
+func (s *namespaceService) writableFile(ctx context.Context, userID, fileID string) (*metadata.FileMetadata, error) {
+	file, err := s.metadataSvc.GetFileMetadata(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizer.Authorize(ctx, authz.User(userID), authz.ActionWrite, authz.FileResource(file)); err != nil {
+		return nil, err
+	}
+	switch file.Status {
+	case metadata.StatusExpiring, metadata.StatusExpired, metadata.StatusTrashed, metadata.StatusPurging:
+		return nil, ErrFileUnavailable
+	}
+	return file, nil
+}
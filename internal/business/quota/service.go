@@ -0,0 +1,218 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var groupPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// for error
+var (
+	ErrInvalidQuota = errors.New("quota limits must not be negative")
+	ErrInvalidGroup = errors.New("group name must be 1-64 letters, digits, '.', '_' or '-'")
+)
+
+// Limit is a quota a user is subject to with the usage it is checked against, 0 is unlimited
+type Limit struct {
+	SubjectType string
+	SubjectID   string
+	MaxBytes    int64
+	MaxFiles    int64
+	Default     bool //the configured default, the user has no quota of its own
+	Usage       *metadata.Usage
+}
+
+// Report is the usage of a user with every quota that applies to it
+type Report struct {
+	UserID string
+	Usage  *metadata.Usage
+	Limits []*Limit
+}
+
+// Group is a group with its members, its quota (nil without one) and summed usage
+type Group struct {
+	Name    string
+	Members []string
+	Quota   *metadata.Quota
+	Usage   *metadata.Usage
+}
+
+type Service interface {
+	GetReport(ctx context.Context, userID string) (*Report, error)
+	// RecomputeUsage rebuilds the usage of a user from its files
+	RecomputeUsage(ctx context.Context, userID string) (*Report, error)
+	SetUserQuota(ctx context.Context, userID string, maxBytes, maxFiles int64) (*metadata.Quota, error)
+	// ResetUserQuota removes the quota of a user, the default quota applies again
+	ResetUserQuota(ctx context.Context, userID string) error
+
+	GetGroup(ctx context.Context, name string) (*Group, error)
+	SetGroupQuota(ctx context.Context, name string, maxBytes, maxFiles int64) (*metadata.Quota, error)
+	DeleteGroupQuota(ctx context.Context, name string) error
+	AddMember(ctx context.Context, name, userID string) error
+	RemoveMember(ctx context.Context, name, userID string) error
+}
+
+type quotaService struct {
+	metadataSvc service.Service
+	logger      *zap.Logger
+	cfg         config.QuotaConfig
+}
+
+func NewService(metadataSvc service.Service, logger *zap.Logger, cfg config.QuotaConfig) Service {
+	return &quotaService{
+		metadataSvc: metadataSvc,
+		logger:      logger,
+		cfg:         cfg,
+	}
+}
+
+func (s *quotaService) GetReport(ctx context.Context, userID string) (*Report, error) {
+	usage, err := s.metadataSvc.GetUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.report(ctx, userID, usage)
+}
+
+func (s *quotaService) RecomputeUsage(ctx context.Context, userID string) (*Report, error) {
+	if _, err := s.metadataSvc.GetUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	usage, err := s.metadataSvc.RecomputeUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.report(ctx, userID, usage)
+}
+
+func (s *quotaService) SetUserQuota(ctx context.Context, userID string, maxBytes, maxFiles int64) (*metadata.Quota, error) {
+	if maxBytes < 0 || maxFiles < 0 {
+		return nil, ErrInvalidQuota
+	}
+	if _, err := s.metadataSvc.GetUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	quota := &metadata.Quota{SubjectType: metadata.QuotaUser, SubjectID: userID, MaxBytes: maxBytes, MaxFiles: maxFiles}
+	if err := s.metadataSvc.SetQuota(ctx, quota); err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+func (s *quotaService) ResetUserQuota(ctx context.Context, userID string) error {
+	return s.metadataSvc.DeleteQuota(ctx, metadata.QuotaUser, userID)
+}
+
+func (s *quotaService) GetGroup(ctx context.Context, name string) (*Group, error) {
+	if !groupPattern.MatchString(name) {
+		return nil, ErrInvalidGroup
+	}
+	members, err := s.metadataSvc.ListGroupMembers(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	group := &Group{Name: name, Members: members}
+	group.Quota, err = s.metadataSvc.GetQuota(ctx, metadata.QuotaGroup, name)
+	if err != nil && !errors.Is(err, service.ErrQuotaNotFound) {
+		return nil, err
+	}
+	if group.Usage, err = s.metadataSvc.GetGroupUsage(ctx, name); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *quotaService) SetGroupQuota(ctx context.Context, name string, maxBytes, maxFiles int64) (*metadata.Quota, error) {
+	if !groupPattern.MatchString(name) {
+		return nil, ErrInvalidGroup
+	}
+	if maxBytes < 0 || maxFiles < 0 {
+		return nil, ErrInvalidQuota
+	}
+	quota := &metadata.Quota{SubjectType: metadata.QuotaGroup, SubjectID: name, MaxBytes: maxBytes, MaxFiles: maxFiles}
+	if err := s.metadataSvc.SetQuota(ctx, quota); err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+func (s *quotaService) DeleteGroupQuota(ctx context.Context, name string) error {
+	if !groupPattern.MatchString(name) {
+		return ErrInvalidGroup
+	}
+	return s.metadataSvc.DeleteQuota(ctx, metadata.QuotaGroup, name)
+}
+
+func (s *quotaService) AddMember(ctx context.Context, name, userID string) error {
+	if !groupPattern.MatchString(name) {
+		return ErrInvalidGroup
+	}
+	if _, err := s.metadataSvc.GetUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.metadataSvc.AddGroupMember(ctx, name, userID); err != nil {
+		return err
+	}
+	utils.LoggerFrom(ctx, s.logger).Info("user added to group", zap.String("group", name), zap.String("userID", userID))
+	return nil
+}
+
+func (s *quotaService) RemoveMember(ctx context.Context, name, userID string) error {
+	if !groupPattern.MatchString(name) {
+		return ErrInvalidGroup
+	}
+	if err := s.metadataSvc.RemoveGroupMember(ctx, name, userID); err != nil {
+		return err
+	}
+	utils.LoggerFrom(ctx, s.logger).Info("user removed from group", zap.String("group", name), zap.String("userID", userID))
+	return nil
+}
+
+// report lists the quota of the user, or the default, and the quotas of its groups
+func (s *quotaService) report(ctx context.Context, userID string, usage *metadata.Usage) (*Report, error) {
+	own := &Limit{SubjectType: metadata.QuotaUser, SubjectID: userID, Usage: usage}
+	quota, err := s.metadataSvc.GetQuota(ctx, metadata.QuotaUser, userID)
+	switch {
+	case err == nil:
+		own.MaxBytes, own.MaxFiles = quota.MaxBytes, quota.MaxFiles
+	case errors.Is(err, service.ErrQuotaNotFound):
+		own.MaxBytes, own.MaxFiles, own.Default = s.cfg.DefaultBytes, s.cfg.DefaultFiles, true
+	default:
+		return nil, err
+	}
+	report := &Report{UserID: userID, Usage: usage, Limits: []*Limit{own}}
+
+	groups, err := s.metadataSvc.ListUserGroups(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range groups {
+		quota, err := s.metadataSvc.GetQuota(ctx, metadata.QuotaGroup, name)
+		if err != nil {
+			if errors.Is(err, service.ErrQuotaNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		groupUsage, err := s.metadataSvc.GetGroupUsage(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		report.Limits = append(report.Limits, &Limit{
+			SubjectType: metadata.QuotaGroup,
+			SubjectID:   name,
+			MaxBytes:    quota.MaxBytes,
+			MaxFiles:    quota.MaxFiles,
+			Usage:       groupUsage,
+		})
+	}
+	return report, nil
+}
@@ -0,0 +1,366 @@
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roamBo/BoCloudStore/internal/business/chunk_upload"
+	"github.com/roamBo/BoCloudStore/internal/business/download"
+	"github.com/roamBo/BoCloudStore/internal/business/namespace"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const tokenBytes = 32
+
+// for error
+var (
+	ErrInvalidShare     = errors.New("invalid share settings")
+	ErrShareExpired     = errors.New("share has expired")
+	ErrPasswordRequired = errors.New("share password required")
+	ErrWrongPassword    = errors.New("wrong share password")
+	ErrOutsideShare     = errors.New("resource is not part of the share")
+	ErrUploadNotAllowed = errors.New("share does not allow uploads")
+	ErrUploadTooLarge   = errors.New("upload exceeds the share upload limit")
+	ErrLengthRequired   = errors.New("upload size must be known in advance")
+	ErrNotShareable     = errors.New("only merged files can be shared")
+)
+
+// CreateRequest describes a new share, zero ExpiresAt and MaxDownloads mean no limit
+type CreateRequest struct {
+	ResourceType string
+	ResourceID   string
+	Password     string
+	Mode         string
+	ExpiresAt    int64
+	MaxDownloads int
+}
+
+type Service interface {
+	CreateShare(ctx context.Context, req *CreateRequest, userID string) (*metadata.Share, error)
+	ListShares(ctx context.Context, userID string) ([]*metadata.Share, error)
+	RevokeShare(ctx context.Context, shareID string, userID string) error
+
+	// Open resolves a token to an active share, checking its expiry and password
+	Open(ctx context.Context, token, password string) (*metadata.Share, error)
+	ListFolder(ctx context.Context, share *metadata.Share, folderID string) (*namespace.Listing, error)
+	GetFile(ctx context.Context, share *metadata.Share, fileID string) (*metadata.FileMetadata, error)
+	OpenFile(ctx context.Context, share *metadata.Share, fileID string) (*download.File, error)
+	// ConsumeDownload counts one download against the share's limit, ErrShareLimitReached once none is left
+	ConsumeDownload(ctx context.Context, share *metadata.Share) error
+	Upload(ctx context.Context, share *metadata.Share, folderID, name string, size int64, body io.Reader) (*metadata.FileMetadata, error)
+}
+
+type shareService struct {
+	metadataSvc    service.Service
+	authorizer     authz.Authorizer
+	namespaceSvc   namespace.Service
+	downloadSvc    download.Service
+	chunkUploadSvc chunk_upload.Service
+	logger         *zap.Logger
+	maxUploadSize  int64 //uploads through a share are sent in one request, bounded like a single chunk
+}
+
+func NewService(
+	metadataSvc service.Service,
+	authorizer authz.Authorizer,
+	namespaceSvc namespace.Service,
+	downloadSvc download.Service,
+	chunkUploadSvc chunk_upload.Service,
+	logger *zap.Logger,
+	maxUploadSize int64,
+) Service {
+	return &shareService{
+		metadataSvc:    metadataSvc,
+		authorizer:     authorizer,
+		namespaceSvc:   namespaceSvc,
+		downloadSvc:    downloadSvc,
+		chunkUploadSvc: chunkUploadSvc,
+		logger:         logger,
+		maxUploadSize:  maxUploadSize,
+	}
+}
+
+func (s *shareService) CreateShare(ctx context.Context, req *CreateRequest, userID string) (*metadata.Share, error) {
+	if req.Mode == "" {
+		req.Mode = metadata.ShareModeRead
+	}
+	if err := s.validateRequest(req); err != nil {
+		return nil, err
+	}
+	ownerID, err := s.checkResource(ctx, req, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	share := &metadata.Share{
+		ShareID:      uuid.NewString(),
+		Token:        token,
+		UserID:       ownerID,
+		ResourceType: req.ResourceType,
+		ResourceID:   req.ResourceID,
+		Mode:         req.Mode,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			// only fails for passwords longer than bcrypt accepts
+			return nil, ErrInvalidShare
+		}
+		share.PasswordHash = string(hash)
+	}
+	if err := s.metadataSvc.CreateShare(ctx, share); err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+func (s *shareService) ListShares(ctx context.Context, userID string) ([]*metadata.Share, error) {
+	return s.metadataSvc.ListShares(ctx, userID)
+}
+
+func (s *shareService) RevokeShare(ctx context.Context, shareID string, userID string) error {
+	return s.metadataSvc.RevokeShare(ctx, shareID, userID)
+}
+
+func (s *shareService) Open(ctx context.Context, token, password string) (*metadata.Share, error) {
+	share, err := s.metadataSvc.GetShareByToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	// a revoked share is indistinguishable from one that never existed
+	if share.RevokedAt != 0 {
+		return nil, service.ErrShareNotFound
+	}
+	if share.ExpiresAt != 0 && time.Now().Unix() >= share.ExpiresAt {
+		return nil, ErrShareExpired
+	}
+	if share.PasswordHash != "" {
+		if password == "" {
+			return nil, ErrPasswordRequired
+		}
+		if bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+			return nil, ErrWrongPassword
+		}
+	}
+	return share, nil
+}
+
+// ListFolder lists a folder of a folder share, an empty folderID lists the shared folder itself
+func (s *shareService) ListFolder(ctx context.Context, share *metadata.Share, folderID string) (*namespace.Listing, error) {
+	if share.ResourceType != metadata.ShareTypeFolder {
+		return nil, ErrOutsideShare
+	}
+	folderID, err := s.sharedFolder(ctx, share, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return s.namespaceSvc.ListFolder(ctx, share.UserID, folderID)
+}
+
+// GetFile returns a merged file reachable through the share, an empty fileID is the shared file itself
+func (s *shareService) GetFile(ctx context.Context, share *metadata.Share, fileID string) (*metadata.FileMetadata, error) {
+	if share.ResourceType == metadata.ShareTypeFile {
+		if fileID == "" {
+			fileID = share.ResourceID
+		}
+		if fileID != share.ResourceID {
+			return nil, ErrOutsideShare
+		}
+	}
+
+	file, err := s.metadataSvc.GetFileMetadata(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	// files that left the owner's namespace are no longer shared
+	if file.UserID != share.UserID || file.Status != metadata.StatusMerged {
+		return nil, ErrOutsideShare
+	}
+	if share.ResourceType == metadata.ShareTypeFolder {
+		if file.FolderID == "" {
+			return nil, ErrOutsideShare
+		}
+		within, err := s.metadataSvc.IsFolderWithin(ctx, file.FolderID, share.ResourceID)
+		if err != nil {
+			return nil, err
+		}
+		if !within {
+			return nil, ErrOutsideShare
+		}
+	}
+	return file, nil
+}
+
+// OpenFile opens a shared file for reading as long as the share has downloads left. The download
+// is not counted, the caller consumes it with ConsumeDownload once the content is actually sent.
+func (s *shareService) OpenFile(ctx context.Context, share *metadata.Share, fileID string) (*download.File, error) {
+	file, err := s.GetFile(ctx, share, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if share.MaxDownloads != 0 && share.DownloadCount >= share.MaxDownloads {
+		return nil, service.ErrShareLimitReached
+	}
+	return s.downloadSvc.OpenFile(ctx, file.FileID, share.UserID)
+}
+
+func (s *shareService) ConsumeDownload(ctx context.Context, share *metadata.Share) error {
+	return s.metadataSvc.ConsumeShareDownload(ctx, share.ShareID)
+}
+
+// Upload stores body as a new file of the share's owner in folderID (empty for the shared folder).
+// Visitors cannot replace existing files, a taken name is a conflict.
+func (s *shareService) Upload(ctx context.Context, share *metadata.Share, folderID, name string, size int64, body io.Reader) (*metadata.FileMetadata, error) {
+	if share.ResourceType != metadata.ShareTypeFolder || share.Mode != metadata.ShareModeUpload {
+		return nil, ErrUploadNotAllowed
+	}
+	if err := namespace.ValidateName(name); err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, ErrLengthRequired
+	}
+	if size > s.maxUploadSize {
+		return nil, ErrUploadTooLarge
+	}
+	folderID, err := s.sharedFolder(ctx, share, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.metadataSvc.FindFileByName(ctx, share.UserID, folderID, name); err == nil {
+		return nil, service.ErrNameConflict
+	} else if !errors.Is(err, service.ErrFileNotFound) {
+		return nil, err
+	}
+
+	fileMeta := &metadata.FileMetadata{
+		FileID:    uuid.NewString(),
+		FileName:  name,
+		TotalSize: size,
+		ChunkSize: s.maxUploadSize,
+		Status:    metadata.StatusUploading,
+		UserID:    share.UserID,
+		FolderID:  folderID,
+	}
+	if size > 0 {
+		fileMeta.ChunkCount = 1
+	}
+	if err := s.metadataSvc.CreateFileMetadata(ctx, fileMeta); err != nil {
+		return nil, err
+	}
+	// an upload failing from here on is left in uploading state for the reaper
+	if size > 0 {
+		_, err := s.chunkUploadSvc.UploadChunk(ctx, fileMeta.FileID, 0, body, chunk_upload.ChunkChecksum{}, share.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	merged, err := s.chunkUploadSvc.MergeChunks(ctx, fileMeta.FileID, share.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LoggerFrom(ctx, s.logger).Info("file uploaded through share",
+		zap.String("shareID", share.ShareID),
+		zap.String("fileID", merged.FileID),
+		zap.Int64("size", merged.TotalSize))
+	return merged, nil
+}
+
+// sharedFolder resolves a folder of a folder share, the empty id is the shared folder
+func (s *shareService) sharedFolder(ctx context.Context, share *metadata.Share, folderID string) (string, error) {
+	if folderID == "" || folderID == share.ResourceID {
+		return share.ResourceID, nil
+	}
+	folder, err := s.metadataSvc.GetFolder(ctx, folderID)
+	if err != nil {
+		return "", err
+	}
+	if folder.UserID != share.UserID {
+		return "", ErrOutsideShare
+	}
+	within, err := s.metadataSvc.IsFolderWithin(ctx, folderID, share.ResourceID)
+	if err != nil {
+		return "", err
+	}
+	if !within {
+		return "", ErrOutsideShare
+	}
+	return folderID, nil
+}
+
+func (s *shareService) validateRequest(req *CreateRequest) error {
+	switch req.ResourceType {
+	case metadata.ShareTypeFile:
+		if req.Mode != metadata.ShareModeRead {
+			return ErrInvalidShare
+		}
+	case metadata.ShareTypeFolder:
+		if req.Mode != metadata.ShareModeRead && req.Mode != metadata.ShareModeUpload {
+			return ErrInvalidShare
+		}
+	default:
+		return ErrInvalidShare
+	}
+	if req.ResourceID == "" || req.MaxDownloads < 0 || req.ExpiresAt < 0 {
+		return ErrInvalidShare
+	}
+	if req.ExpiresAt != 0 && req.ExpiresAt <= time.Now().Unix() {
+		return ErrInvalidShare
+	}
+	return nil
+}
+
+// checkResource makes sure userID may share the resource and returns its owner, whom the share
+// belongs to. The root folder cannot be shared.
+func (s *shareService) checkResource(ctx context.Context, req *CreateRequest, userID string) (string, error) {
+	var resource authz.Resource
+	if req.ResourceType == metadata.ShareTypeFolder {
+		if namespace.NormalizeFolderID(req.ResourceID) == "" {
+			return "", ErrInvalidShare
+		}
+		folder, err := s.metadataSvc.GetFolder(ctx, req.ResourceID)
+		if err != nil {
+			return "", err
+		}
+		resource = authz.FolderResource(folder)
+	} else {
+		file, err := s.metadataSvc.GetFileMetadata(ctx, req.ResourceID)
+		if err != nil {
+			return "", err
+		}
+		if file.Status != metadata.StatusMerged {
+			return "", ErrNotShareable
+		}
+		resource = authz.FileResource(file)
+	}
+
+	if err := s.authorizer.Authorize(ctx, authz.User(userID), authz.ActionShare, resource); err != nil {
+		return "", err
+	}
+	return resource.OwnerID, nil
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, tokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
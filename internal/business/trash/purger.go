@@ -0,0 +1,80 @@
+package trash
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/storage"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"github.com/roamBo/BoCloudStore/pkg/pool"
+	"go.uber.org/zap"
+)
+
+// Purger periodically deletes files that stayed in the trash longer than the retention period
+type Purger struct {
+	metadataSvc service.Service
+	objectStore storage.ObjectStore
+	workerPool  *pool.WorkerPool
+	logger      *zap.Logger
+	cfg         config.TrashConfig
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+func NewPurger(
+	metadataSvc service.Service,
+	objectStore storage.ObjectStore,
+	workerPool *pool.WorkerPool,
+	logger *zap.Logger,
+	cfg config.TrashConfig,
+) *Purger {
+	return &Purger{
+		metadataSvc: metadataSvc,
+		objectStore: objectStore,
+		workerPool:  workerPool,
+		logger:      logger,
+		cfg:         cfg,
+		stop:        make(chan struct{}),
+	}
+}
+
+// Start schedules a sweep on the worker pool every interval until Stop is called
+func (p *Purger) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				if err := p.workerPool.Submit(p.Sweep); err != nil {
+					p.logger.Warn("failed to schedule trash purger", zap.Error(err))
+				}
+			}
+		}
+	}()
+	p.logger.Info("Trash purger started",
+		zap.Duration("interval", p.cfg.Interval),
+		zap.Duration("retention", p.cfg.Retention))
+}
+
+func (p *Purger) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// Sweep purges one batch of expired trash
+func (p *Purger) Sweep(ctx context.Context) error {
+	now := time.Now()
+	files, err := p.metadataSvc.ClaimTrashedFiles(ctx, now.Add(-p.cfg.Retention), now.Add(-p.cfg.ClaimTimeout), p.cfg.BatchSize)
+	if err != nil {
+		return err
+	}
+	return purgeFiles(ctx, p.metadataSvc, p.objectStore, p.logger, files)
+}
@@ -0,0 +1,168 @@
+package trash
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roamBo/BoCloudStore/internal/business/chunk_upload"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/internal/storage"
+	"github.com/roamBo/BoCloudStore/pkg/pool"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// for error
+var (
+	ErrNotTrashable = errors.New("only merged files can be moved to trash")
+)
+
+type Service interface {
+	TrashFile(ctx context.Context, fileID string, userID string) (*metadata.FileMetadata, error)
+	RestoreFile(ctx context.Context, fileID string, userID string) (*metadata.FileMetadata, error)
+	EmptyTrash(ctx context.Context, userID string) (int, error)
+}
+
+type trashService struct {
+	metadataSvc service.Service
+	authorizer  authz.Authorizer
+	objectStore storage.ObjectStore
+	workerPool  *pool.WorkerPool
+	logger      *zap.Logger
+}
+
+func NewService(
+	metadataSvc service.Service,
+	authorizer authz.Authorizer,
+	objectStore storage.ObjectStore,
+	workerPool *pool.WorkerPool,
+	logger *zap.Logger,
+) Service {
+	return &trashService{
+		metadataSvc: metadataSvc,
+		authorizer:  authorizer,
+		objectStore: objectStore,
+		workerPool:  workerPool,
+		logger:      logger,
+	}
+}
+
+// TrashFile moves a merged file to the owner's trash, it leaves its folder but keeps its object
+func (s *trashService) TrashFile(ctx context.Context, fileID string, userID string) (*metadata.FileMetadata, error) {
+	fileMeta, err := s.deletableFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if fileMeta.Status != metadata.StatusMerged {
+		return nil, ErrNotTrashable
+	}
+	// checked again by the update, the purger or a concurrent request may have moved the file on
+	if err := s.metadataSvc.TrashFile(ctx, fileID); err != nil {
+		if errors.Is(err, service.ErrFileNotMerged) {
+			return nil, ErrNotTrashable
+		}
+		return nil, err
+	}
+	fileMeta.Status = metadata.StatusTrashed
+	return fileMeta, nil
+}
+
+// RestoreFile moves a trashed file back into its folder
+func (s *trashService) RestoreFile(ctx context.Context, fileID string, userID string) (*metadata.FileMetadata, error) {
+	fileMeta, err := s.deletableFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if fileMeta.Status != metadata.StatusTrashed {
+		return nil, service.ErrFileNotTrashed
+	}
+	if err := s.metadataSvc.RestoreFile(ctx, fileID); err != nil {
+		return nil, err
+	}
+	fileMeta.Status = metadata.StatusMerged
+	return fileMeta, nil
+}
+
+// EmptyTrash claims every trashed file of the user and purges them in the background,
+// returning how many files were claimed. Purges that fail are retried by the purger.
+func (s *trashService) EmptyTrash(ctx context.Context, userID string) (int, error) {
+	files, err := s.metadataSvc.ClaimUserTrash(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, nil
+	}
+
+	err = s.workerPool.SubmitWait(ctx, func(ctx context.Context) error {
+		return purgeFiles(ctx, s.metadataSvc, s.objectStore, s.logger, files)
+	})
+	if err != nil {
+		utils.LoggerFrom(ctx, s.logger).Warn("failed to schedule trash purge, left to the purger",
+			zap.Error(err),
+			zap.String("userID", userID))
+	}
+	return len(files), nil
+}
+
+// deletableFile loads a file userID may move to and restore from the trash
+func (s *trashService) deletableFile(ctx context.Context, fileID string, userID string) (*metadata.FileMetadata, error) {
+	fileMeta, err := s.metadataSvc.GetFileMetadata(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizer.Authorize(ctx, authz.User(userID), authz.ActionDelete, authz.FileResource(fileMeta)); err != nil {
+		return nil, err
+	}
+	return fileMeta, nil
+}
+
+// purgeFiles permanently deletes claimed files, continuing past failures
+func purgeFiles(ctx context.Context, metadataSvc service.Service, objectStore storage.ObjectStore, logger *zap.Logger, files []*metadata.FileMetadata) error {
+	var failed int
+	for _, file := range files {
+		if err := purgeFile(ctx, metadataSvc, objectStore, file); err != nil {
+			failed++
+			logger.Warn("failed to purge file",
+				zap.Error(err),
+				zap.String("fileID", file.FileID))
+		}
+	}
+	if len(files) > 0 {
+		logger.Info("Purged trashed files",
+			zap.Int("claimed", len(files)),
+			zap.Int("failed", failed))
+	}
+	if failed > 0 {
+		return errors.New("failed to purge some trashed files")
+	}
+	return nil
+}
+
+// purgeFile removes the chunk objects, then the records, and the objects of the file and its
+// versions once nothing else references them. A failure before the records are gone leaves the file claimed
+// so that it is purged again.
+func purgeFile(ctx context.Context, metadataSvc service.Service, objectStore storage.ObjectStore, file *metadata.FileMetadata) error {
+	objects, err := objectStore.List(ctx, chunk_upload.ChunkPrefix(file.UserID, file.FileID))
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := objectStore.Delete(ctx, obj.Key); err != nil {
+			return err
+		}
+	}
+
+	orphans, err := metadataSvc.PurgeFile(ctx, file.FileID)
+	if err != nil {
+		return err
+	}
+	for _, storagePath := range orphans {
+		if err := objectStore.Delete(ctx, storagePath); err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			return err
+		}
+	}
+	return nil
+}
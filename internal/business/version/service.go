@@ -0,0 +1,105 @@
+package version
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"github.com/roamBo/BoCloudStore/internal/security/authz"
+	"github.com/roamBo/BoCloudStore/internal/storage"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// for error
+var (
+	ErrInvalidPolicy = errors.New("invalid max versions")
+)
+
+// History is the current content of a file followed by its previous versions, newest first
+type History struct {
+	Current  *metadata.FileMetadata
+	Previous []*metadata.FileVersion
+}
+
+type Service interface {
+	ListVersions(ctx context.Context, fileID string, userID string) (*History, error)
+	RestoreVersion(ctx context.Context, fileID string, version int, userID string) (*metadata.FileMetadata, error)
+	GetMaxVersions(ctx context.Context, userID string) (int, error)
+	SetMaxVersions(ctx context.Context, userID string, maxVersions int) error
+}
+
+type versionService struct {
+	metadataSvc service.Service
+	authorizer  authz.Authorizer
+	objectStore storage.ObjectStore
+	logger      *zap.Logger
+	limit       int //upper bound for a user's max versions
+}
+
+func NewService(metadataSvc service.Service, authorizer authz.Authorizer, objectStore storage.ObjectStore, logger *zap.Logger, limit int) Service {
+	return &versionService{
+		metadataSvc: metadataSvc,
+		authorizer:  authorizer,
+		objectStore: objectStore,
+		logger:      logger,
+		limit:       limit,
+	}
+}
+
+func (s *versionService) ListVersions(ctx context.Context, fileID string, userID string) (*History, error) {
+	fileMeta, err := s.mergedFile(ctx, fileID, userID, authz.ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := s.metadataSvc.ListFileVersions(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return &History{Current: fileMeta, Previous: versions}, nil
+}
+
+// RestoreVersion makes a previous version the current content, the replaced content becomes the newest version
+func (s *versionService) RestoreVersion(ctx context.Context, fileID string, version int, userID string) (*metadata.FileMetadata, error) {
+	if _, err := s.mergedFile(ctx, fileID, userID, authz.ActionWrite); err != nil {
+		return nil, err
+	}
+	fileMeta, orphans, err := s.metadataSvc.RestoreFileVersion(ctx, fileID, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range orphans {
+		if err := s.objectStore.Delete(ctx, path); err != nil {
+			utils.LoggerFrom(ctx, s.logger).Warn("failed to remove pruned version object",
+				zap.Error(err),
+				zap.String("path", path))
+		}
+	}
+	return fileMeta, nil
+}
+
+func (s *versionService) GetMaxVersions(ctx context.Context, userID string) (int, error) {
+	return s.metadataSvc.GetMaxVersions(ctx, userID)
+}
+
+func (s *versionService) SetMaxVersions(ctx context.Context, userID string, maxVersions int) error {
+	if maxVersions < 0 || maxVersions > s.limit {
+		return ErrInvalidPolicy
+	}
+	return s.metadataSvc.SetMaxVersions(ctx, userID, maxVersions)
+}
+
+func (s *versionService) mergedFile(ctx context.Context, fileID string, userID string, action string) (*metadata.FileMetadata, error) {
+	fileMeta, err := s.metadataSvc.GetFileMetadata(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizer.Authorize(ctx, authz.User(userID), action, authz.FileResource(fileMeta)); err != nil {
+		return nil, err
+	}
+	if fileMeta.Status != metadata.StatusMerged {
+		return nil, service.ErrFileNotMerged
+	}
+	return fileMeta, nil
+}
@@ -7,9 +7,13 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/observability/monitoring"
 	"go.uber.org/zap"
 )
 
+// fileMetadataCache labels the lookups of file metadata in the cache metrics
+const fileMetadataCache = "file_metadata"
+
 type RedisCache struct {
 	client        *redis.Client
 	logger        *zap.Logger
@@ -29,8 +33,10 @@ func (c *RedisCache) GetFileMetadata(ctx context.Context, fileID string) (*metad
 	data, err := c.client.Get(ctx, key).Bytes()
 	if err != nil {
 		if err == redis.Nil {
+			monitoring.CacheMiss(fileMetadataCache, 1)
 			return nil, nil
 		}
+		monitoring.CacheError(fileMetadataCache, 1)
 		c.logger.Error("failed to get file metadata",
 			zap.String("fileID", fileID),
 			zap.Error(err),
@@ -44,6 +50,7 @@ func (c *RedisCache) GetFileMetadata(ctx context.Context, fileID string) (*metad
 			zap.Error(err),
 		)
 	}
+	monitoring.CacheHit(fileMetadataCache, 1)
 	return &fileMetadata, nil
 }
 
@@ -81,6 +88,9 @@ func (c *RedisCache) DeleteFileMetadata(ctx context.Context, fileID string) erro
 }
 
 func (c *RedisCache) BatchGet(ctx context.Context, fileIDs []string) (map[string]*metadata.FileMetadata, error) {
+	if len(fileIDs) == 0 {
+		return map[string]*metadata.FileMetadata{}, nil
+	}
 	keys := make([]string, len(fileIDs))
 	for i, fileID := range fileIDs {
 		keys[i] = "file: metadata:" + fileID
@@ -88,6 +98,7 @@ func (c *RedisCache) BatchGet(ctx context.Context, fileIDs []string) (map[string
 
 	results, err := c.client.MGet(ctx, keys...).Result()
 	if err != nil {
+		monitoring.CacheError(fileMetadataCache, len(keys))
 		c.logger.Error("failed to get batch metadata", zap.Error(err))
 		return nil, err
 	}
@@ -107,5 +118,7 @@ func (c *RedisCache) BatchGet(ctx context.Context, fileIDs []string) (map[string
 		}
 		metaMap[fileIDs[i]] = &fileMetadata
 	}
+	monitoring.CacheHit(fileMetadataCache, len(metaMap))
+	monitoring.CacheMiss(fileMetadataCache, len(keys)-len(metaMap))
 	return metaMap, nil
 }
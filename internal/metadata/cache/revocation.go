@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// RevocationList holds the ids (jti) of access tokens revoked before they expire
+type RevocationList interface {
+	// Revoke blocks jti for ttl, which should be the remaining lifetime of the token
+	Revoke(ctx context.Context, jti string, ttl time.Duration) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+type RedisRevocationList struct {
+	client *redis.Client
+	logger *zap.Logger
+}
+
+func NewRedisRevocationList(client *redis.Client, logger *zap.Logger) *RedisRevocationList {
+	return &RedisRevocationList{
+		client: client,
+		logger: logger,
+	}
+}
+
+func (r *RedisRevocationList) Revoke(ctx context.Context, jti string, ttl time.Duration) error {
+	// an expired token is rejected anyway
+	if ttl <= 0 {
+		return nil
+	}
+	if err := r.client.Set(ctx, revokedKey(jti), 1, ttl).Err(); err != nil {
+		r.logger.Error("failed to revoke token",
+			zap.String("jti", jti),
+			zap.Error(err),
+		)
+		return err
+	}
+	return nil
+}
+
+func (r *RedisRevocationList) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	count, err := r.client.Exists(ctx, revokedKey(jti)).Result()
+	if err != nil {
+		r.logger.Error("failed to check token revocation",
+			zap.String("jti", jti),
+			zap.Error(err),
+		)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func revokedKey(jti string) string {
+	return "token:revoked:" + jti
+}
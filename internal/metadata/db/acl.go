@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+)
+
+const grantColumns = `resource_type, resource_id, user_id, role, granted_by, create_at`
+
+// UpsertGrant gives a user a role on a resource, replacing a role granted before
+func (p *postgresStore) UpsertGrant(ctx context.Context, grant *metadata.Grant) error {
+	query := `
+		INSERT INTO acl_entry (` + grantColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (resource_type, resource_id, user_id)
+		DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by, create_at = EXCLUDED.create_at
+	`
+
+	grant.CreateAt = time.Now().Unix()
+	_, err := p.db.ExecContext(ctx, query,
+		grant.ResourceType, grant.ResourceID, grant.UserID, grant.Role, grant.GrantedBy, grant.CreateAt)
+	if err != nil {
+		return fmt.Errorf("failed to upsert grant: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) DeleteGrant(ctx context.Context, resourceType, resourceID, userID string) error {
+	result, err := p.db.ExecContext(ctx,
+		`DELETE FROM acl_entry WHERE resource_type = $1 AND resource_id = $2 AND user_id = $3`,
+		resourceType, resourceID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete grant: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrGrantNotFound
+	}
+	return nil
+}
+
+// ListGrants lists the grants made directly on a resource
+func (p *postgresStore) ListGrants(ctx context.Context, resourceType, resourceID string) ([]*metadata.Grant, error) {
+	query := `SELECT ` + grantColumns + `
+		FROM acl_entry
+		WHERE resource_type = $1 AND resource_id = $2
+		ORDER BY create_at
+	`
+	return p.queryGrants(ctx, query, resourceType, resourceID)
+}
+
+// ListUserGrants lists the grants userID received, newest first
+func (p *postgresStore) ListUserGrants(ctx context.Context, userID string) ([]*metadata.Grant, error) {
+	query := `SELECT ` + grantColumns + `
+		FROM acl_entry
+		WHERE user_id = $1
+		ORDER BY create_at DESC
+	`
+	return p.queryGrants(ctx, query, userID)
+}
+
+// ListRoles returns the roles userID holds on a resource, granted on the resource itself or on
+// folderID or any folder above it. folderID is the folder holding a file, or the folder itself.
+func (p *postgresStore) ListRoles(ctx context.Context, userID, resourceType, resourceID, folderID string) ([]string, error) {
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT folder_id, parent_id FROM folder WHERE folder_id = $4
+			UNION ALL
+			SELECT f.folder_id, f.parent_id FROM folder f JOIN ancestors a ON f.folder_id = a.parent_id
+		)
+		SELECT DISTINCT role
+		FROM acl_entry
+		WHERE user_id = $1 AND (
+			(resource_type = $2 AND resource_id = $3)
+			OR (resource_type = 'folder' AND resource_id IN (SELECT folder_id FROM ancestors))
+		)
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, userID, resourceType, resourceID, folderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	roles, err := scanPaths(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	return roles, nil
+}
+
+func (p *postgresStore) queryGrants(ctx context.Context, query string, args ...interface{}) ([]*metadata.Grant, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grants: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []*metadata.Grant
+	for rows.Next() {
+		grant := &metadata.Grant{}
+		if err := rows.Scan(&grant.ResourceType, &grant.ResourceID, &grant.UserID, &grant.Role,
+			&grant.GrantedBy, &grant.CreateAt); err != nil {
+			return nil, fmt.Errorf("failed to scan grant: %w", err)
+		}
+		grants = append(grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list grants: %w", err)
+	}
+	return grants, nil
+}
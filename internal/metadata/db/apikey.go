@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+)
+
+const apiKeyColumns = `
+	key_id, key_hash, prefix, user_id, name, kind, scopes,
+	created_by, expires_at, last_used_at, revoked_at, create_at
+`
+
+func (p *postgresStore) InsertAPIKey(ctx context.Context, key *metadata.APIKey) error {
+	query := `INSERT INTO api_key (` + apiKeyColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+
+	if key.CreateAt == 0 {
+		key.CreateAt = time.Now().Unix()
+	}
+	_, err := p.db.ExecContext(ctx, query,
+		key.KeyID, key.KeyHash, key.Prefix, key.UserID, key.Name, key.Kind, pq.Array(key.Scopes),
+		key.CreatedBy, key.ExpiresAt, key.LastUsedAt, key.RevokedAt, key.CreateAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert api key: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) GetAPIKey(ctx context.Context, keyID string) (*metadata.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_key WHERE key_id = $1`
+	return p.getAPIKey(ctx, query, keyID)
+}
+
+func (p *postgresStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (*metadata.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_key WHERE key_hash = $1`
+	return p.getAPIKey(ctx, query, keyHash)
+}
+
+func (p *postgresStore) getAPIKey(ctx context.Context, query, arg string) (*metadata.APIKey, error) {
+	key, err := scanAPIKey(p.db.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve api key: %w", err)
+	}
+	return key, nil
+}
+
+// ListAPIKeys lists the keys of userID including revoked ones, newest first. An empty
+// userID lists the keys of every user.
+func (p *postgresStore) ListAPIKeys(ctx context.Context, userID string) ([]*metadata.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + `
+		FROM api_key
+		WHERE $1 = '' OR user_id = $1
+		ORDER BY create_at DESC
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*metadata.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an active key, ErrAPIKeyNotFound if it does not exist or was revoked before
+func (p *postgresStore) RevokeAPIKey(ctx context.Context, keyID string) error {
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE api_key SET revoked_at = $1 WHERE key_id = $2 AND revoked_at = 0`,
+		time.Now().Unix(), keyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records when a key was last used
+func (p *postgresStore) TouchAPIKey(ctx context.Context, keyID string, usedAt int64) error {
+	_, err := p.db.ExecContext(ctx, `UPDATE api_key SET last_used_at = $1 WHERE key_id = $2`, usedAt, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to update api key: %w", err)
+	}
+	return nil
+}
+
+func scanAPIKey(row rowScanner) (*metadata.APIKey, error) {
+	key := &metadata.APIKey{}
+	err := row.Scan(&key.KeyID, &key.KeyHash, &key.Prefix, &key.UserID, &key.Name, &key.Kind, pq.Array(&key.Scopes),
+		&key.CreatedBy, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreateAt)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
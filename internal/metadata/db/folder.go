@@ -0,0 +1,340 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+)
+
+const folderColumns = `folder_id, user_id, parent_id, name, create_at, update_at`
+
+func (p *postgresStore) InsertFolder(ctx context.Context, folder *metadata.Folder) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockNamespace(ctx, tx, folder.UserID); err != nil {
+		return err
+	}
+	if err := checkParentFolder(ctx, tx, folder.UserID, folder.ParentID); err != nil {
+		return err
+	}
+	if err := checkFileNameFree(ctx, tx, folder.UserID, folder.ParentID, folder.Name); err != nil {
+		return err
+	}
+
+	currentTime := time.Now().Unix()
+	if folder.CreateAt == 0 {
+		folder.CreateAt = currentTime
+	}
+	folder.UpdateAt = currentTime
+
+	query := `INSERT INTO folder (` + folderColumns + `) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.ExecContext(ctx, query,
+		folder.FolderID, folder.UserID, folder.ParentID, folder.Name, folder.CreateAt, folder.UpdateAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return fmt.Errorf("failed to insert folder: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) GetFolder(ctx context.Context, folderID string) (*metadata.Folder, error) {
+	query := `SELECT ` + folderColumns + ` FROM folder WHERE folder_id = $1`
+
+	folder, err := scanFolder(p.db.QueryRowContext(ctx, query, folderID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrFolderNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve folder: %w", err)
+	}
+	return folder, nil
+}
+
+// FindFolderByName returns the folder called name below parentID, or nil if there is none
+func (p *postgresStore) FindFolderByName(ctx context.Context, userID, parentID, name string) (*metadata.Folder, error) {
+	query := `SELECT ` + folderColumns + `
+		FROM folder
+		WHERE user_id = $1 AND parent_id = $2 AND name = $3
+	`
+
+	folder, err := scanFolder(p.db.QueryRowContext(ctx, query, userID, parentID, name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find folder: %w", err)
+	}
+	return folder, nil
+}
+
+func (p *postgresStore) ListFolders(ctx context.Context, userID, parentID string) ([]*metadata.Folder, error) {
+	query := `SELECT ` + folderColumns + `
+		FROM folder
+		WHERE user_id = $1 AND parent_id = $2
+		ORDER BY name
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, userID, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+	defer rows.Close()
+
+	var folders []*metadata.Folder
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan folder: %w", err)
+		}
+		folders = append(folders, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+	return folders, nil
+}
+
+// UpdateFolder renames and/or moves a folder. A move only rewrites the folder's parent,
+// so a whole subtree moves in one transaction without touching any object.
+func (p *postgresStore) UpdateFolder(ctx context.Context, folderID, parentID, name string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	folder, err := scanFolder(tx.QueryRowContext(ctx, `SELECT `+folderColumns+` FROM folder WHERE folder_id = $1`, folderID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrFolderNotFound
+		}
+		return fmt.Errorf("failed to retrieve folder: %w", err)
+	}
+	if err := lockNamespace(ctx, tx, folder.UserID); err != nil {
+		return err
+	}
+	if parentID != folder.ParentID {
+		if err := checkParentFolder(ctx, tx, folder.UserID, parentID); err != nil {
+			return err
+		}
+		if err := checkNotDescendant(ctx, tx, folderID, parentID); err != nil {
+			return err
+		}
+	}
+	if err := checkFileNameFree(ctx, tx, folder.UserID, parentID, name); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE folder
+		SET parent_id = $1, name = $2, update_at = $3
+		WHERE folder_id = $4
+	`
+	if _, err := tx.ExecContext(ctx, query, parentID, name, time.Now().Unix(), folderID); err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return fmt.Errorf("failed to update folder: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// FindFileByName returns the merged file called name in folderID, or nil if there is none
+func (p *postgresStore) FindFileByName(ctx context.Context, userID, folderID, name string) (*metadata.FileMetadata, error) {
+	query := `SELECT ` + fileColumns + `
+		FROM file_metadata
+		WHERE user_id = $1 AND folder_id = $2 AND filename = $3 AND status = $4
+	`
+
+	file, err := scanFile(p.db.QueryRowContext(ctx, query, userID, folderID, name, metadata.StatusMerged))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find file: %w", err)
+	}
+	return file, nil
+}
+
+// ListFolderFiles lists the merged files directly inside folderID
+func (p *postgresStore) ListFolderFiles(ctx context.Context, userID, folderID string) ([]*metadata.FileMetadata, error) {
+	query := `SELECT ` + fileColumns + `
+		FROM file_metadata
+		WHERE user_id = $1 AND folder_id = $2 AND status = $3
+		ORDER BY filename
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, userID, folderID, metadata.StatusMerged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []*metadata.FileMetadata
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file metadata: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	return files, nil
+}
+
+// UpdateFileLocation renames and/or moves a file, the object is left untouched
+func (p *postgresStore) UpdateFileLocation(ctx context.Context, fileID, folderID, name string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID string
+	if err := tx.QueryRowContext(ctx, `SELECT user_id FROM file_metadata WHERE file_id = $1`, fileID).Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("file not found: %s", fileID)
+		}
+		return fmt.Errorf("failed to retrieve file metadata: %w", err)
+	}
+	if err := lockNamespace(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := checkParentFolder(ctx, tx, userID, folderID); err != nil {
+		return err
+	}
+	if err := checkFolderNameFree(ctx, tx, userID, folderID, name); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE file_metadata
+		SET folder_id = $1, filename = $2, update_at = $3
+		WHERE file_id = $4
+	`
+	if _, err := tx.ExecContext(ctx, query, folderID, name, time.Now().Unix(), fileID); err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return fmt.Errorf("failed to update file location: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func scanFolder(row rowScanner) (*metadata.Folder, error) {
+	folder := &metadata.Folder{}
+	err := row.Scan(&folder.FolderID, &folder.UserID, &folder.ParentID, &folder.Name, &folder.CreateAt, &folder.UpdateAt)
+	if err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+// lockNamespace serializes namespace changes of one user until the transaction ends,
+// which keeps the cross table name checks and the cycle check free of races
+func lockNamespace(ctx context.Context, q querier, userID string) error {
+	if _, err := q.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, userID); err != nil {
+		return fmt.Errorf("failed to lock namespace: %w", err)
+	}
+	return nil
+}
+
+// checkParentFolder makes sure parentID is the root or an existing folder of userID
+func checkParentFolder(ctx context.Context, q querier, userID, parentID string) error {
+	if parentID == "" {
+		return nil
+	}
+	var exists bool
+	err := q.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM folder WHERE folder_id = $1 AND user_id = $2)`, parentID, userID).
+		Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check parent folder: %w", err)
+	}
+	if !exists {
+		return ErrFolderNotFound
+	}
+	return nil
+}
+
+// checkNotDescendant rejects moving folderID below itself
+func checkNotDescendant(ctx context.Context, q querier, folderID, parentID string) error {
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT folder_id, parent_id FROM folder WHERE folder_id = $1
+			UNION ALL
+			SELECT f.folder_id, f.parent_id FROM folder f JOIN ancestors a ON f.folder_id = a.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE folder_id = $2)
+	`
+	var cycle bool
+	if err := q.QueryRowContext(ctx, query, parentID, folderID).Scan(&cycle); err != nil {
+		return fmt.Errorf("failed to check folder ancestry: %w", err)
+	}
+	if cycle {
+		return ErrFolderCycle
+	}
+	return nil
+}
+
+// checkFileNameFree a folder may not share its name with a file in the same parent
+func checkFileNameFree(ctx context.Context, q querier, userID, parentID, name string) error {
+	var exists bool
+	err := q.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM file_metadata WHERE user_id = $1 AND folder_id = $2 AND filename = $3 AND status = $4)`,
+		userID, parentID, name, metadata.StatusMerged).
+		Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check file name: %w", err)
+	}
+	if exists {
+		return ErrDuplicateName
+	}
+	return nil
+}
+
+// checkFolderNameFree a file may not share its name with a folder in the same parent
+func checkFolderNameFree(ctx context.Context, q querier, userID, parentID, name string) error {
+	var exists bool
+	err := q.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM folder WHERE user_id = $1 AND parent_id = $2 AND name = $3)`,
+		userID, parentID, name).
+		Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check folder name: %w", err)
+	}
+	if exists {
+		return ErrDuplicateName
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+)
+
+// sortColumns maps the listing sort keys to their columns, file_id breaks ties
+var sortColumns = map[string]string{
+	metadata.SortByName: "filename",
+	metadata.SortBySize: "total_size",
+	metadata.SortByDate: "create_at",
+}
+
+// ListFileIDs returns the ids of one page of files in listing order and the cursor of the
+// next page, nil when this is the last one. Only ids are read so that warm rows come from the cache.
+func (p *postgresStore) ListFileIDs(ctx context.Context, q *metadata.FileListQuery) ([]string, *metadata.FileCursor, error) {
+	sortColumn, ok := sortColumns[q.SortBy]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sort key: %s", q.SortBy)
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where = append(where, "user_id = "+arg(q.UserID))
+	if len(q.Statuses) > 0 {
+		where = append(where, "status = ANY("+arg(pq.Array(q.Statuses))+")")
+	} else {
+		where = append(where, "status NOT IN ("+arg(metadata.StatusExpiring)+", "+arg(metadata.StatusExpired)+", "+
+			arg(metadata.StatusTrashed)+", "+arg(metadata.StatusPurging)+")")
+	}
+	if q.FolderID != nil {
+		where = append(where, "folder_id = "+arg(*q.FolderID))
+	}
+	if q.NamePrefix != "" {
+		where = append(where, "filename LIKE "+arg(escapeLike(q.NamePrefix)+"%"))
+	}
+	if q.MinSize != nil {
+		where = append(where, "total_size >= "+arg(*q.MinSize))
+	}
+	if q.MaxSize != nil {
+		where = append(where, "total_size <= "+arg(*q.MaxSize))
+	}
+	if q.CreatedAfter != nil {
+		where = append(where, "create_at >= "+arg(*q.CreatedAfter))
+	}
+	if q.CreatedBefore != nil {
+		where = append(where, "create_at < "+arg(*q.CreatedBefore))
+	}
+
+	direction, compare := "ASC", ">"
+	if q.Desc {
+		direction, compare = "DESC", "<"
+	}
+	if q.After != nil {
+		var value interface{}
+		switch q.SortBy {
+		case metadata.SortByName:
+			value = q.After.Name
+		case metadata.SortBySize:
+			value = q.After.Size
+		default:
+			value = q.After.CreateAt
+		}
+		where = append(where, fmt.Sprintf("(%s, file_id) %s (%s, %s)", sortColumn, compare, arg(value), arg(q.After.FileID)))
+	}
+
+	// one extra row tells whether another page follows
+	query := fmt.Sprintf(`
+		SELECT file_id, filename, total_size, create_at
+		FROM file_metadata
+		WHERE %s
+		ORDER BY %s %s, file_id %s
+		LIMIT %s
+	`, strings.Join(where, " AND "), sortColumn, direction, direction, arg(q.Limit+1))
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	defer rows.Close()
+
+	var fileIDs []string
+	var last metadata.FileCursor
+	hasMore := false
+	for rows.Next() {
+		if len(fileIDs) == q.Limit {
+			hasMore = true
+			break
+		}
+		row := metadata.FileCursor{SortBy: q.SortBy, Desc: q.Desc}
+		if err := rows.Scan(&row.FileID, &row.Name, &row.Size, &row.CreateAt); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan file id: %w", err)
+		}
+		fileIDs = append(fileIDs, row.FileID)
+		last = row
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	if !hasMore {
+		return fileIDs, nil, nil
+	}
+
+	// the cursor only carries the value of the key the listing is sorted by
+	next := &metadata.FileCursor{SortBy: last.SortBy, Desc: last.Desc, FileID: last.FileID}
+	switch q.SortBy {
+	case metadata.SortByName:
+		next.Name = last.Name
+	case metadata.SortBySize:
+		next.Size = last.Size
+	default:
+		next.CreateAt = last.CreateAt
+	}
+	return fileIDs, next, nil
+}
+
+// GetFiles fetches the files with the given ids, in no particular order
+func (p *postgresStore) GetFiles(ctx context.Context, fileIDs []string) ([]*metadata.FileMetadata, error) {
+	query := `SELECT ` + fileColumns + `
+		FROM file_metadata
+		WHERE file_id = ANY($1)
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, pq.Array(fileIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve files: %w", err)
+	}
+	defer rows.Close()
+
+	files := make([]*metadata.FileMetadata, 0, len(fileIDs))
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file metadata: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve files: %w", err)
+	}
+	return files, nil
+}
+
+// escapeLike escapes the LIKE wildcards so a prefix matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -3,16 +3,134 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/roamBo/BoCloudStore/internal/metadata"
 	"time"
 )
 
+// for error
+var (
+	ErrObjectNotFound       = errors.New("object reference not found")
+	ErrFolderNotFound       = errors.New("folder not found")
+	ErrDuplicateName        = errors.New("name already exists in folder")
+	ErrFolderCycle          = errors.New("folder cannot be moved into itself")
+	ErrFileNotTrashed       = errors.New("file is not in trash")
+	ErrVersionNotFound      = errors.New("file version not found")
+	ErrFileNotMerged        = errors.New("file is not merged")
+	ErrShareNotFound        = errors.New("share not found")
+	ErrShareLimitReached    = errors.New("share download limit reached")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrDuplicateUser        = errors.New("username already exists")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenUsed     = errors.New("refresh token already used")
+	ErrGrantNotFound        = errors.New("grant not found")
+	ErrAPIKeyNotFound       = errors.New("api key not found")
+	ErrQuotaExceeded        = errors.New("storage quota exceeded")
+	ErrQuotaNotFound        = errors.New("quota not found")
+	ErrGroupMemberNotFound  = errors.New("user is not a member of the group")
+	ErrUploadNotActive      = errors.New("upload is not in progress")
+)
+
 type PostgresStore interface {
-	InsertFile(ctx context.Context, file *metadata.FileMetadata) error
+	InsertFile(ctx context.Context, file *metadata.FileMetadata, defaultQuota metadata.Quota) error
 	InsertChunk(ctx context.Context, chunk *metadata.ChunkMetadata) error
+	ListChunks(ctx context.Context, fileID string) ([]*metadata.ChunkMetadata, error)
+	DeleteChunk(ctx context.Context, fileID string, chunkID int) error
+	DeleteChunks(ctx context.Context, fileID string) error
 	GetFile(ctx context.Context, fileID string) (*metadata.FileMetadata, error)
 	UpdateFileStatus(ctx context.Context, fileID, status string) error
+	ExpireUpload(ctx context.Context, fileID string) error
+	CompleteFile(ctx context.Context, fileID, storagePath, etag string, defaultMaxVersions int) (*metadata.FileMetadata, []string, error)
+	FindMergedFileByHash(ctx context.Context, userID, contentHash string, totalSize int64) (*metadata.FileMetadata, error)
+	InsertFileReference(ctx context.Context, file *metadata.FileMetadata, defaultMaxVersions int, defaultQuota metadata.Quota) (*metadata.FileMetadata, []string, error)
+	ReleaseObject(ctx context.Context, storagePath string) (int64, error)
+	ClaimStaleUploads(ctx context.Context, staleBefore int64, limit int) ([]*metadata.FileMetadata, error)
+	ListFileIDs(ctx context.Context, query *metadata.FileListQuery) ([]string, *metadata.FileCursor, error)
+	GetFiles(ctx context.Context, fileIDs []string) ([]*metadata.FileMetadata, error)
+	TrashFile(ctx context.Context, fileID string) error
+	RestoreFile(ctx context.Context, fileID string) error
+	ClaimTrashedFiles(ctx context.Context, trashedBefore, claimStaleBefore int64, limit int) ([]*metadata.FileMetadata, error)
+	ClaimUserTrash(ctx context.Context, userID string) ([]*metadata.FileMetadata, error)
+	PurgeFile(ctx context.Context, fileID string) ([]string, error)
+	ListFileVersions(ctx context.Context, fileID string) ([]*metadata.FileVersion, error)
+	GetFileVersion(ctx context.Context, fileID string, version int) (*metadata.FileVersion, error)
+	RestoreFileVersion(ctx context.Context, fileID string, version int, defaultMaxVersions int, defaultQuota metadata.Quota) (*metadata.FileMetadata, []string, error)
+	GetMaxVersions(ctx context.Context, userID string, defaultMaxVersions int) (int, error)
+	SetMaxVersions(ctx context.Context, userID string, maxVersions int) error
+
+	InsertShare(ctx context.Context, share *metadata.Share) error
+	GetShareByToken(ctx context.Context, token string) (*metadata.Share, error)
+	ListShares(ctx context.Context, userID string) ([]*metadata.Share, error)
+	RevokeShare(ctx context.Context, shareID, userID string) error
+	ConsumeShareDownload(ctx context.Context, shareID string) error
+	IsFolderWithin(ctx context.Context, folderID, ancestorID string) (bool, error)
+
+	InsertUser(ctx context.Context, user *metadata.User) error
+	GetUser(ctx context.Context, userID string) (*metadata.User, error)
+	GetUserByName(ctx context.Context, username string) (*metadata.User, error)
+	SetUserRole(ctx context.Context, userID, role string) error
+
+	UpsertGrant(ctx context.Context, grant *metadata.Grant) error
+	DeleteGrant(ctx context.Context, resourceType, resourceID, userID string) error
+	ListGrants(ctx context.Context, resourceType, resourceID string) ([]*metadata.Grant, error)
+	ListUserGrants(ctx context.Context, userID string) ([]*metadata.Grant, error)
+	ListRoles(ctx context.Context, userID, resourceType, resourceID, folderID string) ([]string, error)
+
+	InsertRefreshToken(ctx context.Context, token *metadata.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*metadata.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, usedID string, next *metadata.RefreshToken) error
+	RevokeTokenFamily(ctx context.Context, familyID string) ([]*metadata.RefreshToken, error)
+	RevokeUserTokens(ctx context.Context, userID string) ([]*metadata.RefreshToken, error)
+
+	InsertAPIKey(ctx context.Context, key *metadata.APIKey) error
+	GetAPIKey(ctx context.Context, keyID string) (*metadata.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*metadata.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]*metadata.APIKey, error)
+	RevokeAPIKey(ctx context.Context, keyID string) error
+	TouchAPIKey(ctx context.Context, keyID string, usedAt int64) error
+
+	GetUsage(ctx context.Context, userID string) (*metadata.Usage, error)
+	GetGroupUsage(ctx context.Context, groupName string) (*metadata.Usage, error)
+	RecomputeUsage(ctx context.Context, userID string) (*metadata.Usage, error)
+	GetQuota(ctx context.Context, subjectType, subjectID string) (*metadata.Quota, error)
+	SetQuota(ctx context.Context, quota *metadata.Quota) error
+	DeleteQuota(ctx context.Context, subjectType, subjectID string) error
+	AddGroupMember(ctx context.Context, groupName, userID string) error
+	RemoveGroupMember(ctx context.Context, groupName, userID string) error
+	ListGroupMembers(ctx context.Context, groupName string) ([]string, error)
+	ListUserGroups(ctx context.Context, userID string) ([]string, error)
+
+	InsertFolder(ctx context.Context, folder *metadata.Folder) error
+	GetFolder(ctx context.Context, folderID string) (*metadata.Folder, error)
+	FindFolderByName(ctx context.Context, userID, parentID, name string) (*metadata.Folder, error)
+	ListFolders(ctx context.Context, userID, parentID string) ([]*metadata.Folder, error)
+	UpdateFolder(ctx context.Context, folderID, parentID, name string) error
+	FindFileByName(ctx context.Context, userID, folderID, name string) (*metadata.FileMetadata, error)
+	ListFolderFiles(ctx context.Context, userID, folderID string) ([]*metadata.FileMetadata, error)
+	UpdateFileLocation(ctx context.Context, fileID, folderID, name string) error
+}
+
+const fileColumns = `
+	file_id, filename, total_size, chunk_count,
+	chunk_size, status, user_id, folder_id, storage_path, etag, content_hash,
+	version, create_at, update_at
+`
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	execer
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
 type postgresStore struct {
@@ -23,12 +141,34 @@ func NewPostgresStore(db *sql.DB) PostgresStore {
 	return &postgresStore{db: db}
 }
 
-func (p *postgresStore) InsertFile(ctx context.Context, file *metadata.FileMetadata) error {
-	query := `
-		INSERT INTO file_metadata (
-			file_id, filename, total_size, chunk_count, 
-			chunk_size, status, user_id, create_at, update_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+// InsertFile inserts a file and charges it to its user, an upload reserves its declared size.
+// It fails with ErrQuotaExceeded when that takes the user or one of its groups over quota.
+func (p *postgresStore) InsertFile(ctx context.Context, file *metadata.FileMetadata, defaultQuota metadata.Quota) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertFile(ctx, tx, file); err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return fmt.Errorf("failed to insert file: %w", err)
+	}
+	if err := chargeUsage(ctx, tx, file.UserID, chargedDelta(file.Status, file.TotalSize), defaultQuota); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func insertFile(ctx context.Context, ex execer, file *metadata.FileMetadata) error {
+	query := `INSERT INTO file_metadata (` + fileColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 	`
 
 	currentTime := time.Now().Unix()
@@ -36,29 +176,37 @@ func (p *postgresStore) InsertFile(ctx context.Context, file *metadata.FileMetad
 		file.CreateAt = currentTime
 	}
 	file.UpdateAt = currentTime
+	if file.Version == 0 {
+		file.Version = 1
+	}
 
-	_, err := p.db.ExecContext(
+	_, err := ex.ExecContext(
 		ctx, query,
 		file.FileID, file.FileName, file.TotalSize, file.ChunkCount,
-		file.ChunkSize, file.Status, file.UserID, file.CreateAt, file.UpdateAt,
+		file.ChunkSize, file.Status, file.UserID, file.FolderID, file.StoragePath, file.ETag, file.ContentHash,
+		file.Version, file.CreateAt, file.UpdateAt,
 	)
-
-	if err != nil {
-		return fmt.Errorf("failed to insert file: %w", err)
-	}
-	return nil
+	return err
 }
 
 func (p *postgresStore) InsertChunk(ctx context.Context, chunk *metadata.ChunkMetadata) error {
+	// every stored chunk keeps the upload alive for the reaper
 	query := `
+		WITH touched AS (
+			UPDATE file_metadata SET update_at = $7 WHERE file_id = $1
+		)
 		INSERT INTO chunk_metadata (
-			file_id, chunk_id, etag, size, storage_path
-		) VALUES ($1, $2, $3, $4, $5)
+			file_id, chunk_id, etag, object_etag, size, storage_path
+		) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (file_id, chunk_id) DO UPDATE
+		SET etag = EXCLUDED.etag, object_etag = EXCLUDED.object_etag,
+			size = EXCLUDED.size, storage_path = EXCLUDED.storage_path
 	`
 
 	_, err := p.db.ExecContext(
 		ctx, query,
-		chunk.FileID, chunk.ChunkID, chunk.ETag, chunk.Size, chunk.StoragePath,
+		chunk.FileID, chunk.ChunkID, chunk.ETag, chunk.ObjectETag, chunk.Size, chunk.StoragePath,
+		time.Now().Unix(),
 	)
 
 	if err != nil {
@@ -67,23 +215,65 @@ func (p *postgresStore) InsertChunk(ctx context.Context, chunk *metadata.ChunkMe
 	return nil
 }
 
-func (p *postgresStore) GetFile(ctx context.Context, fileID string) (*metadata.FileMetadata, error) {
+func (p *postgresStore) ListChunks(ctx context.Context, fileID string) ([]*metadata.ChunkMetadata, error) {
 	query := `
-		SELECT 
-			file_id, filename, total_size, chunk_count, 
-			chunk_size, status, user_id, create_at, update_at
-		FROM file_metadata
+		SELECT file_id, chunk_id, etag, object_etag, size, storage_path
+		FROM chunk_metadata
 		WHERE file_id = $1
+		ORDER BY chunk_id
 	`
 
-	row := p.db.QueryRowContext(ctx, query, fileID)
+	rows, err := p.db.QueryContext(ctx, query, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunk metadata: %w", err)
+	}
+	defer rows.Close()
 
-	file := &metadata.FileMetadata{}
-	err := row.Scan(
-		&file.FileID, &file.FileName, &file.TotalSize, &file.ChunkCount,
-		&file.ChunkSize, &file.Status, &file.UserID, &file.CreateAt, &file.UpdateAt,
-	)
+	var chunks []*metadata.ChunkMetadata
+	for rows.Next() {
+		chunk := &metadata.ChunkMetadata{}
+		if err := rows.Scan(&chunk.FileID, &chunk.ChunkID, &chunk.ETag, &chunk.ObjectETag, &chunk.Size, &chunk.StoragePath); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk metadata: %w", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list chunk metadata: %w", err)
+	}
+	return chunks, nil
+}
+
+func (p *postgresStore) DeleteChunk(ctx context.Context, fileID string, chunkID int) error {
+	query := `
+		DELETE FROM chunk_metadata
+		WHERE file_id = $1 AND chunk_id = $2
+	`
+
+	if _, err := p.db.ExecContext(ctx, query, fileID, chunkID); err != nil {
+		return fmt.Errorf("failed to delete chunk metadata: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) DeleteChunks(ctx context.Context, fileID string) error {
+	query := `
+		DELETE FROM chunk_metadata
+		WHERE file_id = $1
+	`
+
+	if _, err := p.db.ExecContext(ctx, query, fileID); err != nil {
+		return fmt.Errorf("failed to delete chunk metadata: %w", err)
+	}
+	return nil
+}
 
+func (p *postgresStore) GetFile(ctx context.Context, fileID string) (*metadata.FileMetadata, error) {
+	query := `SELECT ` + fileColumns + `
+		FROM file_metadata
+		WHERE file_id = $1
+	`
+
+	file, err := scanFile(p.db.QueryRowContext(ctx, query, fileID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("file not found: %s", fileID)
@@ -93,6 +283,19 @@ func (p *postgresStore) GetFile(ctx context.Context, fileID string) (*metadata.F
 	return file, nil
 }
 
+func scanFile(row rowScanner) (*metadata.FileMetadata, error) {
+	file := &metadata.FileMetadata{}
+	err := row.Scan(
+		&file.FileID, &file.FileName, &file.TotalSize, &file.ChunkCount,
+		&file.ChunkSize, &file.Status, &file.UserID, &file.FolderID, &file.StoragePath, &file.ETag, &file.ContentHash,
+		&file.Version, &file.CreateAt, &file.UpdateAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
 func (p *postgresStore) UpdateFileStatus(ctx context.Context, fileID, status string) error {
 	query := `
 		UPDATE file_metadata
@@ -116,3 +319,300 @@ func (p *postgresStore) UpdateFileStatus(ctx context.Context, fileID, status str
 	}
 	return nil
 }
+
+// CompleteFile marks the file merged and registers its object with a single reference. When a merged
+// file with the same name exists in the folder, the upload becomes that file's new current version
+// instead and the upload record is removed. The reservation of the upload turns into used storage.
+// It returns the resulting file and the objects left without any reference by pruning old versions.
+func (p *postgresStore) CompleteFile(ctx context.Context, fileID, storagePath, etag string, defaultMaxVersions int) (*metadata.FileMetadata, []string, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// locked so the upload reaper cannot expire the upload while it is completed
+	upload, err := scanFile(tx.QueryRowContext(ctx, `SELECT `+fileColumns+` FROM file_metadata WHERE file_id = $1 FOR UPDATE`, fileID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("no file found with ID: %s", fileID)
+		}
+		return nil, nil, fmt.Errorf("failed to complete file: %w", err)
+	}
+	// a concurrent merge of the same upload may have completed it already
+	if upload.Status != metadata.StatusUploading {
+		return nil, nil, ErrUploadNotActive
+	}
+	// the merged file enters the folder namespace, its name must not clash with a folder
+	if err := lockNamespace(ctx, tx, upload.UserID); err != nil {
+		return nil, nil, err
+	}
+	if err := checkFolderNameFree(ctx, tx, upload.UserID, upload.FolderID, upload.FileName); err != nil {
+		return nil, nil, err
+	}
+	if err := addObjectReference(ctx, tx, storagePath); err != nil {
+		return nil, nil, err
+	}
+
+	release := chargedDelta(upload.Status, upload.TotalSize).negate()
+	upload.StoragePath, upload.ETag = storagePath, etag
+	existing, err := findMergedFileByName(ctx, tx, upload.UserID, upload.FolderID, upload.FileName, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if existing != nil {
+		orphans, delta, err := pushVersion(ctx, tx, existing, upload, defaultMaxVersions)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := deleteFileRecords(ctx, tx, fileID); err != nil {
+			return nil, nil, err
+		}
+		if err := adjustUsage(ctx, tx, upload.UserID, release.add(delta)); err != nil {
+			return nil, nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return existing, orphans, nil
+	}
+
+	query := `
+		UPDATE file_metadata
+		SET status = $1, storage_path = $2, etag = $3, update_at = $4
+		WHERE file_id = $5 AND status = $6
+	`
+
+	updateAt := time.Now().Unix()
+	result, err := tx.ExecContext(ctx, query, metadata.StatusMerged, storagePath, etag, updateAt, fileID, metadata.StatusUploading)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, nil, ErrDuplicateName
+		}
+		return nil, nil, fmt.Errorf("failed to complete file: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return nil, nil, ErrUploadNotActive
+	}
+	merged := chargedDelta(metadata.StatusMerged, upload.TotalSize)
+	if err := adjustUsage(ctx, tx, upload.UserID, release.add(merged)); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	upload.Status, upload.UpdateAt = metadata.StatusMerged, updateAt
+	return upload, nil, nil
+}
+
+// FindMergedFileByHash returns a merged file of userID with the given content, or nil if there is none.
+// Other users' files are never matched, a hash alone is no proof of having the content.
+func (p *postgresStore) FindMergedFileByHash(ctx context.Context, userID, contentHash string, totalSize int64) (*metadata.FileMetadata, error) {
+	query := `SELECT ` + fileColumns + `
+		FROM file_metadata
+		WHERE user_id = $1 AND content_hash = $2 AND total_size = $3 AND status = $4
+		LIMIT 1
+	`
+
+	file, err := scanFile(p.db.QueryRowContext(ctx, query, userID, contentHash, totalSize, metadata.StatusMerged))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find file by content hash: %w", err)
+	}
+	return file, nil
+}
+
+// InsertFileReference inserts a file that shares an already stored object, the object's reference
+// count is increased in the same transaction. Like CompleteFile an existing merged file with the same
+// name gets the object as its new current version instead. The content is charged to the user right
+// away, failing with ErrQuotaExceeded over quota.
+func (p *postgresStore) InsertFileReference(ctx context.Context, file *metadata.FileMetadata, defaultMaxVersions int, defaultQuota metadata.Quota) (*metadata.FileMetadata, []string, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockNamespace(ctx, tx, file.UserID); err != nil {
+		return nil, nil, err
+	}
+	if err := checkParentFolder(ctx, tx, file.UserID, file.FolderID); err != nil {
+		return nil, nil, err
+	}
+	if err := checkFolderNameFree(ctx, tx, file.UserID, file.FolderID, file.FileName); err != nil {
+		return nil, nil, err
+	}
+	existing, err := findMergedFileByName(ctx, tx, file.UserID, file.FolderID, file.FileName, file.FileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the same content uploaded again under the same name changes nothing
+	if existing != nil && existing.StoragePath == file.StoragePath {
+		return existing, nil, nil
+	}
+
+	refQuery := `
+		UPDATE object_ref
+		SET ref_count = ref_count + 1
+		WHERE storage_path = $1 AND ref_count > 0
+	`
+	result, err := tx.ExecContext(ctx, refQuery, file.StoragePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to add object reference: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return nil, nil, ErrObjectNotFound
+	}
+
+	var orphans []string
+	var delta usageDelta
+	if existing != nil {
+		if orphans, delta, err = pushVersion(ctx, tx, existing, file, defaultMaxVersions); err != nil {
+			return nil, nil, err
+		}
+		file = existing
+	} else {
+		if err := insertFile(ctx, tx, file); err != nil {
+			if isUniqueViolation(err) {
+				return nil, nil, ErrDuplicateName
+			}
+			return nil, nil, fmt.Errorf("failed to insert file: %w", err)
+		}
+		delta = chargedDelta(file.Status, file.TotalSize)
+	}
+	if err := chargeUsage(ctx, tx, file.UserID, delta, defaultQuota); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return file, orphans, nil
+}
+
+// ReleaseObject drops one reference to the object and returns the references left,
+// the object itself may only be removed from storage once this reaches zero
+func (p *postgresStore) ReleaseObject(ctx context.Context, storagePath string) (int64, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	remaining, err := releaseObject(ctx, tx, storagePath)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return remaining, nil
+}
+
+func releaseObject(ctx context.Context, q querier, storagePath string) (int64, error) {
+	query := `
+		UPDATE object_ref
+		SET ref_count = ref_count - 1
+		WHERE storage_path = $1 AND ref_count > 0
+		RETURNING ref_count
+	`
+	var remaining int64
+	if err := q.QueryRowContext(ctx, query, storagePath).Scan(&remaining); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrObjectNotFound
+		}
+		return 0, fmt.Errorf("failed to release object reference: %w", err)
+	}
+	if remaining == 0 {
+		if _, err := q.ExecContext(ctx, `DELETE FROM object_ref WHERE storage_path = $1`, storagePath); err != nil {
+			return 0, fmt.Errorf("failed to delete object reference: %w", err)
+		}
+	}
+	return remaining, nil
+}
+
+// ExpireUpload marks an upload claimed by the reaper expired and releases its reservation. An upload
+// that was completed in the meantime is left alone.
+func (p *postgresStore) ExpireUpload(ctx context.Context, fileID string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE file_metadata
+		SET status = $1, update_at = $2
+		WHERE file_id = $3 AND status = $4
+		RETURNING user_id, total_size
+	`
+	var userID string
+	var totalSize int64
+	err = tx.QueryRowContext(ctx, query, metadata.StatusExpired, time.Now().Unix(), fileID, metadata.StatusExpiring).
+		Scan(&userID, &totalSize)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("failed to expire upload: %w", err)
+	}
+	if err := adjustUsage(ctx, tx, userID, chargedDelta(metadata.StatusExpiring, totalSize).negate()); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ClaimStaleUploads moves up to limit uploads that have not been touched since staleBefore into the
+// expiring state and returns them. Rows locked by another replica are skipped, so every upload is
+// claimed by exactly one caller. Claims left behind by a crashed replica become stale themselves
+// and are picked up again.
+func (p *postgresStore) ClaimStaleUploads(ctx context.Context, staleBefore int64, limit int) ([]*metadata.FileMetadata, error) {
+	query := `
+		UPDATE file_metadata
+		SET status = $1, update_at = $2
+		WHERE file_id IN (
+			SELECT file_id FROM file_metadata
+			WHERE status IN ($3, $1) AND update_at < $4
+			ORDER BY update_at
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + fileColumns
+
+	rows, err := p.db.QueryContext(ctx, query,
+		metadata.StatusExpiring, time.Now().Unix(), metadata.StatusUploading, staleBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim stale uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var files []*metadata.FileMetadata
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file metadata: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim stale uploads: %w", err)
+	}
+	return files, nil
+}
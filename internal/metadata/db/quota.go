@@ -0,0 +1,324 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+)
+
+// usageDelta is a change of the storage charged to a user
+type usageDelta struct {
+	usedBytes     int64
+	usedFiles     int64
+	reservedBytes int64
+	reservedFiles int64
+}
+
+// grows reports whether the change takes up more storage, only those are checked against quotas
+func (d usageDelta) grows() bool {
+	return d.usedBytes+d.reservedBytes > 0 || d.usedFiles+d.reservedFiles > 0
+}
+
+// chargedDelta is what a file in status with the given bytes is charged, merged content counts as
+// used, uploads in progress as reserved and expired uploads not at all
+func chargedDelta(status string, bytes int64) usageDelta {
+	switch status {
+	case metadata.StatusUploading, metadata.StatusExpiring:
+		return usageDelta{reservedBytes: bytes, reservedFiles: 1}
+	case metadata.StatusMerged, metadata.StatusTrashed, metadata.StatusPurging:
+		return usageDelta{usedBytes: bytes, usedFiles: 1}
+	}
+	return usageDelta{}
+}
+
+func (d usageDelta) add(o usageDelta) usageDelta {
+	return usageDelta{d.usedBytes + o.usedBytes, d.usedFiles + o.usedFiles, d.reservedBytes + o.reservedBytes, d.reservedFiles + o.reservedFiles}
+}
+
+func (d usageDelta) negate() usageDelta {
+	return usageDelta{-d.usedBytes, -d.usedFiles, -d.reservedBytes, -d.reservedFiles}
+}
+
+// GetUsage returns the storage charged to userID, a user without any record uses nothing
+func (p *postgresStore) GetUsage(ctx context.Context, userID string) (*metadata.Usage, error) {
+	query := `
+		SELECT used_bytes, used_files, reserved_bytes, reserved_files, update_at
+		FROM storage_usage
+		WHERE user_id = $1
+	`
+
+	usage := &metadata.Usage{}
+	err := p.db.QueryRowContext(ctx, query, userID).
+		Scan(&usage.UsedBytes, &usage.UsedFiles, &usage.ReservedBytes, &usage.ReservedFiles, &usage.UpdateAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to retrieve usage: %w", err)
+	}
+	return usage, nil
+}
+
+// GetGroupUsage sums the usage of the members of a group
+func (p *postgresStore) GetGroupUsage(ctx context.Context, groupName string) (*metadata.Usage, error) {
+	return groupUsage(ctx, p.db, groupName)
+}
+
+// RecomputeUsage rebuilds the usage of userID from its files, repairing drift and accounting for
+// files stored before usage was tracked
+func (p *postgresStore) RecomputeUsage(ctx context.Context, userID string) (*metadata.Usage, error) {
+	query := `
+		INSERT INTO storage_usage (user_id, used_bytes, used_files, reserved_bytes, reserved_files, update_at)
+		SELECT $1,
+			COALESCE(SUM(f.total_size + COALESCE(v.bytes, 0)) FILTER (WHERE f.status IN ($2, $3, $4)), 0),
+			COUNT(*) FILTER (WHERE f.status IN ($2, $3, $4)),
+			COALESCE(SUM(f.total_size) FILTER (WHERE f.status IN ($5, $6)), 0),
+			COUNT(*) FILTER (WHERE f.status IN ($5, $6)),
+			$7
+		FROM file_metadata f
+		LEFT JOIN (
+			SELECT file_id, SUM(total_size) AS bytes FROM file_version GROUP BY file_id
+		) v ON v.file_id = f.file_id
+		WHERE f.user_id = $1
+		ON CONFLICT (user_id) DO UPDATE SET
+			used_bytes = EXCLUDED.used_bytes,
+			used_files = EXCLUDED.used_files,
+			reserved_bytes = EXCLUDED.reserved_bytes,
+			reserved_files = EXCLUDED.reserved_files,
+			update_at = EXCLUDED.update_at
+		RETURNING used_bytes, used_files, reserved_bytes, reserved_files, update_at
+	`
+
+	usage := &metadata.Usage{}
+	err := p.db.QueryRowContext(ctx, query, userID,
+		metadata.StatusMerged, metadata.StatusTrashed, metadata.StatusPurging,
+		metadata.StatusUploading, metadata.StatusExpiring, time.Now().Unix()).
+		Scan(&usage.UsedBytes, &usage.UsedFiles, &usage.ReservedBytes, &usage.ReservedFiles, &usage.UpdateAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recompute usage: %w", err)
+	}
+	return usage, nil
+}
+
+// GetQuota returns the quota set on a user or group, ErrQuotaNotFound if there is none
+func (p *postgresStore) GetQuota(ctx context.Context, subjectType, subjectID string) (*metadata.Quota, error) {
+	query := `
+		SELECT subject_type, subject_id, max_bytes, max_files, update_at
+		FROM quota
+		WHERE subject_type = $1 AND subject_id = $2
+	`
+
+	quota := &metadata.Quota{}
+	err := p.db.QueryRowContext(ctx, query, subjectType, subjectID).
+		Scan(&quota.SubjectType, &quota.SubjectID, &quota.MaxBytes, &quota.MaxFiles, &quota.UpdateAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrQuotaNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve quota: %w", err)
+	}
+	return quota, nil
+}
+
+func (p *postgresStore) SetQuota(ctx context.Context, quota *metadata.Quota) error {
+	query := `
+		INSERT INTO quota (subject_type, subject_id, max_bytes, max_files, update_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (subject_type, subject_id)
+		DO UPDATE SET max_bytes = EXCLUDED.max_bytes, max_files = EXCLUDED.max_files, update_at = EXCLUDED.update_at
+	`
+
+	quota.UpdateAt = time.Now().Unix()
+	_, err := p.db.ExecContext(ctx, query, quota.SubjectType, quota.SubjectID, quota.MaxBytes, quota.MaxFiles, quota.UpdateAt)
+	if err != nil {
+		return fmt.Errorf("failed to set quota: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) DeleteQuota(ctx context.Context, subjectType, subjectID string) error {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM quota WHERE subject_type = $1 AND subject_id = $2`, subjectType, subjectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete quota: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrQuotaNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) AddGroupMember(ctx context.Context, groupName, userID string) error {
+	query := `
+		INSERT INTO group_member (group_name, user_id, create_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (group_name, user_id) DO NOTHING
+	`
+	if _, err := p.db.ExecContext(ctx, query, groupName, userID, time.Now().Unix()); err != nil {
+		return fmt.Errorf("failed to add group member: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) RemoveGroupMember(ctx context.Context, groupName, userID string) error {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM group_member WHERE group_name = $1 AND user_id = $2`, groupName, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove group member: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrGroupMemberNotFound
+	}
+	return nil
+}
+
+// ListGroupMembers lists the user ids of a group in the order they joined
+func (p *postgresStore) ListGroupMembers(ctx context.Context, groupName string) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT user_id FROM group_member WHERE group_name = $1 ORDER BY create_at, user_id`, groupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list group members: %w", err)
+	}
+	members, err := scanPaths(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list group members: %w", err)
+	}
+	return members, nil
+}
+
+// ListUserGroups lists the groups userID is a member of
+func (p *postgresStore) ListUserGroups(ctx context.Context, userID string) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT group_name FROM group_member WHERE user_id = $1 ORDER BY group_name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user groups: %w", err)
+	}
+	groups, err := scanPaths(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user groups: %w", err)
+	}
+	return groups, nil
+}
+
+// adjustUsage applies delta to the usage of userID, locking its usage row until the transaction ends
+func adjustUsage(ctx context.Context, ex execer, userID string, delta usageDelta) error {
+	if delta == (usageDelta{}) {
+		return nil
+	}
+	query := `
+		INSERT INTO storage_usage (user_id, used_bytes, used_files, reserved_bytes, reserved_files, update_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE SET
+			used_bytes = storage_usage.used_bytes + EXCLUDED.used_bytes,
+			used_files = storage_usage.used_files + EXCLUDED.used_files,
+			reserved_bytes = storage_usage.reserved_bytes + EXCLUDED.reserved_bytes,
+			reserved_files = storage_usage.reserved_files + EXCLUDED.reserved_files,
+			update_at = EXCLUDED.update_at
+	`
+	_, err := ex.ExecContext(ctx, query, userID,
+		delta.usedBytes, delta.usedFiles, delta.reservedBytes, delta.reservedFiles, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to update usage: %w", err)
+	}
+	return nil
+}
+
+// chargeUsage applies delta to the usage of userID and, if it grows, fails with ErrQuotaExceeded
+// when the user or one of its groups ends up over its quota. defaultQuota applies to users without
+// a quota of their own.
+func chargeUsage(ctx context.Context, tx *sql.Tx, userID string, delta usageDelta, defaultQuota metadata.Quota) error {
+	if err := adjustUsage(ctx, tx, userID, delta); err != nil {
+		return err
+	}
+	if !delta.grows() {
+		return nil
+	}
+
+	limit := defaultQuota
+	err := tx.QueryRowContext(ctx,
+		`SELECT max_bytes, max_files FROM quota WHERE subject_type = $1 AND subject_id = $2`,
+		metadata.QuotaUser, userID).Scan(&limit.MaxBytes, &limit.MaxFiles)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to retrieve quota: %w", err)
+	}
+	usage := &metadata.Usage{}
+	err = tx.QueryRowContext(ctx,
+		`SELECT used_bytes, used_files, reserved_bytes, reserved_files FROM storage_usage WHERE user_id = $1`, userID).
+		Scan(&usage.UsedBytes, &usage.UsedFiles, &usage.ReservedBytes, &usage.ReservedFiles)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve usage: %w", err)
+	}
+	if exceeds(usage, limit.MaxBytes, limit.MaxFiles) {
+		return fmt.Errorf("%w: user %s", ErrQuotaExceeded, userID)
+	}
+
+	// the group quota rows serialize members charging at the same time, the sums below are
+	// taken after the lock and see the usage committed by the previous holder
+	rows, err := tx.QueryContext(ctx, `
+		SELECT q.subject_id, q.max_bytes, q.max_files
+		FROM quota q
+		JOIN group_member g ON g.group_name = q.subject_id
+		WHERE q.subject_type = $1 AND g.user_id = $2
+		ORDER BY q.subject_id
+		FOR UPDATE OF q
+	`, metadata.QuotaGroup, userID)
+	if err != nil {
+		return fmt.Errorf("failed to lock group quotas: %w", err)
+	}
+	var groups []metadata.Quota
+	for rows.Next() {
+		var q metadata.Quota
+		if err := rows.Scan(&q.SubjectID, &q.MaxBytes, &q.MaxFiles); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan group quota: %w", err)
+		}
+		groups = append(groups, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to lock group quotas: %w", err)
+	}
+
+	for _, group := range groups {
+		usage, err := groupUsage(ctx, tx, group.SubjectID)
+		if err != nil {
+			return err
+		}
+		if exceeds(usage, group.MaxBytes, group.MaxFiles) {
+			return fmt.Errorf("%w: group %s", ErrQuotaExceeded, group.SubjectID)
+		}
+	}
+	return nil
+}
+
+func groupUsage(ctx context.Context, q querier, groupName string) (*metadata.Usage, error) {
+	query := `
+		SELECT COALESCE(SUM(u.used_bytes), 0), COALESCE(SUM(u.used_files), 0),
+			COALESCE(SUM(u.reserved_bytes), 0), COALESCE(SUM(u.reserved_files), 0),
+			COALESCE(MAX(u.update_at), 0)
+		FROM group_member g
+		JOIN storage_usage u ON u.user_id = g.user_id
+		WHERE g.group_name = $1
+	`
+
+	usage := &metadata.Usage{}
+	err := q.QueryRowContext(ctx, query, groupName).
+		Scan(&usage.UsedBytes, &usage.UsedFiles, &usage.ReservedBytes, &usage.ReservedFiles, &usage.UpdateAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve group usage: %w", err)
+	}
+	return usage, nil
+}
+
+func exceeds(usage *metadata.Usage, maxBytes, maxFiles int64) bool {
+	if maxBytes > 0 && usage.UsedBytes+usage.ReservedBytes > maxBytes {
+		return true
+	}
+	return maxFiles > 0 && usage.UsedFiles+usage.ReservedFiles > maxFiles
+}
@@ -0,0 +1,183 @@
+CREATE TABLE IF NOT EXISTS file_metadata (
+    file_id      VARCHAR(64) PRIMARY KEY,
+    filename     VARCHAR(255) NOT NULL,
+    total_size   BIGINT NOT NULL,
+    chunk_count  INT NOT NULL,
+    chunk_size   BIGINT NOT NULL,
+    status       VARCHAR(32) NOT NULL,
+    user_id      VARCHAR(64) NOT NULL,
+    folder_id    VARCHAR(64) NOT NULL DEFAULT '',
+    storage_path VARCHAR(512) NOT NULL DEFAULT '',
+    etag         VARCHAR(128) NOT NULL DEFAULT '',
+    content_hash VARCHAR(64) NOT NULL DEFAULT '',
+    version      INT NOT NULL DEFAULT 1,
+    create_at    BIGINT NOT NULL,
+    update_at    BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_metadata_user_id ON file_metadata (user_id);
+CREATE INDEX IF NOT EXISTS idx_file_metadata_status_update_at ON file_metadata (status, update_at);
+-- keyset pagination of the file listing, one index per sort key
+CREATE INDEX IF NOT EXISTS idx_file_metadata_user_create_at ON file_metadata (user_id, create_at, file_id);
+CREATE INDEX IF NOT EXISTS idx_file_metadata_user_name_sort ON file_metadata (user_id, filename, file_id);
+CREATE INDEX IF NOT EXISTS idx_file_metadata_user_total_size ON file_metadata (user_id, total_size, file_id);
+-- text_pattern_ops cannot serve the ORDER BY filename above, it only backs the name prefix filter
+CREATE INDEX IF NOT EXISTS idx_file_metadata_user_filename ON file_metadata (user_id, filename text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_file_metadata_user_content_hash ON file_metadata (user_id, content_hash, total_size)
+    WHERE content_hash <> '' AND status = 'merged';
+-- only merged files occupy a name, uploads in progress may share it
+CREATE UNIQUE INDEX IF NOT EXISTS idx_file_metadata_folder_name ON file_metadata (user_id, folder_id, filename)
+    WHERE status = 'merged';
+
+-- parent_id is empty for folders in the user's root, files reference their folder by folder_id
+CREATE TABLE IF NOT EXISTS folder (
+    folder_id VARCHAR(64) PRIMARY KEY,
+    user_id   VARCHAR(64) NOT NULL,
+    parent_id VARCHAR(64) NOT NULL DEFAULT '',
+    name      VARCHAR(255) NOT NULL,
+    create_at BIGINT NOT NULL,
+    update_at BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_folder_parent_name ON folder (user_id, parent_id, name);
+
+-- merged objects can be shared by several files (instant upload), an object is
+-- only removed from storage once its ref_count drops to zero
+CREATE TABLE IF NOT EXISTS object_ref (
+    storage_path VARCHAR(512) PRIMARY KEY,
+    ref_count    BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chunk_metadata (
+    file_id      VARCHAR(64) NOT NULL REFERENCES file_metadata (file_id),
+    chunk_id     INT NOT NULL,
+    etag         VARCHAR(128) NOT NULL,
+    object_etag  VARCHAR(128) NOT NULL DEFAULT '',
+    size         BIGINT NOT NULL,
+    storage_path VARCHAR(512) NOT NULL,
+    PRIMARY KEY (file_id, chunk_id)
+);
+
+-- previous contents of a file, every row holds one reference on its object
+CREATE TABLE IF NOT EXISTS file_version (
+    file_id      VARCHAR(64) NOT NULL REFERENCES file_metadata (file_id),
+    version      INT NOT NULL,
+    total_size   BIGINT NOT NULL,
+    storage_path VARCHAR(512) NOT NULL,
+    etag         VARCHAR(128) NOT NULL DEFAULT '',
+    content_hash VARCHAR(64) NOT NULL DEFAULT '',
+    replaced_at  BIGINT NOT NULL,
+    PRIMARY KEY (file_id, version)
+);
+
+-- per user override of the number of previous versions kept for each file
+CREATE TABLE IF NOT EXISTS version_policy (
+    user_id      VARCHAR(64) PRIMARY KEY,
+    max_versions INT NOT NULL
+);
+
+-- storage charged to each user, reserved counts uploads in progress with their declared size
+CREATE TABLE IF NOT EXISTS storage_usage (
+    user_id        VARCHAR(64) PRIMARY KEY,
+    used_bytes     BIGINT NOT NULL DEFAULT 0,
+    used_files     BIGINT NOT NULL DEFAULT 0,
+    reserved_bytes BIGINT NOT NULL DEFAULT 0,
+    reserved_files BIGINT NOT NULL DEFAULT 0,
+    update_at      BIGINT NOT NULL
+);
+
+-- storage limits of users and groups, 0 is unlimited
+CREATE TABLE IF NOT EXISTS quota (
+    subject_type VARCHAR(16) NOT NULL,
+    subject_id   VARCHAR(64) NOT NULL,
+    max_bytes    BIGINT NOT NULL DEFAULT 0,
+    max_files    BIGINT NOT NULL DEFAULT 0,
+    update_at    BIGINT NOT NULL,
+    PRIMARY KEY (subject_type, subject_id)
+);
+
+-- groups share a quota across their members
+CREATE TABLE IF NOT EXISTS group_member (
+    group_name VARCHAR(64) NOT NULL,
+    user_id    VARCHAR(64) NOT NULL,
+    create_at  BIGINT NOT NULL,
+    PRIMARY KEY (group_name, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_member_user_id ON group_member (user_id);
+
+-- share links, a share is addressed by its unguessable token
+CREATE TABLE IF NOT EXISTS share (
+    share_id       VARCHAR(64) PRIMARY KEY,
+    token          VARCHAR(64) NOT NULL UNIQUE,
+    user_id        VARCHAR(64) NOT NULL,
+    resource_type  VARCHAR(16) NOT NULL,
+    resource_id    VARCHAR(64) NOT NULL,
+    password_hash  VARCHAR(128) NOT NULL DEFAULT '',
+    mode           VARCHAR(16) NOT NULL,
+    expires_at     BIGINT NOT NULL DEFAULT 0,
+    max_downloads  INT NOT NULL DEFAULT 0,
+    download_count INT NOT NULL DEFAULT 0,
+    revoked_at     BIGINT NOT NULL DEFAULT 0,
+    create_at      BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_user_id ON share (user_id);
+
+-- user accounts, "user" is reserved in postgres. role is 'user' or 'admin', the first
+-- admin has to be promoted by hand: UPDATE app_user SET role = 'admin' WHERE username = '...'
+CREATE TABLE IF NOT EXISTS app_user (
+    user_id       VARCHAR(64) PRIMARY KEY,
+    username      VARCHAR(64) NOT NULL UNIQUE,
+    password_hash VARCHAR(128) NOT NULL,
+    role          VARCHAR(16) NOT NULL DEFAULT 'user',
+    create_at     BIGINT NOT NULL,
+    update_at     BIGINT NOT NULL
+);
+
+-- rotating refresh tokens, a used token presented again revokes its whole family
+CREATE TABLE IF NOT EXISTS refresh_token (
+    token_id   VARCHAR(64) PRIMARY KEY,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    family_id  VARCHAR(64) NOT NULL,
+    user_id    VARCHAR(64) NOT NULL,
+    access_jti VARCHAR(64) NOT NULL,
+    expires_at BIGINT NOT NULL,
+    used_at    BIGINT NOT NULL DEFAULT 0,
+    revoked_at BIGINT NOT NULL DEFAULT 0,
+    create_at  BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_token_family ON refresh_token (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_user ON refresh_token (user_id) WHERE revoked_at = 0;
+
+-- long-lived keys for machine clients, only the sha256 of a key is stored
+CREATE TABLE IF NOT EXISTS api_key (
+    key_id       VARCHAR(64) PRIMARY KEY,
+    key_hash     VARCHAR(64) NOT NULL UNIQUE,
+    prefix       VARCHAR(16) NOT NULL,
+    user_id      VARCHAR(64) NOT NULL,
+    name         VARCHAR(255) NOT NULL,
+    kind         VARCHAR(16) NOT NULL,
+    scopes       TEXT[] NOT NULL DEFAULT '{}',
+    created_by   VARCHAR(64) NOT NULL,
+    expires_at   BIGINT NOT NULL DEFAULT 0,
+    last_used_at BIGINT NOT NULL DEFAULT 0,
+    revoked_at   BIGINT NOT NULL DEFAULT 0,
+    create_at    BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key_user_id ON api_key (user_id);
+
+-- roles granted on files and folders, a folder grant covers everything below the folder
+CREATE TABLE IF NOT EXISTS acl_entry (
+    resource_type VARCHAR(16) NOT NULL,
+    resource_id   VARCHAR(64) NOT NULL,
+    user_id       VARCHAR(64) NOT NULL,
+    role          VARCHAR(16) NOT NULL,
+    granted_by    VARCHAR(64) NOT NULL,
+    create_at     BIGINT NOT NULL,
+    PRIMARY KEY (resource_type, resource_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_acl_entry_user_id ON acl_entry (user_id);
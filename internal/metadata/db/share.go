@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+)
+
+const shareColumns = `
+	share_id, token, user_id, resource_type, resource_id, password_hash, mode,
+	expires_at, max_downloads, download_count, revoked_at, create_at
+`
+
+func (p *postgresStore) InsertShare(ctx context.Context, share *metadata.Share) error {
+	query := `INSERT INTO share (` + shareColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+
+	if share.CreateAt == 0 {
+		share.CreateAt = time.Now().Unix()
+	}
+	_, err := p.db.ExecContext(ctx, query,
+		share.ShareID, share.Token, share.UserID, share.ResourceType, share.ResourceID, share.PasswordHash, share.Mode,
+		share.ExpiresAt, share.MaxDownloads, share.DownloadCount, share.RevokedAt, share.CreateAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert share: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) GetShareByToken(ctx context.Context, token string) (*metadata.Share, error) {
+	query := `SELECT ` + shareColumns + ` FROM share WHERE token = $1`
+
+	share, err := scanShare(p.db.QueryRowContext(ctx, query, token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrShareNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve share: %w", err)
+	}
+	return share, nil
+}
+
+// ListShares lists the active shares of userID, newest first
+func (p *postgresStore) ListShares(ctx context.Context, userID string) ([]*metadata.Share, error) {
+	query := `SELECT ` + shareColumns + `
+		FROM share
+		WHERE user_id = $1 AND revoked_at = 0
+		ORDER BY create_at DESC
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shares: %w", err)
+	}
+	defer rows.Close()
+
+	var shares []*metadata.Share
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan share: %w", err)
+		}
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list shares: %w", err)
+	}
+	return shares, nil
+}
+
+// RevokeShare disables an active share of userID, the row is kept for auditing
+func (p *postgresStore) RevokeShare(ctx context.Context, shareID, userID string) error {
+	query := `
+		UPDATE share
+		SET revoked_at = $1
+		WHERE share_id = $2 AND user_id = $3 AND revoked_at = 0
+	`
+
+	result, err := p.db.ExecContext(ctx, query, time.Now().Unix(), shareID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke share: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// ConsumeShareDownload counts one download, failing with ErrShareLimitReached once the
+// share's download limit is used up. The check and the increment are a single statement.
+func (p *postgresStore) ConsumeShareDownload(ctx context.Context, shareID string) error {
+	query := `
+		UPDATE share
+		SET download_count = download_count + 1
+		WHERE share_id = $1 AND (max_downloads = 0 OR download_count < max_downloads)
+	`
+
+	result, err := p.db.ExecContext(ctx, query, shareID)
+	if err != nil {
+		return fmt.Errorf("failed to count share download: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrShareLimitReached
+	}
+	return nil
+}
+
+// IsFolderWithin reports whether folderID is ancestorID or lies below it
+func (p *postgresStore) IsFolderWithin(ctx context.Context, folderID, ancestorID string) (bool, error) {
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT folder_id, parent_id FROM folder WHERE folder_id = $1
+			UNION ALL
+			SELECT f.folder_id, f.parent_id FROM folder f JOIN ancestors a ON f.folder_id = a.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE folder_id = $2)
+	`
+	var within bool
+	if err := p.db.QueryRowContext(ctx, query, folderID, ancestorID).Scan(&within); err != nil {
+		return false, fmt.Errorf("failed to check folder ancestry: %w", err)
+	}
+	return within, nil
+}
+
+func scanShare(row rowScanner) (*metadata.Share, error) {
+	share := &metadata.Share{}
+	err := row.Scan(
+		&share.ShareID, &share.Token, &share.UserID, &share.ResourceType, &share.ResourceID, &share.PasswordHash, &share.Mode,
+		&share.ExpiresAt, &share.MaxDownloads, &share.DownloadCount, &share.RevokedAt, &share.CreateAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return share, nil
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+)
+
+const refreshTokenColumns = `
+	token_id, token_hash, family_id, user_id, access_jti, expires_at, used_at, revoked_at, create_at
+`
+
+func (p *postgresStore) InsertRefreshToken(ctx context.Context, token *metadata.RefreshToken) error {
+	return insertRefreshToken(ctx, p.db, token)
+}
+
+func (p *postgresStore) GetRefreshToken(ctx context.Context, tokenHash string) (*metadata.RefreshToken, error) {
+	query := `SELECT ` + refreshTokenColumns + ` FROM refresh_token WHERE token_hash = $1`
+
+	token, err := scanRefreshToken(p.db.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// RotateRefreshToken uses up usedID and stores next in its place. Only one rotation of a
+// token can succeed, ErrRefreshTokenUsed means it was used or revoked before.
+func (p *postgresStore) RotateRefreshToken(ctx context.Context, usedID string, next *metadata.RefreshToken) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE refresh_token SET used_at = $1 WHERE token_id = $2 AND used_at = 0 AND revoked_at = 0`,
+		time.Now().Unix(), usedID)
+	if err != nil {
+		return fmt.Errorf("failed to use refresh token: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRefreshTokenUsed
+	}
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RevokeTokenFamily revokes the active tokens of a family and returns them, so the access
+// tokens issued with them can be revoked as well
+func (p *postgresStore) RevokeTokenFamily(ctx context.Context, familyID string) ([]*metadata.RefreshToken, error) {
+	query := `
+		UPDATE refresh_token
+		SET revoked_at = $1
+		WHERE family_id = $2 AND revoked_at = 0
+		RETURNING ` + refreshTokenColumns
+	return p.revokeRefreshTokens(ctx, query, familyID)
+}
+
+// RevokeUserTokens revokes every active refresh token of userID, logging out all sessions
+func (p *postgresStore) RevokeUserTokens(ctx context.Context, userID string) ([]*metadata.RefreshToken, error) {
+	query := `
+		UPDATE refresh_token
+		SET revoked_at = $1
+		WHERE user_id = $2 AND revoked_at = 0
+		RETURNING ` + refreshTokenColumns
+	return p.revokeRefreshTokens(ctx, query, userID)
+}
+
+func (p *postgresStore) revokeRefreshTokens(ctx context.Context, query, arg string) ([]*metadata.RefreshToken, error) {
+	rows, err := p.db.QueryContext(ctx, query, time.Now().Unix(), arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*metadata.RefreshToken
+	for rows.Next() {
+		token, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan refresh token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func insertRefreshToken(ctx context.Context, ex execer, token *metadata.RefreshToken) error {
+	query := `INSERT INTO refresh_token (` + refreshTokenColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	if token.CreateAt == 0 {
+		token.CreateAt = time.Now().Unix()
+	}
+	_, err := ex.ExecContext(ctx, query,
+		token.TokenID, token.TokenHash, token.FamilyID, token.UserID, token.AccessJTI,
+		token.ExpiresAt, token.UsedAt, token.RevokedAt, token.CreateAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert refresh token: %w", err)
+	}
+	return nil
+}
+
+func scanRefreshToken(row rowScanner) (*metadata.RefreshToken, error) {
+	token := &metadata.RefreshToken{}
+	err := row.Scan(&token.TokenID, &token.TokenHash, &token.FamilyID, &token.UserID, &token.AccessJTI,
+		&token.ExpiresAt, &token.UsedAt, &token.RevokedAt, &token.CreateAt)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+)
+
+// TrashFile moves a merged file to the trash, failing with ErrFileNotMerged when it is in any other
+// state, so a file the purger or a concurrent request already moved on is never pulled back
+func (p *postgresStore) TrashFile(ctx context.Context, fileID string) error {
+	query := `
+		UPDATE file_metadata
+		SET status = $1, update_at = $2
+		WHERE file_id = $3 AND status = $4
+	`
+	result, err := p.db.ExecContext(ctx, query, metadata.StatusTrashed, time.Now().Unix(), fileID, metadata.StatusMerged)
+	if err != nil {
+		return fmt.Errorf("failed to trash file: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrFileNotMerged
+	}
+	return nil
+}
+
+// RestoreFile moves a trashed file back into its folder, failing with ErrDuplicateName
+// when its name was taken in the meantime
+func (p *postgresStore) RestoreFile(ctx context.Context, fileID string) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID, folderID, fileName, status string
+	err = tx.QueryRowContext(ctx, `SELECT user_id, folder_id, filename, status FROM file_metadata WHERE file_id = $1`, fileID).
+		Scan(&userID, &folderID, &fileName, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("file not found: %s", fileID)
+		}
+		return fmt.Errorf("failed to retrieve file metadata: %w", err)
+	}
+	if err := lockNamespace(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := checkFolderNameFree(ctx, tx, userID, folderID, fileName); err != nil {
+		return err
+	}
+
+	// the status is checked again under the row lock, the purger may have claimed the file
+	query := `
+		UPDATE file_metadata
+		SET status = $1, update_at = $2
+		WHERE file_id = $3 AND status = $4
+	`
+	result, err := tx.ExecContext(ctx, query, metadata.StatusMerged, time.Now().Unix(), fileID, metadata.StatusTrashed)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return fmt.Errorf("failed to restore file: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrFileNotTrashed
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ClaimTrashedFiles moves up to limit files trashed before trashedBefore into the purging state
+// and returns them. Like ClaimStaleUploads rows locked by another replica are skipped, purge claims
+// older than claimStaleBefore were left behind by a crashed replica and are claimed again.
+func (p *postgresStore) ClaimTrashedFiles(ctx context.Context, trashedBefore, claimStaleBefore int64, limit int) ([]*metadata.FileMetadata, error) {
+	query := `
+		UPDATE file_metadata
+		SET status = $1, update_at = $2
+		WHERE file_id IN (
+			SELECT file_id FROM file_metadata
+			WHERE (status = $3 AND update_at < $4) OR (status = $1 AND update_at < $5)
+			ORDER BY update_at
+			LIMIT $6
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + fileColumns
+
+	rows, err := p.db.QueryContext(ctx, query,
+		metadata.StatusPurging, time.Now().Unix(), metadata.StatusTrashed, trashedBefore, claimStaleBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim trashed files: %w", err)
+	}
+	return scanClaimedFiles(rows)
+}
+
+// ClaimUserTrash moves every trashed file of userID into the purging state and returns them
+func (p *postgresStore) ClaimUserTrash(ctx context.Context, userID string) ([]*metadata.FileMetadata, error) {
+	query := `
+		UPDATE file_metadata
+		SET status = $1, update_at = $2
+		WHERE user_id = $3 AND status = $4
+		RETURNING ` + fileColumns
+
+	rows, err := p.db.QueryContext(ctx, query,
+		metadata.StatusPurging, time.Now().Unix(), userID, metadata.StatusTrashed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim trashed files: %w", err)
+	}
+	return scanClaimedFiles(rows)
+}
+
+// PurgeFile removes a file together with its chunk and version records and drops their references
+// to stored objects, the storage of the file and its versions is no longer charged to the user. It
+// returns the objects left without any reference, only those may be deleted from storage.
+func (p *postgresStore) PurgeFile(ctx context.Context, fileID string) ([]string, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID, status, storagePath string
+	var totalSize int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id, status, total_size, storage_path FROM file_metadata WHERE file_id = $1 FOR UPDATE`, fileID).
+		Scan(&userID, &status, &totalSize, &storagePath)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("file not found: %s", fileID)
+		}
+		return nil, fmt.Errorf("failed to retrieve file metadata: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT storage_path, total_size FROM file_version WHERE file_id = $1`, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list file versions: %w", err)
+	}
+	storagePaths, versionBytes, err := scanVersionObjects(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list file versions: %w", err)
+	}
+	if storagePath != "" {
+		storagePaths = append(storagePaths, storagePath)
+	}
+
+	orphans, err := releaseObjects(ctx, tx, storagePaths)
+	if err != nil {
+		return nil, err
+	}
+	if err := deleteFileRecords(ctx, tx, fileID); err != nil {
+		return nil, err
+	}
+	if err := adjustUsage(ctx, tx, userID, chargedDelta(status, totalSize+versionBytes).negate()); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return orphans, nil
+}
+
+func scanClaimedFiles(rows *sql.Rows) ([]*metadata.FileMetadata, error) {
+	defer rows.Close()
+
+	var files []*metadata.FileMetadata
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file metadata: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim trashed files: %w", err)
+	}
+	return files, nil
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+)
+
+const userColumns = `user_id, username, password_hash, role, create_at, update_at`
+
+// InsertUser creates an account, ErrDuplicateUser if the username is taken
+func (p *postgresStore) InsertUser(ctx context.Context, user *metadata.User) error {
+	query := `INSERT INTO app_user (` + userColumns + `) VALUES ($1, $2, $3, $4, $5, $6)`
+
+	now := time.Now().Unix()
+	user.CreateAt, user.UpdateAt = now, now
+	if user.Role == "" {
+		user.Role = metadata.UserRoleUser
+	}
+	_, err := p.db.ExecContext(ctx, query, user.UserID, user.Username, user.PasswordHash, user.Role, user.CreateAt, user.UpdateAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateUser
+		}
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) GetUser(ctx context.Context, userID string) (*metadata.User, error) {
+	query := `SELECT ` + userColumns + ` FROM app_user WHERE user_id = $1`
+	return p.queryUser(ctx, query, userID)
+}
+
+func (p *postgresStore) GetUserByName(ctx context.Context, username string) (*metadata.User, error) {
+	query := `SELECT ` + userColumns + ` FROM app_user WHERE username = $1`
+	return p.queryUser(ctx, query, username)
+}
+
+func (p *postgresStore) SetUserRole(ctx context.Context, userID, role string) error {
+	result, err := p.db.ExecContext(ctx, `UPDATE app_user SET role = $1, update_at = $2 WHERE user_id = $3`,
+		role, time.Now().Unix(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user role: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (p *postgresStore) queryUser(ctx context.Context, query string, arg string) (*metadata.User, error) {
+	user := &metadata.User{}
+	err := p.db.QueryRowContext(ctx, query, arg).
+		Scan(&user.UserID, &user.Username, &user.PasswordHash, &user.Role, &user.CreateAt, &user.UpdateAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve user: %w", err)
+	}
+	return user, nil
+}
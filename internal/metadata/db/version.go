@@ -0,0 +1,307 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+)
+
+const versionColumns = `file_id, version, total_size, storage_path, etag, content_hash, replaced_at`
+
+// ListFileVersions lists the previous versions of a file, newest first
+func (p *postgresStore) ListFileVersions(ctx context.Context, fileID string) ([]*metadata.FileVersion, error) {
+	query := `SELECT ` + versionColumns + `
+		FROM file_version
+		WHERE file_id = $1
+		ORDER BY version DESC
+	`
+
+	rows, err := p.db.QueryContext(ctx, query, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list file versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*metadata.FileVersion
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list file versions: %w", err)
+	}
+	return versions, nil
+}
+
+func (p *postgresStore) GetFileVersion(ctx context.Context, fileID string, version int) (*metadata.FileVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM file_version WHERE file_id = $1 AND version = $2`
+
+	fileVersion, err := scanVersion(p.db.QueryRowContext(ctx, query, fileID, version))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrVersionNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve file version: %w", err)
+	}
+	return fileVersion, nil
+}
+
+// RestoreFileVersion makes the content of a previous version current again. The replaced content is
+// kept as a version of its own, so a restore can be undone like any other change. The restored
+// content is charged again, failing with ErrQuotaExceeded over quota.
+func (p *postgresStore) RestoreFileVersion(ctx context.Context, fileID string, version int, defaultMaxVersions int, defaultQuota metadata.Quota) (*metadata.FileMetadata, []string, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	file, err := scanFile(tx.QueryRowContext(ctx, `SELECT `+fileColumns+` FROM file_metadata WHERE file_id = $1 FOR UPDATE`, fileID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("file not found: %s", fileID)
+		}
+		return nil, nil, fmt.Errorf("failed to retrieve file metadata: %w", err)
+	}
+	if file.Status != metadata.StatusMerged {
+		return nil, nil, ErrFileNotMerged
+	}
+	if version == file.Version {
+		return file, nil, nil
+	}
+
+	previous, err := scanVersion(tx.QueryRowContext(ctx,
+		`SELECT `+versionColumns+` FROM file_version WHERE file_id = $1 AND version = $2`, fileID, version))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrVersionNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to retrieve file version: %w", err)
+	}
+	// the current content takes a reference of its own, the version row keeps its one
+	if err := addObjectReference(ctx, tx, previous.StoragePath); err != nil {
+		return nil, nil, err
+	}
+
+	content := &metadata.FileMetadata{
+		TotalSize:   previous.TotalSize,
+		ChunkCount:  file.ChunkCount,
+		ChunkSize:   file.ChunkSize,
+		StoragePath: previous.StoragePath,
+		ETag:        previous.ETag,
+		ContentHash: previous.ContentHash,
+	}
+	orphans, delta, err := pushVersion(ctx, tx, file, content, defaultMaxVersions)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := chargeUsage(ctx, tx, file.UserID, delta, defaultQuota); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return file, orphans, nil
+}
+
+// GetMaxVersions returns how many previous versions userID keeps per file
+func (p *postgresStore) GetMaxVersions(ctx context.Context, userID string, defaultMaxVersions int) (int, error) {
+	return maxVersions(ctx, p.db, userID, defaultMaxVersions)
+}
+
+// SetMaxVersions overrides the number of previous versions userID keeps per file, versions above
+// the new limit are pruned with the next change of each file
+func (p *postgresStore) SetMaxVersions(ctx context.Context, userID string, maxVersions int) error {
+	query := `
+		INSERT INTO version_policy (user_id, max_versions)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET max_versions = EXCLUDED.max_versions
+	`
+	if _, err := p.db.ExecContext(ctx, query, userID, maxVersions); err != nil {
+		return fmt.Errorf("failed to set version policy: %w", err)
+	}
+	return nil
+}
+
+func scanVersion(row rowScanner) (*metadata.FileVersion, error) {
+	version := &metadata.FileVersion{}
+	err := row.Scan(&version.FileID, &version.Version, &version.TotalSize, &version.StoragePath,
+		&version.ETag, &version.ContentHash, &version.ReplacedAt)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// findMergedFileByName locks and returns the merged file holding name in folderID other than
+// excludeID, or nil if there is none
+func findMergedFileByName(ctx context.Context, tx *sql.Tx, userID, folderID, name, excludeID string) (*metadata.FileMetadata, error) {
+	query := `SELECT ` + fileColumns + `
+		FROM file_metadata
+		WHERE user_id = $1 AND folder_id = $2 AND filename = $3 AND status = $4 AND file_id <> $5
+		FOR UPDATE
+	`
+
+	file, err := scanFile(tx.QueryRowContext(ctx, query, userID, folderID, name, metadata.StatusMerged, excludeID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find file by name: %w", err)
+	}
+	return file, nil
+}
+
+func addObjectReference(ctx context.Context, ex execer, storagePath string) error {
+	query := `
+		INSERT INTO object_ref (storage_path, ref_count)
+		VALUES ($1, 1)
+		ON CONFLICT (storage_path) DO UPDATE SET ref_count = object_ref.ref_count + 1
+	`
+	if _, err := ex.ExecContext(ctx, query, storagePath); err != nil {
+		return fmt.Errorf("failed to register object reference: %w", err)
+	}
+	return nil
+}
+
+// pushVersion keeps the current content of file as a version and replaces it with content, whose
+// object reference must already be taken. file is updated in place. Versions beyond the user's
+// limit are pruned, the objects left without reference are returned for removal from storage.
+// The returned delta, the new content less the pruned versions, is left for the caller to charge.
+func pushVersion(ctx context.Context, tx *sql.Tx, file, content *metadata.FileMetadata, defaultMaxVersions int) ([]string, usageDelta, error) {
+	now := time.Now().Unix()
+
+	insertQuery := `INSERT INTO file_version (` + versionColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := tx.ExecContext(ctx, insertQuery,
+		file.FileID, file.Version, file.TotalSize, file.StoragePath, file.ETag, file.ContentHash, now)
+	if err != nil {
+		return nil, usageDelta{}, fmt.Errorf("failed to insert file version: %w", err)
+	}
+
+	updateQuery := `
+		UPDATE file_metadata
+		SET total_size = $1, chunk_count = $2, chunk_size = $3, storage_path = $4, etag = $5,
+			content_hash = $6, version = version + 1, update_at = $7
+		WHERE file_id = $8
+	`
+	_, err = tx.ExecContext(ctx, updateQuery,
+		content.TotalSize, content.ChunkCount, content.ChunkSize, content.StoragePath, content.ETag,
+		content.ContentHash, now, file.FileID)
+	if err != nil {
+		return nil, usageDelta{}, fmt.Errorf("failed to update file content: %w", err)
+	}
+	file.TotalSize, file.ChunkCount, file.ChunkSize = content.TotalSize, content.ChunkCount, content.ChunkSize
+	file.StoragePath, file.ETag, file.ContentHash = content.StoragePath, content.ETag, content.ContentHash
+	file.Version++
+	file.UpdateAt = now
+
+	limit, err := maxVersions(ctx, tx, file.UserID, defaultMaxVersions)
+	if err != nil {
+		return nil, usageDelta{}, err
+	}
+	pruneQuery := `
+		DELETE FROM file_version
+		WHERE file_id = $1 AND version NOT IN (
+			SELECT version FROM file_version WHERE file_id = $1 ORDER BY version DESC LIMIT $2
+		)
+		RETURNING storage_path, total_size
+	`
+	rows, err := tx.QueryContext(ctx, pruneQuery, file.FileID, limit)
+	if err != nil {
+		return nil, usageDelta{}, fmt.Errorf("failed to prune file versions: %w", err)
+	}
+	pruned, prunedBytes, err := scanVersionObjects(rows)
+	if err != nil {
+		return nil, usageDelta{}, fmt.Errorf("failed to prune file versions: %w", err)
+	}
+	orphans, err := releaseObjects(ctx, tx, pruned)
+	if err != nil {
+		return nil, usageDelta{}, err
+	}
+	return orphans, usageDelta{usedBytes: content.TotalSize - prunedBytes}, nil
+}
+
+// releaseObjects drops one reference per path and returns the paths no longer referenced at all
+func releaseObjects(ctx context.Context, q querier, storagePaths []string) ([]string, error) {
+	var orphans []string
+	for _, storagePath := range storagePaths {
+		remaining, err := releaseObject(ctx, q, storagePath)
+		// a missing reference means nobody else holds the object either
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return nil, err
+		}
+		if remaining == 0 {
+			orphans = append(orphans, storagePath)
+		}
+	}
+	return orphans, nil
+}
+
+func maxVersions(ctx context.Context, q querier, userID string, defaultMaxVersions int) (int, error) {
+	var limit int
+	err := q.QueryRowContext(ctx, `SELECT max_versions FROM version_policy WHERE user_id = $1`, userID).Scan(&limit)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return defaultMaxVersions, nil
+		}
+		return 0, fmt.Errorf("failed to retrieve version policy: %w", err)
+	}
+	return limit, nil
+}
+
+// deleteFileRecords removes a file with its chunk, version and grant records
+func deleteFileRecords(ctx context.Context, ex execer, fileID string) error {
+	if _, err := ex.ExecContext(ctx, `DELETE FROM acl_entry WHERE resource_type = 'file' AND resource_id = $1`, fileID); err != nil {
+		return fmt.Errorf("failed to delete file grants: %w", err)
+	}
+	if _, err := ex.ExecContext(ctx, `DELETE FROM chunk_metadata WHERE file_id = $1`, fileID); err != nil {
+		return fmt.Errorf("failed to delete chunk metadata: %w", err)
+	}
+	if _, err := ex.ExecContext(ctx, `DELETE FROM file_version WHERE file_id = $1`, fileID); err != nil {
+		return fmt.Errorf("failed to delete file versions: %w", err)
+	}
+	if _, err := ex.ExecContext(ctx, `DELETE FROM file_metadata WHERE file_id = $1`, fileID); err != nil {
+		return fmt.Errorf("failed to delete file metadata: %w", err)
+	}
+	return nil
+}
+
+func scanPaths(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// scanVersionObjects reads rows of storage_path and total_size, returning the paths and the summed size
+func scanVersionObjects(rows *sql.Rows) ([]string, int64, error) {
+	defer rows.Close()
+
+	var paths []string
+	var total int64
+	for rows.Next() {
+		var path string
+		var size int64
+		if err := rows.Scan(&path, &size); err != nil {
+			return nil, 0, err
+		}
+		paths = append(paths, path)
+		total += size
+	}
+	return paths, total, rows.Err()
+}
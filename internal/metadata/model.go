@@ -1,21 +1,172 @@
 package metadata
 
+// file status
+const (
+	StatusUploading = "uploading"
+	StatusMerged    = "merged"
+	StatusExpiring  = "expiring" //claimed by the upload reaper
+	StatusExpired   = "expired"
+	StatusTrashed   = "trashed"
+	StatusPurging   = "purging" //claimed by the trash purger
+)
+
 type FileMetadata struct {
-	FileID     string
-	FileName   string
-	TotalSize  int64
-	ChunkCount int
-	ChunkSize  int64
-	Status     string
-	UserID     string
-	CreateAt   int64
-	UpdateAt   int64
+	FileID      string
+	FileName    string
+	TotalSize   int64
+	ChunkCount  int
+	ChunkSize   int64
+	Status      string
+	UserID      string
+	FolderID    string //parent folder, empty for the user's root
+	StoragePath string //object path of the merged file
+	ETag        string //etag of the merged object
+	ContentHash string //sha256 of the whole file (hex), used for instant upload
+	Version     int    //number of the current content, previous ones are kept as FileVersion
+	CreateAt    int64
+	UpdateAt    int64
 }
 
 type ChunkMetadata struct {
 	FileID      string
-	ChunkID     string
-	ETag        string
+	ChunkID     int
+	ETag        string //md5 of the chunk content (hex)
+	ObjectETag  string //etag reported by the object store, used to detect changed chunks
 	Size        int64
 	StoragePath string
 }
+
+// FileVersion is a previous content of a file, replaced by an upload with the same name or a restore
+type FileVersion struct {
+	FileID      string
+	Version     int
+	TotalSize   int64
+	StoragePath string
+	ETag        string
+	ContentHash string
+	ReplacedAt  int64 //when this content stopped being the current one
+}
+
+// Folder is a directory in a user's namespace, folders form a tree through ParentID
+type Folder struct {
+	FolderID string
+	UserID   string
+	ParentID string //empty for the user's root
+	Name     string
+	CreateAt int64
+	UpdateAt int64
+}
+
+// share resource types and modes
+const (
+	ShareTypeFile   = "file"
+	ShareTypeFolder = "folder"
+
+	ShareModeRead   = "read"
+	ShareModeUpload = "upload" //folder shares only, visitors may add files
+)
+
+// Share grants access to a file or folder to anyone holding Token, within its own limits
+type Share struct {
+	ShareID       string
+	Token         string
+	UserID        string //owner of the shared resource
+	ResourceType  string
+	ResourceID    string
+	PasswordHash  string //bcrypt, empty when no password is required
+	Mode          string
+	ExpiresAt     int64 //unix seconds, 0 never expires
+	MaxDownloads  int   //0 is unlimited
+	DownloadCount int
+	RevokedAt     int64 //0 while active
+	CreateAt      int64
+}
+
+// quota subjects
+const (
+	QuotaUser  = "user"
+	QuotaGroup = "group" //limits the summed usage of all members
+)
+
+// Quota limits the storage of a user or a group, 0 is unlimited
+type Quota struct {
+	SubjectType string
+	SubjectID   string
+	MaxBytes    int64
+	MaxFiles    int64
+	UpdateAt    int64
+}
+
+// Usage is the storage charged to a user, or summed over a group. Merged and trashed files count
+// with all their versions, uploads in progress are reserved with their declared size until they
+// are merged or expire.
+type Usage struct {
+	UsedBytes     int64
+	UsedFiles     int64
+	ReservedBytes int64
+	ReservedFiles int64
+	UpdateAt      int64
+}
+
+// user roles
+const (
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin" //may access the data of every user
+)
+
+// User is an account, Username is stored lower case and unique
+type User struct {
+	UserID       string
+	Username     string
+	PasswordHash string //bcrypt
+	Role         string
+	CreateAt     int64
+	UpdateAt     int64
+}
+
+// RefreshToken is one link of a rotating refresh token family. Only the sha256 of the
+// token is stored. Every refresh uses up the presented token and adds a new one to the family.
+type RefreshToken struct {
+	TokenID   string
+	TokenHash string //hex sha256 of the token
+	FamilyID  string //shared by every token rotated from the same login
+	UserID    string
+	AccessJTI string //jti of the access token issued together with this one
+	ExpiresAt int64
+	UsedAt    int64 //0 until rotated
+	RevokedAt int64 //0 while active
+	CreateAt  int64
+}
+
+// api key kinds
+const (
+	APIKeyPersonal = "personal" //created by a user for their own scripts
+	APIKeyService  = "service"  //created by an admin for a service account
+)
+
+// APIKey authenticates a machine client as UserID. Only the sha256 of the key is stored,
+// Prefix is kept in clear so users can tell their keys apart.
+type APIKey struct {
+	KeyID      string
+	KeyHash    string //hex sha256 of the key
+	Prefix     string
+	UserID     string
+	Name       string
+	Kind       string
+	Scopes     []string
+	CreatedBy  string
+	ExpiresAt  int64 //0 never expires
+	LastUsedAt int64
+	RevokedAt  int64 //0 while active
+	CreateAt   int64
+}
+
+// Grant gives UserID a role on a file or folder, a folder grant covers everything below the folder
+type Grant struct {
+	ResourceType string //"file" or "folder"
+	ResourceID   string
+	UserID       string
+	Role         string
+	GrantedBy    string
+	CreateAt     int64
+}
@@ -0,0 +1,41 @@
+package metadata
+
+// sort keys of a file listing
+const (
+	SortByName = "name"
+	SortBySize = "size"
+	SortByDate = "date"
+)
+
+// FileListQuery selects one page of a user's files, nil or empty filters are not applied
+type FileListQuery struct {
+	UserID        string
+	Statuses      []string //empty lists every file that is neither expired nor trashed
+	FolderID      *string
+	NamePrefix    string
+	MinSize       *int64
+	MaxSize       *int64
+	CreatedAfter  *int64 //unix seconds, inclusive
+	CreatedBefore *int64 //unix seconds, exclusive
+	SortBy        string
+	Desc          bool
+	After         *FileCursor //continue after this position
+	Limit         int
+}
+
+// FileCursor is the keyset position of the last file of a page, only the
+// value of the listing's sort key is set next to the file id tie breaker
+type FileCursor struct {
+	SortBy   string `json:"s"`
+	Desc     bool   `json:"d,omitempty"`
+	Name     string `json:"n,omitempty"`
+	Size     int64  `json:"z,omitempty"`
+	CreateAt int64  `json:"c,omitempty"`
+	FileID   string `json:"f"`
+}
+
+// FilePage is one page of files, Next is nil on the last page
+type FilePage struct {
+	Files []*FileMetadata
+	Next  *FileCursor
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"go.uber.org/zap"
+)
+
+// SaveGrant gives a user a role on a file or folder, replacing the role granted before
+func (m *metadataService) SaveGrant(ctx context.Context, grant *metadata.Grant) error {
+	if err := m.db.UpsertGrant(ctx, grant); err != nil {
+		m.log(ctx).Error("Failed to save grant",
+			zap.Error(err),
+			zap.String("resourceType", grant.ResourceType),
+			zap.String("resourceID", grant.ResourceID),
+			zap.String("userID", grant.UserID))
+		return errors.New("database update failed")
+	}
+
+	m.log(ctx).Info("Role granted",
+		zap.String("resourceType", grant.ResourceType),
+		zap.String("resourceID", grant.ResourceID),
+		zap.String("userID", grant.UserID),
+		zap.String("role", grant.Role),
+		zap.String("grantedBy", grant.GrantedBy))
+	return nil
+}
+
+func (m *metadataService) DeleteGrant(ctx context.Context, resourceType, resourceID, userID string) error {
+	if err := m.db.DeleteGrant(ctx, resourceType, resourceID, userID); err != nil {
+		if errors.Is(err, db.ErrGrantNotFound) {
+			return ErrGrantNotFound
+		}
+		m.log(ctx).Error("Failed to delete grant",
+			zap.Error(err),
+			zap.String("resourceType", resourceType),
+			zap.String("resourceID", resourceID),
+			zap.String("userID", userID))
+		return errors.New("database update failed")
+	}
+
+	m.log(ctx).Info("Role revoked",
+		zap.String("resourceType", resourceType),
+		zap.String("resourceID", resourceID),
+		zap.String("userID", userID))
+	return nil
+}
+
+func (m *metadataService) ListGrants(ctx context.Context, resourceType, resourceID string) ([]*metadata.Grant, error) {
+	grants, err := m.db.ListGrants(ctx, resourceType, resourceID)
+	if err != nil {
+		m.log(ctx).Error("Failed to list grants from database",
+			zap.Error(err),
+			zap.String("resourceType", resourceType),
+			zap.String("resourceID", resourceID))
+		return nil, errors.New("database operation failed")
+	}
+	return grants, nil
+}
+
+func (m *metadataService) ListUserGrants(ctx context.Context, userID string) ([]*metadata.Grant, error) {
+	grants, err := m.db.ListUserGrants(ctx, userID)
+	if err != nil {
+		m.log(ctx).Error("Failed to list grants of user from database",
+			zap.Error(err),
+			zap.String("userID", userID))
+		return nil, errors.New("database operation failed")
+	}
+	return grants, nil
+}
+
+// ListRoles returns the roles userID holds on a resource directly or through the folders above it
+func (m *metadataService) ListRoles(ctx context.Context, userID, resourceType, resourceID, folderID string) ([]string, error) {
+	roles, err := m.db.ListRoles(ctx, userID, resourceType, resourceID, folderID)
+	if err != nil {
+		m.log(ctx).Error("Failed to list roles from database",
+			zap.Error(err),
+			zap.String("userID", userID),
+			zap.String("resourceType", resourceType),
+			zap.String("resourceID", resourceID))
+		return nil, errors.New("database operation failed")
+	}
+	return roles, nil
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"go.uber.org/zap"
+)
+
+func (m *metadataService) CreateAPIKey(ctx context.Context, key *metadata.APIKey) error {
+	if err := m.db.InsertAPIKey(ctx, key); err != nil {
+		m.log(ctx).Error("Failed to insert api key into database",
+			zap.Error(err),
+			zap.String("userID", key.UserID),
+			zap.String("keyID", key.KeyID))
+		return errors.New("database operation failed")
+	}
+
+	m.log(ctx).Info("API key created",
+		zap.String("keyID", key.KeyID),
+		zap.String("userID", key.UserID),
+		zap.String("kind", key.Kind),
+		zap.Strings("scopes", key.Scopes))
+	return nil
+}
+
+func (m *metadataService) GetAPIKey(ctx context.Context, keyID string) (*metadata.APIKey, error) {
+	key, err := m.db.GetAPIKey(ctx, keyID)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		m.log(ctx).Error("Failed to retrieve api key from database", zap.Error(err), zap.String("keyID", keyID))
+		return nil, errors.New("database operation failed")
+	}
+	return key, nil
+}
+
+func (m *metadataService) GetAPIKeyByHash(ctx context.Context, keyHash string) (*metadata.APIKey, error) {
+	key, err := m.db.GetAPIKeyByHash(ctx, keyHash)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		m.log(ctx).Error("Failed to retrieve api key from database", zap.Error(err))
+		return nil, errors.New("database operation failed")
+	}
+	return key, nil
+}
+
+func (m *metadataService) ListAPIKeys(ctx context.Context, userID string) ([]*metadata.APIKey, error) {
+	keys, err := m.db.ListAPIKeys(ctx, userID)
+	if err != nil {
+		m.log(ctx).Error("Failed to list api keys", zap.Error(err), zap.String("userID", userID))
+		return nil, errors.New("database operation failed")
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an active key, ErrAPIKeyNotFound if there is none with keyID
+func (m *metadataService) RevokeAPIKey(ctx context.Context, keyID string) error {
+	if err := m.db.RevokeAPIKey(ctx, keyID); err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			return ErrAPIKeyNotFound
+		}
+		m.log(ctx).Error("Failed to revoke api key", zap.Error(err), zap.String("keyID", keyID))
+		return errors.New("database update failed")
+	}
+
+	m.log(ctx).Info("API key revoked", zap.String("keyID", keyID))
+	return nil
+}
+
+func (m *metadataService) TouchAPIKey(ctx context.Context, keyID string, usedAt int64) error {
+	if err := m.db.TouchAPIKey(ctx, keyID, usedAt); err != nil {
+		m.log(ctx).Error("Failed to update api key last use", zap.Error(err), zap.String("keyID", keyID))
+		return errors.New("database update failed")
+	}
+	return nil
+}
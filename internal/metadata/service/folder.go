@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"go.uber.org/zap"
+)
+
+func (m *metadataService) CreateFolder(ctx context.Context, folder *metadata.Folder) error {
+	if folder.CreateAt == 0 {
+		folder.CreateAt = time.Now().Unix()
+	}
+
+	if err := m.db.InsertFolder(ctx, folder); err != nil {
+		if mapped := mapNamespaceError(err); mapped != nil {
+			return mapped
+		}
+		m.log(ctx).Error("Failed to insert folder into database",
+			zap.Error(err),
+			zap.String("folderID", folder.FolderID))
+		return errors.New("database operation failed")
+	}
+
+	m.log(ctx).Info("Folder created successfully",
+		zap.String("folderID", folder.FolderID),
+		zap.String("parentID", folder.ParentID))
+	return nil
+}
+
+func (m *metadataService) GetFolder(ctx context.Context, folderID string) (*metadata.Folder, error) {
+	folder, err := m.db.GetFolder(ctx, folderID)
+	if err != nil {
+		if errors.Is(err, db.ErrFolderNotFound) {
+			return nil, ErrFolderNotFound
+		}
+		m.log(ctx).Error("Failed to retrieve folder from database",
+			zap.Error(err),
+			zap.String("folderID", folderID))
+		return nil, errors.New("database operation failed")
+	}
+	return folder, nil
+}
+
+func (m *metadataService) FindFolderByName(ctx context.Context, userID, parentID, name string) (*metadata.Folder, error) {
+	folder, err := m.db.FindFolderByName(ctx, userID, parentID, name)
+	if err != nil {
+		m.log(ctx).Error("Failed to find folder by name",
+			zap.Error(err),
+			zap.String("parentID", parentID))
+		return nil, errors.New("database operation failed")
+	}
+	if folder == nil {
+		return nil, ErrFolderNotFound
+	}
+	return folder, nil
+}
+
+func (m *metadataService) ListFolders(ctx context.Context, userID, parentID string) ([]*metadata.Folder, error) {
+	folders, err := m.db.ListFolders(ctx, userID, parentID)
+	if err != nil {
+		m.log(ctx).Error("Failed to list folders from database",
+			zap.Error(err),
+			zap.String("parentID", parentID))
+		return nil, errors.New("database operation failed")
+	}
+	return folders, nil
+}
+
+// UpdateFolder renames a folder and/or moves it below parentID together with its subtree
+func (m *metadataService) UpdateFolder(ctx context.Context, folderID, parentID, name string) error {
+	if err := m.db.UpdateFolder(ctx, folderID, parentID, name); err != nil {
+		if mapped := mapNamespaceError(err); mapped != nil {
+			return mapped
+		}
+		m.log(ctx).Error("Failed to update folder in database",
+			zap.Error(err),
+			zap.String("folderID", folderID))
+		return errors.New("database update failed")
+	}
+
+	m.log(ctx).Info("Folder updated successfully",
+		zap.String("folderID", folderID),
+		zap.String("parentID", parentID))
+	return nil
+}
+
+func (m *metadataService) FindFileByName(ctx context.Context, userID, folderID, name string) (*metadata.FileMetadata, error) {
+	file, err := m.db.FindFileByName(ctx, userID, folderID, name)
+	if err != nil {
+		m.log(ctx).Error("Failed to find file by name",
+			zap.Error(err),
+			zap.String("folderID", folderID))
+		return nil, errors.New("database operation failed")
+	}
+	if file == nil {
+		return nil, ErrFileNotFound
+	}
+	return file, nil
+}
+
+func (m *metadataService) ListFolderFiles(ctx context.Context, userID, folderID string) ([]*metadata.FileMetadata, error) {
+	files, err := m.db.ListFolderFiles(ctx, userID, folderID)
+	if err != nil {
+		m.log(ctx).Error("Failed to list folder files from database",
+			zap.Error(err),
+			zap.String("folderID", folderID))
+		return nil, errors.New("database operation failed")
+	}
+	return files, nil
+}
+
+// UpdateFileLocation renames a file and/or moves it to folderID
+func (m *metadataService) UpdateFileLocation(ctx context.Context, fileID, folderID, name string) error {
+	if err := m.db.UpdateFileLocation(ctx, fileID, folderID, name); err != nil {
+		if mapped := mapNamespaceError(err); mapped != nil {
+			return mapped
+		}
+		m.log(ctx).Error("Failed to update file location in database",
+			zap.Error(err),
+			zap.String("fileID", fileID),
+			zap.String("folderID", folderID))
+		return errors.New("database update failed")
+	}
+
+	if err := m.cache.DeleteFileMetadata(ctx, fileID); err != nil {
+		m.log(ctx).Warn("Failed to invalidate cache after file update",
+			zap.Error(err),
+			zap.String("fileID", fileID))
+	}
+
+	m.log(ctx).Info("File location updated successfully",
+		zap.String("fileID", fileID),
+		zap.String("folderID", folderID))
+	return nil
+}
+
+// IsFolderWithin reports whether folderID is ancestorID itself or one of its descendants
+func (m *metadataService) IsFolderWithin(ctx context.Context, folderID, ancestorID string) (bool, error) {
+	within, err := m.db.IsFolderWithin(ctx, folderID, ancestorID)
+	if err != nil {
+		m.log(ctx).Error("Failed to check folder ancestry",
+			zap.Error(err),
+			zap.String("folderID", folderID),
+			zap.String("ancestorID", ancestorID))
+		return false, errors.New("database operation failed")
+	}
+	return within, nil
+}
+
+// mapNamespaceError translates the store's namespace errors, nil means err is unexpected
+func mapNamespaceError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrFolderNotFound):
+		return ErrFolderNotFound
+	case errors.Is(err, db.ErrDuplicateName):
+		return ErrNameConflict
+	case errors.Is(err, db.ErrFolderCycle):
+		return ErrInvalidMove
+	}
+	return nil
+}
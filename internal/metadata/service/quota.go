@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"go.uber.org/zap"
+)
+
+func (m *metadataService) GetUsage(ctx context.Context, userID string) (*metadata.Usage, error) {
+	usage, err := m.db.GetUsage(ctx, userID)
+	if err != nil {
+		m.log(ctx).Error("Failed to retrieve usage from database", zap.Error(err), zap.String("userID", userID))
+		return nil, errors.New("database operation failed")
+	}
+	return usage, nil
+}
+
+func (m *metadataService) GetGroupUsage(ctx context.Context, groupName string) (*metadata.Usage, error) {
+	usage, err := m.db.GetGroupUsage(ctx, groupName)
+	if err != nil {
+		m.log(ctx).Error("Failed to retrieve group usage from database", zap.Error(err), zap.String("group", groupName))
+		return nil, errors.New("database operation failed")
+	}
+	return usage, nil
+}
+
+// RecomputeUsage rebuilds the usage of a user from its files
+func (m *metadataService) RecomputeUsage(ctx context.Context, userID string) (*metadata.Usage, error) {
+	usage, err := m.db.RecomputeUsage(ctx, userID)
+	if err != nil {
+		m.log(ctx).Error("Failed to recompute usage", zap.Error(err), zap.String("userID", userID))
+		return nil, errors.New("database update failed")
+	}
+
+	m.log(ctx).Info("Usage recomputed",
+		zap.String("userID", userID),
+		zap.Int64("usedBytes", usage.UsedBytes),
+		zap.Int64("usedFiles", usage.UsedFiles))
+	return usage, nil
+}
+
+func (m *metadataService) GetQuota(ctx context.Context, subjectType, subjectID string) (*metadata.Quota, error) {
+	quota, err := m.db.GetQuota(ctx, subjectType, subjectID)
+	if err != nil {
+		if errors.Is(err, db.ErrQuotaNotFound) {
+			return nil, ErrQuotaNotFound
+		}
+		m.log(ctx).Error("Failed to retrieve quota from database",
+			zap.Error(err),
+			zap.String("subjectType", subjectType),
+			zap.String("subjectID", subjectID))
+		return nil, errors.New("database operation failed")
+	}
+	return quota, nil
+}
+
+func (m *metadataService) SetQuota(ctx context.Context, quota *metadata.Quota) error {
+	if err := m.db.SetQuota(ctx, quota); err != nil {
+		m.log(ctx).Error("Failed to set quota",
+			zap.Error(err),
+			zap.String("subjectType", quota.SubjectType),
+			zap.String("subjectID", quota.SubjectID))
+		return errors.New("database update failed")
+	}
+
+	m.log(ctx).Info("Quota set",
+		zap.String("subjectType", quota.SubjectType),
+		zap.String("subjectID", quota.SubjectID),
+		zap.Int64("maxBytes", quota.MaxBytes),
+		zap.Int64("maxFiles", quota.MaxFiles))
+	return nil
+}
+
+func (m *metadataService) DeleteQuota(ctx context.Context, subjectType, subjectID string) error {
+	if err := m.db.DeleteQuota(ctx, subjectType, subjectID); err != nil {
+		if errors.Is(err, db.ErrQuotaNotFound) {
+			return ErrQuotaNotFound
+		}
+		m.log(ctx).Error("Failed to delete quota",
+			zap.Error(err),
+			zap.String("subjectType", subjectType),
+			zap.String("subjectID", subjectID))
+		return errors.New("database update failed")
+	}
+	return nil
+}
+
+func (m *metadataService) AddGroupMember(ctx context.Context, groupName, userID string) error {
+	if err := m.db.AddGroupMember(ctx, groupName, userID); err != nil {
+		m.log(ctx).Error("Failed to add group member",
+			zap.Error(err),
+			zap.String("group", groupName),
+			zap.String("userID", userID))
+		return errors.New("database update failed")
+	}
+	return nil
+}
+
+func (m *metadataService) RemoveGroupMember(ctx context.Context, groupName, userID string) error {
+	if err := m.db.RemoveGroupMember(ctx, groupName, userID); err != nil {
+		if errors.Is(err, db.ErrGroupMemberNotFound) {
+			return ErrGroupMemberNotFound
+		}
+		m.log(ctx).Error("Failed to remove group member",
+			zap.Error(err),
+			zap.String("group", groupName),
+			zap.String("userID", userID))
+		return errors.New("database update failed")
+	}
+	return nil
+}
+
+func (m *metadataService) ListGroupMembers(ctx context.Context, groupName string) ([]string, error) {
+	members, err := m.db.ListGroupMembers(ctx, groupName)
+	if err != nil {
+		m.log(ctx).Error("Failed to list group members", zap.Error(err), zap.String("group", groupName))
+		return nil, errors.New("database operation failed")
+	}
+	return members, nil
+}
+
+func (m *metadataService) ListUserGroups(ctx context.Context, userID string) ([]string, error) {
+	groups, err := m.db.ListUserGroups(ctx, userID)
+	if err != nil {
+		m.log(ctx).Error("Failed to list user groups", zap.Error(err), zap.String("userID", userID))
+		return nil, errors.New("database operation failed")
+	}
+	return groups, nil
+}
@@ -6,31 +6,155 @@ import (
 	"github.com/roamBo/BoCloudStore/internal/metadata"
 	"github.com/roamBo/BoCloudStore/internal/metadata/cache"
 	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"github.com/roamBo/BoCloudStore/pkg/utils"
 	"go.uber.org/zap"
 	"time"
 )
 
+var (
+	ErrFileNotFound         = errors.New("file not found")
+	ErrObjectNotFound       = errors.New("object not found")
+	ErrFolderNotFound       = errors.New("folder not found")
+	ErrNameConflict         = errors.New("name already exists in folder")
+	ErrInvalidMove          = errors.New("folder cannot be moved into itself")
+	ErrFileNotTrashed       = errors.New("file is not in trash")
+	ErrVersionNotFound      = errors.New("file version not found")
+	ErrFileNotMerged        = errors.New("file is not merged")
+	ErrShareNotFound        = errors.New("share not found")
+	ErrShareLimitReached    = errors.New("share download limit reached")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrUserExists           = errors.New("username already exists")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenUsed     = errors.New("refresh token already used")
+	ErrGrantNotFound        = errors.New("grant not found")
+	ErrAPIKeyNotFound       = errors.New("api key not found")
+	ErrQuotaExceeded        = errors.New("storage quota exceeded")
+	ErrQuotaNotFound        = errors.New("quota not found")
+	ErrGroupMemberNotFound  = errors.New("user is not a member of the group")
+	ErrUploadNotActive      = errors.New("upload is not in progress")
+)
+
 type Service interface {
 	CreateFileMetadata(ctx context.Context, file *metadata.FileMetadata) error
 	SaveChunkMetadata(ctx context.Context, chunk *metadata.ChunkMetadata) error
+	ListChunkMetadata(ctx context.Context, fileID string) ([]*metadata.ChunkMetadata, error)
+	DeleteChunkMetadata(ctx context.Context, fileID string, chunkID int) error
+	DeleteAllChunkMetadata(ctx context.Context, fileID string) error
 	GetFileMetadata(ctx context.Context, fileID string) (*metadata.FileMetadata, error)
 	UpdateFileStatus(ctx context.Context, fileID, status string) error
+	ExpireUpload(ctx context.Context, fileID string) error
+	CompleteFile(ctx context.Context, fileID, storagePath, etag string) (*metadata.FileMetadata, []string, error)
+	FindFileByContentHash(ctx context.Context, userID, contentHash string, totalSize int64) (*metadata.FileMetadata, error)
+	CreateFileReference(ctx context.Context, file *metadata.FileMetadata) (*metadata.FileMetadata, []string, error)
+	ReleaseObject(ctx context.Context, storagePath string) (int64, error)
+	ClaimStaleUploads(ctx context.Context, staleBefore time.Time, limit int) ([]*metadata.FileMetadata, error)
+	ListFiles(ctx context.Context, query *metadata.FileListQuery) (*metadata.FilePage, error)
+	TrashFile(ctx context.Context, fileID string) error
+	RestoreFile(ctx context.Context, fileID string) error
+	ClaimTrashedFiles(ctx context.Context, trashedBefore, claimStaleBefore time.Time, limit int) ([]*metadata.FileMetadata, error)
+	ClaimUserTrash(ctx context.Context, userID string) ([]*metadata.FileMetadata, error)
+	PurgeFile(ctx context.Context, fileID string) ([]string, error)
+	ListFileVersions(ctx context.Context, fileID string) ([]*metadata.FileVersion, error)
+	GetFileVersion(ctx context.Context, fileID string, version int) (*metadata.FileVersion, error)
+	RestoreFileVersion(ctx context.Context, fileID string, version int) (*metadata.FileMetadata, []string, error)
+	GetMaxVersions(ctx context.Context, userID string) (int, error)
+	SetMaxVersions(ctx context.Context, userID string, maxVersions int) error
+
+	CreateFolder(ctx context.Context, folder *metadata.Folder) error
+	GetFolder(ctx context.Context, folderID string) (*metadata.Folder, error)
+	FindFolderByName(ctx context.Context, userID, parentID, name string) (*metadata.Folder, error)
+	ListFolders(ctx context.Context, userID, parentID string) ([]*metadata.Folder, error)
+	UpdateFolder(ctx context.Context, folderID, parentID, name string) error
+	FindFileByName(ctx context.Context, userID, folderID, name string) (*metadata.FileMetadata, error)
+	ListFolderFiles(ctx context.Context, userID, folderID string) ([]*metadata.FileMetadata, error)
+	UpdateFileLocation(ctx context.Context, fileID, folderID, name string) error
+	IsFolderWithin(ctx context.Context, folderID, ancestorID string) (bool, error)
+
+	CreateShare(ctx context.Context, share *metadata.Share) error
+	GetShareByToken(ctx context.Context, token string) (*metadata.Share, error)
+	ListShares(ctx context.Context, userID string) ([]*metadata.Share, error)
+	RevokeShare(ctx context.Context, shareID, userID string) error
+	ConsumeShareDownload(ctx context.Context, shareID string) error
+
+	CreateUser(ctx context.Context, user *metadata.User) error
+	GetUser(ctx context.Context, userID string) (*metadata.User, error)
+	GetUserByName(ctx context.Context, username string) (*metadata.User, error)
+	SetUserRole(ctx context.Context, userID, role string) error
+
+	CreateRefreshToken(ctx context.Context, token *metadata.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*metadata.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, usedID string, next *metadata.RefreshToken) error
+	RevokeTokenFamily(ctx context.Context, familyID string) ([]*metadata.RefreshToken, error)
+	RevokeUserTokens(ctx context.Context, userID string) ([]*metadata.RefreshToken, error)
+
+	GetUsage(ctx context.Context, userID string) (*metadata.Usage, error)
+	GetGroupUsage(ctx context.Context, groupName string) (*metadata.Usage, error)
+	RecomputeUsage(ctx context.Context, userID string) (*metadata.Usage, error)
+	GetQuota(ctx context.Context, subjectType, subjectID string) (*metadata.Quota, error)
+	SetQuota(ctx context.Context, quota *metadata.Quota) error
+	DeleteQuota(ctx context.Context, subjectType, subjectID string) error
+	AddGroupMember(ctx context.Context, groupName, userID string) error
+	RemoveGroupMember(ctx context.Context, groupName, userID string) error
+	ListGroupMembers(ctx context.Context, groupName string) ([]string, error)
+	ListUserGroups(ctx context.Context, userID string) ([]string, error)
+
+	CreateAPIKey(ctx context.Context, key *metadata.APIKey) error
+	GetAPIKey(ctx context.Context, keyID string) (*metadata.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*metadata.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]*metadata.APIKey, error)
+	RevokeAPIKey(ctx context.Context, keyID string) error
+	TouchAPIKey(ctx context.Context, keyID string, usedAt int64) error
+
+	SaveGrant(ctx context.Context, grant *metadata.Grant) error
+	DeleteGrant(ctx context.Context, resourceType, resourceID, userID string) error
+	ListGrants(ctx context.Context, resourceType, resourceID string) ([]*metadata.Grant, error)
+	ListUserGrants(ctx context.Context, userID string) ([]*metadata.Grant, error)
+	ListRoles(ctx context.Context, userID, resourceType, resourceID, folderID string) ([]string, error)
 }
 
 type metadataService struct {
-	db     db.PostgresStore
-	cache  cache.RedisCache
-	logger *zap.Logger
+	db           db.PostgresStore
+	cache        cache.MetadataCache
+	logger       *zap.Logger
+	maxVersions  int            //previous versions kept per file unless the user set a policy
+	defaultQuota metadata.Quota //limits of users without a quota of their own
+}
+
+type Option func(*metadataService)
+
+func WithMaxVersions(count int) Option {
+	return func(m *metadataService) {
+		if count >= 0 {
+			m.maxVersions = count
+		}
+	}
 }
 
-func NewService(db db.PostgresStore, cache cache.RedisCache, logger *zap.Logger) Service {
-	return &metadataService{
-		db:     db,
-		cache:  cache,
-		logger: logger,
+// WithDefaultQuota limits users without a quota of their own, 0 is unlimited
+func WithDefaultQuota(maxBytes, maxFiles int64) Option {
+	return func(m *metadataService) {
+		m.defaultQuota = metadata.Quota{SubjectType: metadata.QuotaUser, MaxBytes: maxBytes, MaxFiles: maxFiles}
 	}
 }
 
+// log is the logger of the request ctx belongs to, see utils.LoggerFrom
+func (m *metadataService) log(ctx context.Context) *zap.Logger {
+	return utils.LoggerFrom(ctx, m.logger)
+}
+
+func NewService(db db.PostgresStore, cache cache.MetadataCache, logger *zap.Logger, opts ...Option) Service {
+	m := &metadataService{
+		db:          db,
+		cache:       cache,
+		logger:      logger,
+		maxVersions: 10,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
 func (m *metadataService) CreateFileMetadata(ctx context.Context, file *metadata.FileMetadata) error {
 	// Set timestamps if not provided
 	if file.CreateAt == 0 {
@@ -38,9 +162,16 @@ func (m *metadataService) CreateFileMetadata(ctx context.Context, file *metadata
 	}
 	file.UpdateAt = time.Now().Unix()
 
-	// Insert into database
-	if err := m.db.InsertFile(ctx, file); err != nil {
-		m.logger.Error("failed to insert file metadata into database",
+	// Insert into database, an upload reserves its size against the quotas of the user
+	if err := m.db.InsertFile(ctx, file, m.defaultQuota); err != nil {
+		if errors.Is(err, db.ErrQuotaExceeded) {
+			m.log(ctx).Info("file rejected over quota",
+				zap.Error(err),
+				zap.String("fileID", file.FileID),
+				zap.Int64("totalSize", file.TotalSize))
+			return ErrQuotaExceeded
+		}
+		m.log(ctx).Error("failed to insert file metadata into database",
 			zap.Error(err),
 			zap.String("fileID", file.FileID))
 		return errors.New("database operation failed")
@@ -48,58 +179,87 @@ func (m *metadataService) CreateFileMetadata(ctx context.Context, file *metadata
 
 	// Cache file metadata (TTL: 1 hour)
 	if err := m.cache.SetFileMetadata(ctx, file); err != nil {
-		m.logger.Warn("failed to cache file metadata",
+		m.log(ctx).Warn("failed to cache file metadata",
 			zap.Error(err),
 			zap.String("fileID", file.FileID))
 		// Non-critical error, proceed without returning error
 	}
 
-	m.logger.Info("file metadata created successfully",
+	m.log(ctx).Info("file metadata created successfully",
 		zap.String("fileID", file.FileID),
 		zap.String("status", file.Status))
 	return nil
 }
 func (m *metadataService) SaveChunkMetadata(ctx context.Context, chunk *metadata.ChunkMetadata) error {
 	if err := m.db.InsertChunk(ctx, chunk); err != nil {
-		m.logger.Error("Failed to save chunk metadata to database",
+		m.log(ctx).Error("Failed to save chunk metadata to database",
 			zap.Error(err),
 			zap.String("fileID", chunk.FileID),
-			zap.String("chunkID", chunk.ChunkID))
+			zap.Int("chunkID", chunk.ChunkID))
 		return errors.New("database operation failed")
 	}
-	m.logger.Info("Chunk metadata saved successfully",
+	m.log(ctx).Info("Chunk metadata saved successfully",
 		zap.String("fileID", chunk.FileID),
-		zap.String("chunkID", chunk.ChunkID))
+		zap.Int("chunkID", chunk.ChunkID))
+	return nil
+}
+func (m *metadataService) ListChunkMetadata(ctx context.Context, fileID string) ([]*metadata.ChunkMetadata, error) {
+	chunks, err := m.db.ListChunks(ctx, fileID)
+	if err != nil {
+		m.log(ctx).Error("Failed to list chunk metadata from database",
+			zap.Error(err),
+			zap.String("fileID", fileID))
+		return nil, errors.New("database operation failed")
+	}
+	return chunks, nil
+}
+func (m *metadataService) DeleteChunkMetadata(ctx context.Context, fileID string, chunkID int) error {
+	if err := m.db.DeleteChunk(ctx, fileID, chunkID); err != nil {
+		m.log(ctx).Error("Failed to delete chunk metadata from database",
+			zap.Error(err),
+			zap.String("fileID", fileID),
+			zap.Int("chunkID", chunkID))
+		return errors.New("database operation failed")
+	}
+	return nil
+}
+func (m *metadataService) DeleteAllChunkMetadata(ctx context.Context, fileID string) error {
+	if err := m.db.DeleteChunks(ctx, fileID); err != nil {
+		m.log(ctx).Error("Failed to delete chunk metadata from database",
+			zap.Error(err),
+			zap.String("fileID", fileID))
+		return errors.New("database operation failed")
+	}
 	return nil
 }
 func (m *metadataService) GetFileMetadata(ctx context.Context, fileID string) (*metadata.FileMetadata, error) {
 	// Try to get from cache first
-	if file, err := m.cache.GetFileMetadata(ctx, fileID); err == nil {
-		m.logger.Info("Retrieved file metadata from cache",
+	if file, err := m.cache.GetFileMetadata(ctx, fileID); err == nil && file != nil {
+		m.log(ctx).Info("Retrieved file metadata from cache",
 			zap.String("fileID", fileID))
 		return file, nil
 	}
 	// Fallback to database if cache miss
 	file, err := m.db.GetFile(ctx, fileID)
 	if err != nil {
-		m.logger.Error("Failed to retrieve file metadata from database",
+		m.log(ctx).Error("Failed to retrieve file metadata from database",
 			zap.Error(err),
 			zap.String("fileID", fileID))
-		return nil, errors.New("file not found")
+		return nil, ErrFileNotFound
 	}
 
 	if err := m.cache.SetFileMetadata(ctx, file); err != nil {
-		m.logger.Warn("Failed to cache file metadata after retrieval",
+		m.log(ctx).Warn("Failed to cache file metadata after retrieval",
 			zap.Error(err),
 			zap.String("fileID", fileID))
 	}
-	m.logger.Info("Retrieved file metadata from database",
+	m.log(ctx).Info("Retrieved file metadata from database",
 		zap.String("fileID", fileID))
 	return file, nil
 }
 func (m *metadataService) UpdateFileStatus(ctx context.Context, fileID, status string) error {
 	if err := m.db.UpdateFileStatus(ctx, fileID, status); err != nil {
-		m.logger.Error("Failed to update file status in database",
+		m.log(ctx).Error("Failed to update file status in database",
 			zap.Error(err),
 			zap.String("fileID", fileID),
 			zap.String("status", status))
@@ -108,13 +268,194 @@ func (m *metadataService) UpdateFileStatus(ctx context.Context, fileID, status s
 
 	// Invalidate cache to ensure consistency
 	if err := m.cache.DeleteFileMetadata(ctx, fileID); err != nil {
-		m.logger.Warn("Failed to invalidate cache after status update",
+		m.log(ctx).Warn("Failed to invalidate cache after status update",
 			zap.Error(err),
 			zap.String("fileID", fileID))
 	}
 
-	m.logger.Info("File status updated successfully",
+	m.log(ctx).Info("File status updated successfully",
 		zap.String("fileID", fileID),
 		zap.String("newStatus", status))
 	return nil
 }
+
+// ExpireUpload marks an upload claimed by the reaper expired and releases its reserved quota
+func (m *metadataService) ExpireUpload(ctx context.Context, fileID string) error {
+	if err := m.db.ExpireUpload(ctx, fileID); err != nil {
+		m.log(ctx).Error("Failed to expire upload in database",
+			zap.Error(err),
+			zap.String("fileID", fileID))
+		return errors.New("database update failed")
+	}
+
+	if err := m.cache.DeleteFileMetadata(ctx, fileID); err != nil {
+		m.log(ctx).Warn("Failed to invalidate cache after expiring upload",
+			zap.Error(err),
+			zap.String("fileID", fileID))
+	}
+	return nil
+}
+
+// CompleteFile marks an upload merged. If a merged file with the same name exists in the folder the
+// upload replaces its content and that file is returned instead. The returned paths are objects left
+// without any reference after pruning old versions, the caller removes them from storage.
+func (m *metadataService) CompleteFile(ctx context.Context, fileID, storagePath, etag string) (*metadata.FileMetadata, []string, error) {
+	file, orphans, err := m.db.CompleteFile(ctx, fileID, storagePath, etag, m.maxVersions)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicateName) {
+			return nil, nil, ErrNameConflict
+		}
+		if errors.Is(err, db.ErrUploadNotActive) {
+			return nil, nil, ErrUploadNotActive
+		}
+		m.log(ctx).Error("Failed to complete file in database",
+			zap.Error(err),
+			zap.String("fileID", fileID),
+			zap.String("storagePath", storagePath))
+		return nil, nil, errors.New("database update failed")
+	}
+
+	m.invalidateFiles(ctx, fileID, file.FileID)
+
+	m.log(ctx).Info("File merged successfully",
+		zap.String("fileID", file.FileID),
+		zap.String("uploadID", fileID),
+		zap.Int("version", file.Version),
+		zap.String("storagePath", storagePath))
+	return file, orphans, nil
+}
+
+// FindFileByContentHash looks for a merged file of userID with the given content
+func (m *metadataService) FindFileByContentHash(ctx context.Context, userID, contentHash string, totalSize int64) (*metadata.FileMetadata, error) {
+	file, err := m.db.FindMergedFileByHash(ctx, userID, contentHash, totalSize)
+	if err != nil {
+		m.log(ctx).Error("Failed to find file by content hash",
+			zap.Error(err),
+			zap.String("userID", userID),
+			zap.String("contentHash", contentHash))
+		return nil, errors.New("database operation failed")
+	}
+	if file == nil {
+		return nil, ErrFileNotFound
+	}
+	return file, nil
+}
+
+// CreateFileReference creates a merged file that shares the object at file.StoragePath, or makes it
+// the new content of the merged file already holding the name. Like CompleteFile it returns the
+// resulting file and the objects left without reference.
+func (m *metadataService) CreateFileReference(ctx context.Context, file *metadata.FileMetadata) (*metadata.FileMetadata, []string, error) {
+	uploadID := file.FileID
+	file, orphans, err := m.db.InsertFileReference(ctx, file, m.maxVersions, m.defaultQuota)
+	if err != nil {
+		if errors.Is(err, db.ErrObjectNotFound) {
+			return nil, nil, ErrObjectNotFound
+		}
+		if errors.Is(err, db.ErrQuotaExceeded) {
+			m.log(ctx).Info("file reference rejected over quota", zap.Error(err), zap.String("fileID", uploadID))
+			return nil, nil, ErrQuotaExceeded
+		}
+		if mapped := mapNamespaceError(err); mapped != nil {
+			return nil, nil, mapped
+		}
+		m.log(ctx).Error("Failed to insert file reference into database",
+			zap.Error(err),
+			zap.String("fileID", uploadID))
+		return nil, nil, errors.New("database operation failed")
+	}
+
+	if err := m.cache.SetFileMetadata(ctx, file); err != nil {
+		m.log(ctx).Warn("failed to cache file metadata",
+			zap.Error(err),
+			zap.String("fileID", file.FileID))
+	}
+
+	m.log(ctx).Info("File reference created successfully",
+		zap.String("fileID", file.FileID),
+		zap.String("storagePath", file.StoragePath))
+	return file, orphans, nil
+}
+
+// ReleaseObject drops one reference to a stored object and returns how many are left
+func (m *metadataService) ReleaseObject(ctx context.Context, storagePath string) (int64, error) {
+	remaining, err := m.db.ReleaseObject(ctx, storagePath)
+	if err != nil {
+		if errors.Is(err, db.ErrObjectNotFound) {
+			return 0, ErrObjectNotFound
+		}
+		m.log(ctx).Error("Failed to release object reference",
+			zap.Error(err),
+			zap.String("storagePath", storagePath))
+		return 0, errors.New("database update failed")
+	}
+	return remaining, nil
+}
+
+// ClaimStaleUploads claims uploads untouched since staleBefore for expiry, safe to call from several replicas
+func (m *metadataService) ClaimStaleUploads(ctx context.Context, staleBefore time.Time, limit int) ([]*metadata.FileMetadata, error) {
+	files, err := m.db.ClaimStaleUploads(ctx, staleBefore.Unix(), limit)
+	if err != nil {
+		m.log(ctx).Error("Failed to claim stale uploads",
+			zap.Error(err))
+		return nil, errors.New("database update failed")
+	}
+	for _, file := range files {
+		if err := m.cache.DeleteFileMetadata(ctx, file.FileID); err != nil {
+			m.log(ctx).Warn("Failed to invalidate cache after claiming upload",
+				zap.Error(err),
+				zap.String("fileID", file.FileID))
+		}
+	}
+	return files, nil
+}
+
+// ListFiles returns one page of the user's files, rows warm in the cache are not read from the database
+func (m *metadataService) ListFiles(ctx context.Context, query *metadata.FileListQuery) (*metadata.FilePage, error) {
+	fileIDs, next, err := m.db.ListFileIDs(ctx, query)
+	if err != nil {
+		m.log(ctx).Error("Failed to list files from database",
+			zap.Error(err),
+			zap.String("userID", query.UserID))
+		return nil, errors.New("database operation failed")
+	}
+
+	cached, err := m.cache.BatchGet(ctx, fileIDs)
+	if err != nil {
+		m.log(ctx).Warn("Failed to batch get file metadata from cache",
+			zap.Error(err))
+		cached = map[string]*metadata.FileMetadata{}
+	}
+
+	var missing []string
+	for _, fileID := range fileIDs {
+		if _, ok := cached[fileID]; !ok {
+			missing = append(missing, fileID)
+		}
+	}
+	if len(missing) > 0 {
+		files, err := m.db.GetFiles(ctx, missing)
+		if err != nil {
+			m.log(ctx).Error("Failed to retrieve files from database",
+				zap.Error(err),
+				zap.Int("count", len(missing)))
+			return nil, errors.New("database operation failed")
+		}
+		for _, file := range files {
+			cached[file.FileID] = file
+			if err := m.cache.SetFileMetadata(ctx, file); err != nil {
+				m.log(ctx).Warn("Failed to cache file metadata after listing",
+					zap.Error(err),
+					zap.String("fileID", file.FileID))
+			}
+		}
+	}
+
+	page := &metadata.FilePage{Files: make([]*metadata.FileMetadata, 0, len(fileIDs)), Next: next}
+	for _, fileID := range fileIDs {
+		// a file deleted between both reads is skipped
+		if file, ok := cached[fileID]; ok {
+			page.Files = append(page.Files, file)
+		}
+	}
+	return page, nil
+}
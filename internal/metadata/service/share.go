@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"go.uber.org/zap"
+)
+
+func (m *metadataService) CreateShare(ctx context.Context, share *metadata.Share) error {
+	if err := m.db.InsertShare(ctx, share); err != nil {
+		m.log(ctx).Error("Failed to insert share into database",
+			zap.Error(err),
+			zap.String("shareID", share.ShareID),
+			zap.String("userID", share.UserID))
+		return errors.New("database operation failed")
+	}
+
+	m.log(ctx).Info("Share created",
+		zap.String("shareID", share.ShareID),
+		zap.String("userID", share.UserID),
+		zap.String("resourceType", share.ResourceType),
+		zap.String("resourceID", share.ResourceID),
+		zap.String("mode", share.Mode))
+	return nil
+}
+
+// GetShareByToken looks up a share by its token, revoked and expired shares are returned as well
+func (m *metadataService) GetShareByToken(ctx context.Context, token string) (*metadata.Share, error) {
+	share, err := m.db.GetShareByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, db.ErrShareNotFound) {
+			return nil, ErrShareNotFound
+		}
+		m.log(ctx).Error("Failed to retrieve share from database", zap.Error(err))
+		return nil, errors.New("database operation failed")
+	}
+	return share, nil
+}
+
+func (m *metadataService) ListShares(ctx context.Context, userID string) ([]*metadata.Share, error) {
+	shares, err := m.db.ListShares(ctx, userID)
+	if err != nil {
+		m.log(ctx).Error("Failed to list shares from database",
+			zap.Error(err),
+			zap.String("userID", userID))
+		return nil, errors.New("database operation failed")
+	}
+	return shares, nil
+}
+
+func (m *metadataService) RevokeShare(ctx context.Context, shareID, userID string) error {
+	if err := m.db.RevokeShare(ctx, shareID, userID); err != nil {
+		if errors.Is(err, db.ErrShareNotFound) {
+			return ErrShareNotFound
+		}
+		m.log(ctx).Error("Failed to revoke share in database",
+			zap.Error(err),
+			zap.String("shareID", shareID),
+			zap.String("userID", userID))
+		return errors.New("database update failed")
+	}
+
+	m.log(ctx).Info("Share revoked", zap.String("shareID", shareID), zap.String("userID", userID))
+	return nil
+}
+
+// ConsumeShareDownload counts one download of the share, ErrShareLimitReached once none is left
+func (m *metadataService) ConsumeShareDownload(ctx context.Context, shareID string) error {
+	if err := m.db.ConsumeShareDownload(ctx, shareID); err != nil {
+		if errors.Is(err, db.ErrShareLimitReached) {
+			return ErrShareLimitReached
+		}
+		m.log(ctx).Error("Failed to count share download",
+			zap.Error(err),
+			zap.String("shareID", shareID))
+		return errors.New("database update failed")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"go.uber.org/zap"
+)
+
+func (m *metadataService) CreateRefreshToken(ctx context.Context, token *metadata.RefreshToken) error {
+	if err := m.db.InsertRefreshToken(ctx, token); err != nil {
+		m.log(ctx).Error("Failed to insert refresh token into database",
+			zap.Error(err),
+			zap.String("userID", token.UserID),
+			zap.String("familyID", token.FamilyID))
+		return errors.New("database operation failed")
+	}
+	return nil
+}
+
+func (m *metadataService) GetRefreshToken(ctx context.Context, tokenHash string) (*metadata.RefreshToken, error) {
+	token, err := m.db.GetRefreshToken(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, db.ErrRefreshTokenNotFound) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		m.log(ctx).Error("Failed to retrieve refresh token from database", zap.Error(err))
+		return nil, errors.New("database operation failed")
+	}
+	return token, nil
+}
+
+// RotateRefreshToken replaces a refresh token with next, ErrRefreshTokenUsed if it was already used or revoked
+func (m *metadataService) RotateRefreshToken(ctx context.Context, usedID string, next *metadata.RefreshToken) error {
+	if err := m.db.RotateRefreshToken(ctx, usedID, next); err != nil {
+		if errors.Is(err, db.ErrRefreshTokenUsed) {
+			return ErrRefreshTokenUsed
+		}
+		m.log(ctx).Error("Failed to rotate refresh token",
+			zap.Error(err),
+			zap.String("tokenID", usedID),
+			zap.String("familyID", next.FamilyID))
+		return errors.New("database update failed")
+	}
+	return nil
+}
+
+func (m *metadataService) RevokeTokenFamily(ctx context.Context, familyID string) ([]*metadata.RefreshToken, error) {
+	tokens, err := m.db.RevokeTokenFamily(ctx, familyID)
+	if err != nil {
+		m.log(ctx).Error("Failed to revoke refresh token family",
+			zap.Error(err),
+			zap.String("familyID", familyID))
+		return nil, errors.New("database update failed")
+	}
+
+	m.log(ctx).Info("Refresh token family revoked", zap.String("familyID", familyID), zap.Int("count", len(tokens)))
+	return tokens, nil
+}
+
+func (m *metadataService) RevokeUserTokens(ctx context.Context, userID string) ([]*metadata.RefreshToken, error) {
+	tokens, err := m.db.RevokeUserTokens(ctx, userID)
+	if err != nil {
+		m.log(ctx).Error("Failed to revoke refresh tokens of user",
+			zap.Error(err),
+			zap.String("userID", userID))
+		return nil, errors.New("database update failed")
+	}
+
+	m.log(ctx).Info("Refresh tokens of user revoked", zap.String("userID", userID), zap.Int("count", len(tokens)))
+	return tokens, nil
+}
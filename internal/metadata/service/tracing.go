@@ -0,0 +1,428 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/observability/tracing"
+)
+
+// tracedService runs every call of the metadata service in a span, so the database and cache
+// spans of a request are grouped by the call they belong to
+type tracedService struct {
+	next Service
+}
+
+// NewTracedService wraps next so its calls are traced
+func NewTracedService(next Service) Service {
+	return &tracedService{next: next}
+}
+
+func (t *tracedService) CreateFileMetadata(ctx context.Context, file *metadata.FileMetadata) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.CreateFileMetadata")
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateFileMetadata(ctx, file)
+}
+
+func (t *tracedService) SaveChunkMetadata(ctx context.Context, chunk *metadata.ChunkMetadata) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.SaveChunkMetadata")
+	defer func() { tracing.End(span, err) }()
+	return t.next.SaveChunkMetadata(ctx, chunk)
+}
+
+func (t *tracedService) ListChunkMetadata(ctx context.Context, fileID string) (_ []*metadata.ChunkMetadata, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListChunkMetadata")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListChunkMetadata(ctx, fileID)
+}
+
+func (t *tracedService) DeleteChunkMetadata(ctx context.Context, fileID string, chunkID int) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.DeleteChunkMetadata")
+	defer func() { tracing.End(span, err) }()
+	return t.next.DeleteChunkMetadata(ctx, fileID, chunkID)
+}
+
+func (t *tracedService) DeleteAllChunkMetadata(ctx context.Context, fileID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.DeleteAllChunkMetadata")
+	defer func() { tracing.End(span, err) }()
+	return t.next.DeleteAllChunkMetadata(ctx, fileID)
+}
+
+func (t *tracedService) GetFileMetadata(ctx context.Context, fileID string) (_ *metadata.FileMetadata, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetFileMetadata")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetFileMetadata(ctx, fileID)
+}
+
+func (t *tracedService) UpdateFileStatus(ctx context.Context, fileID, status string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.UpdateFileStatus")
+	defer func() { tracing.End(span, err) }()
+	return t.next.UpdateFileStatus(ctx, fileID, status)
+}
+
+func (t *tracedService) ExpireUpload(ctx context.Context, fileID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ExpireUpload")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ExpireUpload(ctx, fileID)
+}
+
+func (t *tracedService) CompleteFile(ctx context.Context, fileID, storagePath, etag string) (_ *metadata.FileMetadata, _ []string, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.CompleteFile")
+	defer func() { tracing.End(span, err) }()
+	return t.next.CompleteFile(ctx, fileID, storagePath, etag)
+}
+
+func (t *tracedService) FindFileByContentHash(ctx context.Context, userID, contentHash string, totalSize int64) (_ *metadata.FileMetadata, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.FindFileByContentHash")
+	defer func() { tracing.End(span, err) }()
+	return t.next.FindFileByContentHash(ctx, userID, contentHash, totalSize)
+}
+
+func (t *tracedService) CreateFileReference(ctx context.Context, file *metadata.FileMetadata) (_ *metadata.FileMetadata, _ []string, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.CreateFileReference")
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateFileReference(ctx, file)
+}
+
+func (t *tracedService) ReleaseObject(ctx context.Context, storagePath string) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ReleaseObject")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ReleaseObject(ctx, storagePath)
+}
+
+func (t *tracedService) ClaimStaleUploads(ctx context.Context, staleBefore time.Time, limit int) (_ []*metadata.FileMetadata, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ClaimStaleUploads")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ClaimStaleUploads(ctx, staleBefore, limit)
+}
+
+func (t *tracedService) ListFiles(ctx context.Context, query *metadata.FileListQuery) (_ *metadata.FilePage, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListFiles")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListFiles(ctx, query)
+}
+
+func (t *tracedService) TrashFile(ctx context.Context, fileID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.TrashFile")
+	defer func() { tracing.End(span, err) }()
+	return t.next.TrashFile(ctx, fileID)
+}
+
+func (t *tracedService) RestoreFile(ctx context.Context, fileID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.RestoreFile")
+	defer func() { tracing.End(span, err) }()
+	return t.next.RestoreFile(ctx, fileID)
+}
+
+func (t *tracedService) ClaimTrashedFiles(ctx context.Context, trashedBefore, claimStaleBefore time.Time, limit int) (_ []*metadata.FileMetadata, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ClaimTrashedFiles")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ClaimTrashedFiles(ctx, trashedBefore, claimStaleBefore, limit)
+}
+
+func (t *tracedService) ClaimUserTrash(ctx context.Context, userID string) (_ []*metadata.FileMetadata, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ClaimUserTrash")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ClaimUserTrash(ctx, userID)
+}
+
+func (t *tracedService) PurgeFile(ctx context.Context, fileID string) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.PurgeFile")
+	defer func() { tracing.End(span, err) }()
+	return t.next.PurgeFile(ctx, fileID)
+}
+
+func (t *tracedService) ListFileVersions(ctx context.Context, fileID string) (_ []*metadata.FileVersion, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListFileVersions")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListFileVersions(ctx, fileID)
+}
+
+func (t *tracedService) GetFileVersion(ctx context.Context, fileID string, version int) (_ *metadata.FileVersion, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetFileVersion")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetFileVersion(ctx, fileID, version)
+}
+
+func (t *tracedService) RestoreFileVersion(ctx context.Context, fileID string, version int) (_ *metadata.FileMetadata, _ []string, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.RestoreFileVersion")
+	defer func() { tracing.End(span, err) }()
+	return t.next.RestoreFileVersion(ctx, fileID, version)
+}
+
+func (t *tracedService) GetMaxVersions(ctx context.Context, userID string) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetMaxVersions")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetMaxVersions(ctx, userID)
+}
+
+func (t *tracedService) SetMaxVersions(ctx context.Context, userID string, maxVersions int) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.SetMaxVersions")
+	defer func() { tracing.End(span, err) }()
+	return t.next.SetMaxVersions(ctx, userID, maxVersions)
+}
+
+func (t *tracedService) CreateFolder(ctx context.Context, folder *metadata.Folder) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.CreateFolder")
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateFolder(ctx, folder)
+}
+
+func (t *tracedService) GetFolder(ctx context.Context, folderID string) (_ *metadata.Folder, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetFolder")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetFolder(ctx, folderID)
+}
+
+func (t *tracedService) FindFolderByName(ctx context.Context, userID, parentID, name string) (_ *metadata.Folder, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.FindFolderByName")
+	defer func() { tracing.End(span, err) }()
+	return t.next.FindFolderByName(ctx, userID, parentID, name)
+}
+
+func (t *tracedService) ListFolders(ctx context.Context, userID, parentID string) (_ []*metadata.Folder, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListFolders")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListFolders(ctx, userID, parentID)
+}
+
+func (t *tracedService) UpdateFolder(ctx context.Context, folderID, parentID, name string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.UpdateFolder")
+	defer func() { tracing.End(span, err) }()
+	return t.next.UpdateFolder(ctx, folderID, parentID, name)
+}
+
+func (t *tracedService) FindFileByName(ctx context.Context, userID, folderID, name string) (_ *metadata.FileMetadata, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.FindFileByName")
+	defer func() { tracing.End(span, err) }()
+	return t.next.FindFileByName(ctx, userID, folderID, name)
+}
+
+func (t *tracedService) ListFolderFiles(ctx context.Context, userID, folderID string) (_ []*metadata.FileMetadata, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListFolderFiles")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListFolderFiles(ctx, userID, folderID)
+}
+
+func (t *tracedService) UpdateFileLocation(ctx context.Context, fileID, folderID, name string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.UpdateFileLocation")
+	defer func() { tracing.End(span, err) }()
+	return t.next.UpdateFileLocation(ctx, fileID, folderID, name)
+}
+
+func (t *tracedService) IsFolderWithin(ctx context.Context, folderID, ancestorID string) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.IsFolderWithin")
+	defer func() { tracing.End(span, err) }()
+	return t.next.IsFolderWithin(ctx, folderID, ancestorID)
+}
+
+func (t *tracedService) CreateShare(ctx context.Context, share *metadata.Share) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.CreateShare")
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateShare(ctx, share)
+}
+
+func (t *tracedService) GetShareByToken(ctx context.Context, token string) (_ *metadata.Share, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetShareByToken")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetShareByToken(ctx, token)
+}
+
+func (t *tracedService) ListShares(ctx context.Context, userID string) (_ []*metadata.Share, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListShares")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListShares(ctx, userID)
+}
+
+func (t *tracedService) RevokeShare(ctx context.Context, shareID, userID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.RevokeShare")
+	defer func() { tracing.End(span, err) }()
+	return t.next.RevokeShare(ctx, shareID, userID)
+}
+
+func (t *tracedService) ConsumeShareDownload(ctx context.Context, shareID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ConsumeShareDownload")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ConsumeShareDownload(ctx, shareID)
+}
+
+func (t *tracedService) CreateUser(ctx context.Context, user *metadata.User) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.CreateUser")
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateUser(ctx, user)
+}
+
+func (t *tracedService) GetUser(ctx context.Context, userID string) (_ *metadata.User, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetUser")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetUser(ctx, userID)
+}
+
+func (t *tracedService) GetUserByName(ctx context.Context, username string) (_ *metadata.User, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetUserByName")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetUserByName(ctx, username)
+}
+
+func (t *tracedService) SetUserRole(ctx context.Context, userID, role string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.SetUserRole")
+	defer func() { tracing.End(span, err) }()
+	return t.next.SetUserRole(ctx, userID, role)
+}
+
+func (t *tracedService) CreateRefreshToken(ctx context.Context, token *metadata.RefreshToken) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.CreateRefreshToken")
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateRefreshToken(ctx, token)
+}
+
+func (t *tracedService) GetRefreshToken(ctx context.Context, tokenHash string) (_ *metadata.RefreshToken, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetRefreshToken")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetRefreshToken(ctx, tokenHash)
+}
+
+func (t *tracedService) RotateRefreshToken(ctx context.Context, usedID string, next *metadata.RefreshToken) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.RotateRefreshToken")
+	defer func() { tracing.End(span, err) }()
+	return t.next.RotateRefreshToken(ctx, usedID, next)
+}
+
+func (t *tracedService) RevokeTokenFamily(ctx context.Context, familyID string) (_ []*metadata.RefreshToken, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.RevokeTokenFamily")
+	defer func() { tracing.End(span, err) }()
+	return t.next.RevokeTokenFamily(ctx, familyID)
+}
+
+func (t *tracedService) RevokeUserTokens(ctx context.Context, userID string) (_ []*metadata.RefreshToken, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.RevokeUserTokens")
+	defer func() { tracing.End(span, err) }()
+	return t.next.RevokeUserTokens(ctx, userID)
+}
+
+func (t *tracedService) GetUsage(ctx context.Context, userID string) (_ *metadata.Usage, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetUsage")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetUsage(ctx, userID)
+}
+
+func (t *tracedService) GetGroupUsage(ctx context.Context, groupName string) (_ *metadata.Usage, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetGroupUsage")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetGroupUsage(ctx, groupName)
+}
+
+func (t *tracedService) RecomputeUsage(ctx context.Context, userID string) (_ *metadata.Usage, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.RecomputeUsage")
+	defer func() { tracing.End(span, err) }()
+	return t.next.RecomputeUsage(ctx, userID)
+}
+
+func (t *tracedService) GetQuota(ctx context.Context, subjectType, subjectID string) (_ *metadata.Quota, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetQuota")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetQuota(ctx, subjectType, subjectID)
+}
+
+func (t *tracedService) SetQuota(ctx context.Context, quota *metadata.Quota) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.SetQuota")
+	defer func() { tracing.End(span, err) }()
+	return t.next.SetQuota(ctx, quota)
+}
+
+func (t *tracedService) DeleteQuota(ctx context.Context, subjectType, subjectID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.DeleteQuota")
+	defer func() { tracing.End(span, err) }()
+	return t.next.DeleteQuota(ctx, subjectType, subjectID)
+}
+
+func (t *tracedService) AddGroupMember(ctx context.Context, groupName, userID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.AddGroupMember")
+	defer func() { tracing.End(span, err) }()
+	return t.next.AddGroupMember(ctx, groupName, userID)
+}
+
+func (t *tracedService) RemoveGroupMember(ctx context.Context, groupName, userID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.RemoveGroupMember")
+	defer func() { tracing.End(span, err) }()
+	return t.next.RemoveGroupMember(ctx, groupName, userID)
+}
+
+func (t *tracedService) ListGroupMembers(ctx context.Context, groupName string) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListGroupMembers")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListGroupMembers(ctx, groupName)
+}
+
+func (t *tracedService) ListUserGroups(ctx context.Context, userID string) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListUserGroups")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListUserGroups(ctx, userID)
+}
+
+func (t *tracedService) CreateAPIKey(ctx context.Context, key *metadata.APIKey) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.CreateAPIKey")
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateAPIKey(ctx, key)
+}
+
+func (t *tracedService) GetAPIKey(ctx context.Context, keyID string) (_ *metadata.APIKey, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetAPIKey")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetAPIKey(ctx, keyID)
+}
+
+func (t *tracedService) GetAPIKeyByHash(ctx context.Context, keyHash string) (_ *metadata.APIKey, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.GetAPIKeyByHash")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetAPIKeyByHash(ctx, keyHash)
+}
+
+func (t *tracedService) ListAPIKeys(ctx context.Context, userID string) (_ []*metadata.APIKey, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListAPIKeys")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListAPIKeys(ctx, userID)
+}
+
+func (t *tracedService) RevokeAPIKey(ctx context.Context, keyID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.RevokeAPIKey")
+	defer func() { tracing.End(span, err) }()
+	return t.next.RevokeAPIKey(ctx, keyID)
+}
+
+func (t *tracedService) TouchAPIKey(ctx context.Context, keyID string, usedAt int64) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.TouchAPIKey")
+	defer func() { tracing.End(span, err) }()
+	return t.next.TouchAPIKey(ctx, keyID, usedAt)
+}
+
+func (t *tracedService) SaveGrant(ctx context.Context, grant *metadata.Grant) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.SaveGrant")
+	defer func() { tracing.End(span, err) }()
+	return t.next.SaveGrant(ctx, grant)
+}
+
+func (t *tracedService) DeleteGrant(ctx context.Context, resourceType, resourceID, userID string) (err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.DeleteGrant")
+	defer func() { tracing.End(span, err) }()
+	return t.next.DeleteGrant(ctx, resourceType, resourceID, userID)
+}
+
+func (t *tracedService) ListGrants(ctx context.Context, resourceType, resourceID string) (_ []*metadata.Grant, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListGrants")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListGrants(ctx, resourceType, resourceID)
+}
+
+func (t *tracedService) ListUserGrants(ctx context.Context, userID string) (_ []*metadata.Grant, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListUserGrants")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListUserGrants(ctx, userID)
+}
+
+func (t *tracedService) ListRoles(ctx context.Context, userID, resourceType, resourceID, folderID string) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "metadata", "metadata.ListRoles")
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListRoles(ctx, userID, resourceType, resourceID, folderID)
+}
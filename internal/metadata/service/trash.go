@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"go.uber.org/zap"
+)
+
+// TrashFile moves a merged file to the trash
+func (m *metadataService) TrashFile(ctx context.Context, fileID string) error {
+	if err := m.db.TrashFile(ctx, fileID); err != nil {
+		if errors.Is(err, db.ErrFileNotMerged) {
+			return ErrFileNotMerged
+		}
+		m.log(ctx).Error("Failed to trash file in database",
+			zap.Error(err),
+			zap.String("fileID", fileID))
+		return errors.New("database update failed")
+	}
+
+	m.invalidateFiles(ctx, fileID)
+	m.log(ctx).Info("File moved to trash",
+		zap.String("fileID", fileID))
+	return nil
+}
+
+// RestoreFile moves a trashed file back to merged, its name must still be free in its folder
+func (m *metadataService) RestoreFile(ctx context.Context, fileID string) error {
+	if err := m.db.RestoreFile(ctx, fileID); err != nil {
+		if errors.Is(err, db.ErrFileNotTrashed) {
+			return ErrFileNotTrashed
+		}
+		if mapped := mapNamespaceError(err); mapped != nil {
+			return mapped
+		}
+		m.log(ctx).Error("Failed to restore file in database",
+			zap.Error(err),
+			zap.String("fileID", fileID))
+		return errors.New("database update failed")
+	}
+
+	m.invalidateFiles(ctx, fileID)
+	m.log(ctx).Info("File restored from trash",
+		zap.String("fileID", fileID))
+	return nil
+}
+
+// ClaimTrashedFiles claims files whose trash retention ran out for purging, safe to call from several replicas
+func (m *metadataService) ClaimTrashedFiles(ctx context.Context, trashedBefore, claimStaleBefore time.Time, limit int) ([]*metadata.FileMetadata, error) {
+	files, err := m.db.ClaimTrashedFiles(ctx, trashedBefore.Unix(), claimStaleBefore.Unix(), limit)
+	if err != nil {
+		m.log(ctx).Error("Failed to claim trashed files",
+			zap.Error(err))
+		return nil, errors.New("database update failed")
+	}
+	m.invalidateFiles(ctx, fileIDs(files)...)
+	return files, nil
+}
+
+// ClaimUserTrash claims every trashed file of the user for purging
+func (m *metadataService) ClaimUserTrash(ctx context.Context, userID string) ([]*metadata.FileMetadata, error) {
+	files, err := m.db.ClaimUserTrash(ctx, userID)
+	if err != nil {
+		m.log(ctx).Error("Failed to claim user trash",
+			zap.Error(err),
+			zap.String("userID", userID))
+		return nil, errors.New("database update failed")
+	}
+	m.invalidateFiles(ctx, fileIDs(files)...)
+	return files, nil
+}
+
+// PurgeFile deletes the file with its chunk and version records, returning the objects left without reference
+func (m *metadataService) PurgeFile(ctx context.Context, fileID string) ([]string, error) {
+	orphans, err := m.db.PurgeFile(ctx, fileID)
+	if err != nil {
+		m.log(ctx).Error("Failed to purge file from database",
+			zap.Error(err),
+			zap.String("fileID", fileID))
+		return nil, errors.New("database update failed")
+	}
+	m.invalidateFiles(ctx, fileID)
+	return orphans, nil
+}
+
+func (m *metadataService) invalidateFiles(ctx context.Context, fileIDs ...string) {
+	for _, fileID := range fileIDs {
+		if err := m.cache.DeleteFileMetadata(ctx, fileID); err != nil {
+			m.log(ctx).Warn("Failed to invalidate cached file metadata",
+				zap.Error(err),
+				zap.String("fileID", fileID))
+		}
+	}
+}
+
+func fileIDs(files []*metadata.FileMetadata) []string {
+	ids := make([]string, len(files))
+	for i, file := range files {
+		ids[i] = file.FileID
+	}
+	return ids
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"go.uber.org/zap"
+)
+
+func (m *metadataService) CreateUser(ctx context.Context, user *metadata.User) error {
+	if err := m.db.InsertUser(ctx, user); err != nil {
+		if errors.Is(err, db.ErrDuplicateUser) {
+			return ErrUserExists
+		}
+		m.log(ctx).Error("Failed to insert user into database",
+			zap.Error(err),
+			zap.String("username", user.Username))
+		return errors.New("database operation failed")
+	}
+
+	m.log(ctx).Info("User created", zap.String("userID", user.UserID), zap.String("username", user.Username))
+	return nil
+}
+
+func (m *metadataService) GetUser(ctx context.Context, userID string) (*metadata.User, error) {
+	user, err := m.db.GetUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		m.log(ctx).Error("Failed to retrieve user from database",
+			zap.Error(err),
+			zap.String("userID", userID))
+		return nil, errors.New("database operation failed")
+	}
+	return user, nil
+}
+
+func (m *metadataService) GetUserByName(ctx context.Context, username string) (*metadata.User, error) {
+	user, err := m.db.GetUserByName(ctx, username)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		m.log(ctx).Error("Failed to retrieve user from database",
+			zap.Error(err),
+			zap.String("username", username))
+		return nil, errors.New("database operation failed")
+	}
+	return user, nil
+}
+
+func (m *metadataService) SetUserRole(ctx context.Context, userID, role string) error {
+	if err := m.db.SetUserRole(ctx, userID, role); err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return ErrUserNotFound
+		}
+		m.log(ctx).Error("Failed to update user role",
+			zap.Error(err),
+			zap.String("userID", userID))
+		return errors.New("database update failed")
+	}
+
+	m.log(ctx).Info("User role updated", zap.String("userID", userID), zap.String("role", role))
+	return nil
+}
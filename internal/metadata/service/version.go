@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/db"
+	"go.uber.org/zap"
+)
+
+func (m *metadataService) ListFileVersions(ctx context.Context, fileID string) ([]*metadata.FileVersion, error) {
+	versions, err := m.db.ListFileVersions(ctx, fileID)
+	if err != nil {
+		m.log(ctx).Error("Failed to list file versions from database",
+			zap.Error(err),
+			zap.String("fileID", fileID))
+		return nil, errors.New("database operation failed")
+	}
+	return versions, nil
+}
+
+func (m *metadataService) GetFileVersion(ctx context.Context, fileID string, version int) (*metadata.FileVersion, error) {
+	fileVersion, err := m.db.GetFileVersion(ctx, fileID, version)
+	if err != nil {
+		if errors.Is(err, db.ErrVersionNotFound) {
+			return nil, ErrVersionNotFound
+		}
+		m.log(ctx).Error("Failed to retrieve file version from database",
+			zap.Error(err),
+			zap.String("fileID", fileID),
+			zap.Int("version", version))
+		return nil, errors.New("database operation failed")
+	}
+	return fileVersion, nil
+}
+
+// RestoreFileVersion makes a previous version current again, returning the file and the objects left
+// without reference after pruning
+func (m *metadataService) RestoreFileVersion(ctx context.Context, fileID string, version int) (*metadata.FileMetadata, []string, error) {
+	file, orphans, err := m.db.RestoreFileVersion(ctx, fileID, version, m.maxVersions, m.defaultQuota)
+	if err != nil {
+		if errors.Is(err, db.ErrQuotaExceeded) {
+			return nil, nil, ErrQuotaExceeded
+		}
+		if errors.Is(err, db.ErrVersionNotFound) {
+			return nil, nil, ErrVersionNotFound
+		}
+		if errors.Is(err, db.ErrFileNotMerged) {
+			return nil, nil, ErrFileNotMerged
+		}
+		m.log(ctx).Error("Failed to restore file version in database",
+			zap.Error(err),
+			zap.String("fileID", fileID),
+			zap.Int("version", version))
+		return nil, nil, errors.New("database update failed")
+	}
+
+	m.invalidateFiles(ctx, fileID)
+	m.log(ctx).Info("File version restored",
+		zap.String("fileID", fileID),
+		zap.Int("restoredVersion", version),
+		zap.Int("version", file.Version))
+	return file, orphans, nil
+}
+
+// GetMaxVersions returns how many previous versions the user keeps per file
+func (m *metadataService) GetMaxVersions(ctx context.Context, userID string) (int, error) {
+	limit, err := m.db.GetMaxVersions(ctx, userID, m.maxVersions)
+	if err != nil {
+		m.log(ctx).Error("Failed to retrieve version policy",
+			zap.Error(err),
+			zap.String("userID", userID))
+		return 0, errors.New("database operation failed")
+	}
+	return limit, nil
+}
+
+func (m *metadataService) SetMaxVersions(ctx context.Context, userID string, maxVersions int) error {
+	if err := m.db.SetMaxVersions(ctx, userID, maxVersions); err != nil {
+		m.log(ctx).Error("Failed to set version policy",
+			zap.Error(err),
+			zap.String("userID", userID))
+		return errors.New("database update failed")
+	}
+	return nil
+}
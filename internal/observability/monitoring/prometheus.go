@@ -0,0 +1,192 @@
+package monitoring
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/roamBo/BoCloudStore/pkg/pool"
+)
+
+const namespace = "bocloudstore"
+
+// Registry holds every metric of the server, it is served by Handler
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	httpRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by route and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time until the HTTP response is complete, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	httpInFlight = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "HTTP requests being served, by route.",
+	}, []string{"method", "route"})
+
+	chunkBytes = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "chunk_bytes_total",
+		Help:      "Bytes of chunks accepted through the server.",
+	})
+	chunkDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "chunk_duration_seconds",
+		Help:      "Time to receive, verify and store a chunk.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"result"})
+	mergeDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "merge_duration_seconds",
+		Help:      "Time to merge the chunks of an upload into the final object.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"result"})
+	mergeBytes = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "merge_bytes_total",
+		Help:      "Bytes of successfully merged files.",
+	})
+
+	cacheRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Cache lookups by result: hit, miss or error.",
+	}, []string{"cache", "result"})
+
+	dbDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "postgres",
+		Name:      "query_duration_seconds",
+		Help:      "Postgres statement latency by statement type.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"operation"})
+	dbErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "postgres",
+		Name:      "query_errors_total",
+		Help:      "Failed Postgres statements by statement type.",
+	}, []string{"operation"})
+
+	poolTasks = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "pool",
+		Name:      "tasks_total",
+		Help:      "Worker pool tasks by outcome: success, error or panic.",
+	}, []string{"outcome"})
+	poolTaskDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "pool",
+		Name:      "task_duration_seconds",
+		Help:      "Time a worker spent on a task.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RequestStarted counts a request to route as in flight until the returned func is called with its status
+func RequestStarted(method, route string) func(status int) {
+	start := time.Now()
+	inFlight := httpInFlight.WithLabelValues(method, route)
+	inFlight.Inc()
+	return func(status int) {
+		inFlight.Dec()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	}
+}
+
+// ObserveChunkUpload records a chunk upload, size counts only when err is nil
+func ObserveChunkUpload(size int64, duration time.Duration, err error) {
+	chunkDuration.WithLabelValues(result(err)).Observe(duration.Seconds())
+	if err == nil {
+		chunkBytes.Add(float64(size))
+	}
+}
+
+// ObserveMerge records the merge of an upload, size counts only when err is nil
+func ObserveMerge(size int64, duration time.Duration, err error) {
+	mergeDuration.WithLabelValues(result(err)).Observe(duration.Seconds())
+	if err == nil {
+		mergeBytes.Add(float64(size))
+	}
+}
+
+// CacheHit, CacheMiss and CacheError count lookups in cache
+func CacheHit(cache string, n int) {
+	cacheRequests.WithLabelValues(cache, "hit").Add(float64(n))
+}
+
+func CacheMiss(cache string, n int) {
+	cacheRequests.WithLabelValues(cache, "miss").Add(float64(n))
+}
+
+func CacheError(cache string, n int) {
+	cacheRequests.WithLabelValues(cache, "error").Add(float64(n))
+}
+
+// ObservePoolTask records a worker pool task, it is the pool.TaskObserver of the server's pool
+func ObservePoolTask(duration time.Duration, outcome pool.TaskOutcome) {
+	poolTasks.WithLabelValues(string(outcome)).Inc()
+	poolTaskDuration.Observe(duration.Seconds())
+}
+
+// RegisterWorkerPool exposes the queue depth and busy workers of p
+func RegisterWorkerPool(p *pool.WorkerPool) {
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "pool",
+		Name:      "queue_depth",
+		Help:      "Tasks waiting for a worker.",
+	}, func() float64 { return float64(p.QueueDepth()) })
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "pool",
+		Name:      "active_workers",
+		Help:      "Workers running a task.",
+	}, func() float64 { return float64(p.ActiveWorkers()) })
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "pool",
+		Name:      "workers",
+		Help:      "Workers of the pool.",
+	}, func() float64 { return float64(p.WorkerCount()) })
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
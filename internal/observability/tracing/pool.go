@@ -0,0 +1,28 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/roamBo/BoCloudStore/pkg/pool"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WrapTask runs task in a span that is a child of the span in ctx, the context it was submitted
+// with. It is the pool.TaskWrapper of the server's pool.
+func WrapTask(ctx context.Context, task pool.Task) pool.Task {
+	parent := trace.SpanContextFromContext(ctx)
+	return func(poolCtx context.Context) error {
+		ctx, span := Start(trace.ContextWithSpanContext(poolCtx, parent), "pool", "pool.task")
+		defer func() {
+			if r := recover(); r != nil {
+				span.SetStatus(codes.Error, "task panicked")
+				span.End()
+				panic(r)
+			}
+		}()
+		err := task(ctx)
+		End(span, err)
+		return err
+	}
+}
@@ -1 +1,112 @@
 package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const instrumentation = "github.com/roamBo/BoCloudStore"
+
+// Option changes how Setup installs the tracer provider
+type Option func(*setupOptions)
+
+type setupOptions struct {
+	exporter sdktrace.SpanExporter //replaces the exporter named by the config
+}
+
+// WithSyncExporter exports every span to exporter as soon as it ends instead of batching them to
+// the exporter named by the config, e.g. an in-memory exporter a test reads right after a request
+func WithSyncExporter(exporter sdktrace.SpanExporter) Option {
+	return func(o *setupOptions) {
+		o.exporter = exporter
+	}
+}
+
+// Setup installs W3C trace context propagation and, when cfg enables tracing, a tracer provider
+// exporting to cfg.Exporter. Without it spans are no-ops. The returned func flushes pending spans
+// and stops the exporter.
+func Setup(ctx context.Context, cfg config.TracingConfig, logger *zap.Logger, opts ...Option) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var o setupOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var export sdktrace.TracerProviderOption
+	if o.exporter != nil {
+		export = sdktrace.WithSyncer(o.exporter)
+	} else {
+		exporter, err := NewExporter(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		export = sdktrace.WithBatcher(exporter)
+	}
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		export,
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("tracing error", zap.Error(err))
+	}))
+
+	logger.Info("Tracing enabled",
+		zap.String("exporter", cfg.Exporter),
+		zap.String("endpoint", cfg.Endpoint),
+		zap.Float64("sampleRatio", cfg.SampleRatio))
+	return provider.Shutdown, nil
+}
+
+// NewExporter creates the span exporter named by cfg.Exporter: otlp or stdout
+func NewExporter(ctx context.Context, cfg config.TracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "otlp", "":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		return stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", cfg.Exporter)
+	}
+}
+
+// Start starts a span named name for component. The tracer is looked up on every call, so spans
+// go to the provider installed by Setup even if the caller was created before.
+func Start(ctx context.Context, component, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation+"/"+component).Start(ctx, name, opts...)
+}
+
+// End marks span as failed when err is not nil and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
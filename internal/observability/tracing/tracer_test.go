@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+// setupMemoryTracing installs a tracer provider recording every span in memory
+func setupMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	cfg := config.TracingConfig{Enabled: true, ServiceName: "test", SampleRatio: 1}
+	shutdown, err := Setup(context.Background(), cfg, zap.NewNop(), WithSyncExporter(exporter))
+	if err != nil {
+		t.Fatalf("setup tracing: %v", err)
+	}
+	t.Cleanup(func() { shutdown(context.Background()) })
+	return exporter
+}
+
+func TestEndRecordsError(t *testing.T) {
+	exporter := setupMemoryTracing(t)
+
+	_, span := Start(context.Background(), "test", "test.ok")
+	End(span, nil)
+	_, span = Start(context.Background(), "test", "test.failed")
+	End(span, errors.New("boom"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].Name != "test.ok" || spans[0].Status.Code != codes.Unset {
+		t.Fatalf("span %q has status %v, want unset", spans[0].Name, spans[0].Status.Code)
+	}
+	if spans[1].Name != "test.failed" || spans[1].Status.Code != codes.Error || spans[1].Status.Description != "boom" {
+		t.Fatalf("span %q has status %v %q, want error boom", spans[1].Name, spans[1].Status.Code, spans[1].Status.Description)
+	}
+}
+
+func TestWrapTaskCarriesTrace(t *testing.T) {
+	exporter := setupMemoryTracing(t)
+
+	ctx, parent := Start(context.Background(), "test", "test.request")
+	task := WrapTask(ctx, func(ctx context.Context) error { return nil })
+	// the pool runs the task with its own context, the trace comes from the submitter
+	if err := task(context.Background()); err != nil {
+		t.Fatalf("task: %v", err)
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	child := spans[0]
+	if child.Name != "pool.task" {
+		t.Fatalf("first span = %q, want pool.task", child.Name)
+	}
+	if child.Parent.SpanID() != parent.SpanContext().SpanID() || child.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+		t.Fatal("pool task span is not a child of the submitting span")
+	}
+}
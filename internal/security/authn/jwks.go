@@ -0,0 +1,340 @@
+package authn
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	maxJWKSSize = 1 << 20
+	// an unknown kid triggers a reload, at most this often, to pick up rotated keys early
+	minReloadInterval = time.Minute
+)
+
+// for error
+var (
+	ErrNoJWKSSource = errors.New("jwks file or url required")
+	ErrKeyNotFound  = errors.New("no matching key in jwks")
+)
+
+// jwk is a JSON Web Key as defined by RFC 7517, only the public members are read
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type publicKey struct {
+	kid string
+	alg string //empty if the jwk does not restrict its algorithm
+	key crypto.PublicKey
+}
+
+// KeySet holds the signing keys of an identity provider, loaded from a JWKS file or url and
+// reloaded every RefreshInterval. The last good keys are kept when a reload fails.
+type KeySet struct {
+	cfg    config.JWKSConfig
+	client *http.Client
+	logger *zap.Logger
+
+	mu         sync.RWMutex
+	keys       []publicKey
+	reloadMu   sync.Mutex
+	lastReload time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewKeySet loads the keys once, failing if none can be loaded
+func NewKeySet(cfg config.JWKSConfig, logger *zap.Logger) (*KeySet, error) {
+	if (cfg.File == "") == (cfg.URL == "") {
+		return nil, ErrNoJWKSSource
+	}
+	k := &KeySet{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+	if err := k.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Start reloads the keys every RefreshInterval until Stop is called
+func (k *KeySet) Start() {
+	k.wg.Add(1)
+	go func() {
+		defer k.wg.Done()
+		ticker := time.NewTicker(k.cfg.RefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-k.stop:
+				return
+			case <-ticker.C:
+				if err := k.Reload(context.Background()); err != nil {
+					k.logger.Warn("failed to reload jwks, keeping previous keys", zap.Error(err))
+				}
+			}
+		}
+	}()
+	k.logger.Info("JWKS refresh started",
+		zap.String("file", k.cfg.File),
+		zap.String("url", k.cfg.URL),
+		zap.Duration("interval", k.cfg.RefreshInterval))
+}
+
+func (k *KeySet) Stop() {
+	close(k.stop)
+	k.wg.Wait()
+}
+
+// Reload replaces the keys with the current content of the source
+func (k *KeySet) Reload(ctx context.Context) error {
+	k.reloadMu.Lock()
+	defer k.reloadMu.Unlock()
+	return k.reload(ctx)
+}
+
+func (k *KeySet) reload(ctx context.Context) error {
+	k.lastReload = time.Now()
+	data, err := k.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := k.parse(data)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	k.logger.Debug("JWKS loaded", zap.Int("keys", len(keys)))
+	return nil
+}
+
+// Keyfunc selects the key for a token by its kid header and algorithm, for jwt.Parse
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	return k.Key(kid, token.Method.Alg())
+}
+
+// Key returns the key with kid usable for alg. Without a kid the only key usable for alg is
+// returned. An unknown kid reloads the keys in case the provider rotated them.
+func (k *KeySet) Key(kid, alg string) (crypto.PublicKey, error) {
+	if key, ok := k.lookup(kid, alg); ok {
+		return key, nil
+	}
+	if kid == "" {
+		return nil, ErrKeyNotFound
+	}
+
+	k.reloadMu.Lock()
+	if time.Since(k.lastReload) >= minReloadInterval {
+		if err := k.reload(context.Background()); err != nil {
+			k.logger.Warn("failed to reload jwks for unknown kid", zap.Error(err), zap.String("kid", kid))
+		}
+	}
+	k.reloadMu.Unlock()
+
+	if key, ok := k.lookup(kid, alg); ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+func (k *KeySet) lookup(kid, alg string) (crypto.PublicKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var found crypto.PublicKey
+	matches := 0
+	for _, key := range k.keys {
+		if kid != "" && key.kid != kid {
+			continue
+		}
+		if !usableFor(key, alg) {
+			continue
+		}
+		found = key.key
+		matches++
+	}
+	return found, matches == 1
+}
+
+func (k *KeySet) fetch(ctx context.Context) ([]byte, error) {
+	if k.cfg.File != "" {
+		data, err := os.ReadFile(k.cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read jwks file: %w", err)
+		}
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.cfg.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jwks request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks: unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwks: %w", err)
+	}
+	return data, nil
+}
+
+// parse reads the signing keys of a JWKS document, skipping keys of unsupported types
+func (k *KeySet) parse(data []byte) ([]publicKey, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse jwks: %w", err)
+	}
+
+	var keys []publicKey
+	for _, raw := range doc.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+		key, err := parseKey(raw)
+		if err != nil {
+			k.logger.Warn("skipping jwk", zap.Error(err), zap.String("kid", raw.Kid), zap.String("kty", raw.Kty))
+			continue
+		}
+		keys = append(keys, publicKey{kid: raw.Kid, alg: raw.Alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks contains no usable signing key")
+	}
+	return keys, nil
+}
+
+func parseKey(raw jwk) (crypto.PublicKey, error) {
+	switch raw.Kty {
+	case "RSA":
+		n, err := decodeBigInt(raw.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(raw.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		return parseECKey(raw)
+	case "OKP":
+		if raw.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", raw.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(raw.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", raw.Kty)
+	}
+}
+
+func parseECKey(raw jwk) (crypto.PublicKey, error) {
+	var curve elliptic.Curve
+	var check ecdh.Curve
+	switch raw.Crv {
+	case "P-256":
+		curve, check = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, check = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, check = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", raw.Crv)
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	x, errX := base64.RawURLEncoding.DecodeString(raw.X)
+	y, errY := base64.RawURLEncoding.DecodeString(raw.Y)
+	if errX != nil || errY != nil || len(x) != size || len(y) != size {
+		return nil, errors.New("invalid ec key coordinates")
+	}
+	// ecdh rejects points that are not on the curve
+	point := append(append([]byte{4}, x...), y...)
+	if _, err := check.NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("invalid ec key: %w", err)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid rsa key")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// usableFor reports whether key can verify signatures made with alg
+func usableFor(key publicKey, alg string) bool {
+	if key.alg != "" && key.alg != alg {
+		return false
+	}
+	switch pub := key.key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		switch alg {
+		case "ES256":
+			return pub.Curve == elliptic.P256()
+		case "ES384":
+			return pub.Curve == elliptic.P384()
+		case "ES512":
+			return pub.Curve == elliptic.P521()
+		}
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package authn
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+)
+
+var (
+	hmacAlgorithms       = []string{"HS256", "HS384", "HS512"}
+	asymmetricAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// for error
+var (
+	ErrAlgorithmNotAllowed = errors.New("token signing algorithm not allowed")
+	ErrNoKeySet            = errors.New("no jwks configured for asymmetric tokens")
+	ErrMissingNotBefore    = errors.New("token has no nbf claim")
+	ErrMissingSubject      = errors.New("token has no sub claim")
+)
+
+// Verifier checks access tokens. HMAC signed tokens are the server's own and verified with the
+// configured secret, asymmetric tokens come from an identity provider and are verified against
+// its JWKS, including the configured iss and aud.
+type Verifier interface {
+	// Verify checks the signature and claims of a token and returns its claims
+	Verify(tokenString string) (*jwt.RegisteredClaims, error)
+}
+
+type verifier struct {
+	algorithms       []string
+	secret           []byte
+	keys             *KeySet
+	local            *jwt.Parser
+	external         *jwt.Parser
+	requireNotBefore bool
+}
+
+// NewVerifier creates a verifier for the algorithms of cfg, keys may be nil when no JWKS is configured
+func NewVerifier(cfg config.JWTConfig, keys *KeySet) (Verifier, error) {
+	var local, external []string
+	for _, alg := range cfg.Algorithms {
+		switch {
+		case slices.Contains(hmacAlgorithms, alg):
+			local = append(local, alg)
+		case slices.Contains(asymmetricAlgorithms, alg):
+			external = append(external, alg)
+		default:
+			return nil, fmt.Errorf("unsupported jwt algorithm %q", alg)
+		}
+	}
+	if len(local) > 0 && cfg.Secret == "" {
+		return nil, errors.New("jwt secret required for hmac algorithms")
+	}
+
+	common := []jwt.ParserOption{jwt.WithLeeway(cfg.Leeway), jwt.WithExpirationRequired()}
+	externalOpts := append(slices.Clone(common), jwt.WithValidMethods(external))
+	if cfg.Issuer != "" {
+		externalOpts = append(externalOpts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		externalOpts = append(externalOpts, jwt.WithAudience(cfg.Audience))
+	}
+	return &verifier{
+		algorithms:       cfg.Algorithms,
+		secret:           []byte(cfg.Secret),
+		keys:             keys,
+		local:            jwt.NewParser(append(slices.Clone(common), jwt.WithValidMethods(local))...),
+		external:         jwt.NewParser(externalOpts...),
+		requireNotBefore: cfg.RequireNotBefore,
+	}, nil
+}
+
+func (v *verifier) Verify(tokenString string) (*jwt.RegisteredClaims, error) {
+	// the header only picks the parser, both restrict the signing method again
+	unverified, _, err := jwt.NewParser().ParseUnverified(tokenString, &jwt.RegisteredClaims{})
+	if err != nil {
+		return nil, err
+	}
+	alg := unverified.Method.Alg()
+	if !slices.Contains(v.algorithms, alg) {
+		return nil, ErrAlgorithmNotAllowed
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	var token *jwt.Token
+	if slices.Contains(hmacAlgorithms, alg) {
+		token, err = v.local.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+			return v.secret, nil
+		})
+	} else {
+		if v.keys == nil {
+			return nil, ErrNoKeySet
+		}
+		token, err = v.external.ParseWithClaims(tokenString, claims, v.keys.Keyfunc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+	if claims.Subject == "" {
+		return nil, ErrMissingSubject
+	}
+	if v.requireNotBefore && claims.NotBefore == nil {
+		return nil, ErrMissingNotBefore
+	}
+	return claims, nil
+}
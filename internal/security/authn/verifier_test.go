@@ -0,0 +1,325 @@
+package authn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/roamBo/BoCloudStore/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "bocloudstore"
+)
+
+var (
+	rsaKeysOnce sync.Once
+	rsaKeys     [2]*rsa.PrivateKey
+)
+
+// testRSAKey returns one of a few rsa keys, generated once as it is slow
+func testRSAKey(t *testing.T, i int) *rsa.PrivateKey {
+	t.Helper()
+	rsaKeysOnce.Do(func() {
+		for n := range rsaKeys {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				panic(err)
+			}
+			rsaKeys[n] = key
+		}
+	})
+	return rsaKeys[i]
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) jwk {
+	return jwk{Kty: "RSA", Kid: kid, Use: "sig", N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) jwk {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return jwk{Kty: "EC", Kid: kid, Crv: key.Curve.Params().Name, X: b64(key.X.FillBytes(make([]byte, size))), Y: b64(key.Y.FillBytes(make([]byte, size)))}
+}
+
+func edJWK(kid string, key ed25519.PrivateKey) jwk {
+	return jwk{Kty: "OKP", Kid: kid, Crv: "Ed25519", X: b64(key.Public().(ed25519.PublicKey))}
+}
+
+func writeJWKS(t *testing.T, path string, keys ...jwk) {
+	t.Helper()
+	data, err := json.Marshal(map[string][]jwk{"keys": keys})
+	if err != nil {
+		t.Fatalf("marshal jwks: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write jwks: %v", err)
+	}
+}
+
+// newKeySet loads a KeySet from a temp JWKS file holding keys, the path is returned for rewrites
+func newKeySet(t *testing.T, keys ...jwk) (*KeySet, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, keys...)
+	keySet, err := NewKeySet(config.JWKSConfig{File: path, RefreshInterval: time.Hour}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("load jwks: %v", err)
+	}
+	return keySet, path
+}
+
+func testConfig(algorithms ...string) config.JWTConfig {
+	return config.JWTConfig{
+		Secret:     "server-secret",
+		Algorithms: algorithms,
+		Issuer:     testIssuer,
+		Audience:   testAudience,
+	}
+}
+
+func newTestVerifier(t *testing.T, cfg config.JWTConfig, keys *KeySet) Verifier {
+	t.Helper()
+	v, err := NewVerifier(cfg, keys)
+	if err != nil {
+		t.Fatalf("create verifier: %v", err)
+	}
+	return v
+}
+
+// validClaims are accepted by a verifier made from testConfig
+func validClaims() jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Subject:   "user-1",
+		Issuer:    testIssuer,
+		Audience:  jwt.ClaimStrings{testAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now.Add(-time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.Claims, key crypto.PrivateKey) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func TestVerifyKidSelection(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySet, _ := newKeySet(t,
+		rsaJWK("rsa-a", testRSAKey(t, 0)),
+		rsaJWK("rsa-b", testRSAKey(t, 1)),
+		ecJWK("ec", ecKey),
+		edJWK("ed", edKey))
+	v := newTestVerifier(t, testConfig("RS256", "ES256", "EdDSA"), keySet)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "first rsa key", token: sign(t, jwt.SigningMethodRS256, "rsa-a", validClaims(), testRSAKey(t, 0))},
+		{name: "second rsa key", token: sign(t, jwt.SigningMethodRS256, "rsa-b", validClaims(), testRSAKey(t, 1))},
+		{name: "ecdsa key", token: sign(t, jwt.SigningMethodES256, "ec", validClaims(), ecKey)},
+		{name: "ed25519 key", token: sign(t, jwt.SigningMethodEdDSA, "ed", validClaims(), edKey)},
+		// the kid picks the key, a signature by another key of the set does not verify
+		{name: "kid of another key", token: sign(t, jwt.SigningMethodRS256, "rsa-a", validClaims(), testRSAKey(t, 1)), wantErr: jwt.ErrTokenSignatureInvalid},
+		// without a kid only an unambiguous key is used
+		{name: "no kid with one key for alg", token: sign(t, jwt.SigningMethodES256, "", validClaims(), ecKey)},
+		{name: "no kid with several keys for alg", token: sign(t, jwt.SigningMethodRS256, "", validClaims(), testRSAKey(t, 0)), wantErr: ErrKeyNotFound},
+		// a kid naming a key of another type is not used
+		{name: "kid of key for another alg", token: sign(t, jwt.SigningMethodRS256, "ec", validClaims(), testRSAKey(t, 0)), wantErr: ErrKeyNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.Verify(tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("verify err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if claims.Subject != "user-1" {
+				t.Fatalf("subject = %q, want user-1", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestVerifyReloadsOnUnknownKid(t *testing.T) {
+	keySet, path := newKeySet(t, rsaJWK("old", testRSAKey(t, 0)))
+	v := newTestVerifier(t, testConfig("RS256"), keySet)
+	// the provider rotates to a new key
+	writeJWKS(t, path, rsaJWK("old", testRSAKey(t, 0)), rsaJWK("new", testRSAKey(t, 1)))
+	token := sign(t, jwt.SigningMethodRS256, "new", validClaims(), testRSAKey(t, 1))
+
+	// reloads are rate limited, the keys were loaded just now
+	if _, err := v.Verify(token); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("verify err = %v, want %v", err, ErrKeyNotFound)
+	}
+	keySet.lastReload = time.Now().Add(-minReloadInterval)
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("verify after rotation: %v", err)
+	}
+
+	// a reload that fails keeps the keys loaded before
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := keySet.Reload(t.Context()); err == nil {
+		t.Fatal("reload of an invalid jwks succeeded")
+	}
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("verify after failed reload: %v", err)
+	}
+}
+
+func TestVerifyRejectsAlgorithms(t *testing.T) {
+	rsaKey := testRSAKey(t, 0)
+	keySet, _ := newKeySet(t, rsaJWK("rsa", rsaKey))
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: mustMarshalPKIX(t, &rsaKey.PublicKey)})
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims())
+	unsigned.Header["kid"] = "rsa"
+	noneToken, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		algorithms []string
+		token      string
+		wantErr    error
+	}{
+		{name: "none", algorithms: []string{"RS256"}, token: noneToken, wantErr: ErrAlgorithmNotAllowed},
+		// the classic key confusion: the public key used as hmac secret
+		{name: "hs256 with rsa public key", algorithms: []string{"RS256"},
+			token: sign(t, jwt.SigningMethodHS256, "rsa", validClaims(), publicPEM), wantErr: ErrAlgorithmNotAllowed},
+		{name: "hs256 allowed but signed with rsa public key", algorithms: []string{"HS256", "RS256"},
+			token: sign(t, jwt.SigningMethodHS256, "rsa", validClaims(), publicPEM), wantErr: jwt.ErrTokenSignatureInvalid},
+		{name: "rs512 not in list", algorithms: []string{"RS256"},
+			token: sign(t, jwt.SigningMethodRS512, "rsa", validClaims(), rsaKey), wantErr: ErrAlgorithmNotAllowed},
+		{name: "rs256 allowed", algorithms: []string{"RS256"},
+			token: sign(t, jwt.SigningMethodRS256, "rsa", validClaims(), rsaKey)},
+		{name: "server token", algorithms: []string{"HS256"},
+			token: sign(t, jwt.SigningMethodHS256, "", validClaims(), []byte("server-secret"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestVerifier(t, testConfig(tt.algorithms...), keySet)
+			_, err := v.Verify(tt.token)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("verify: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verify err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsUnsupportedAlgorithmConfig(t *testing.T) {
+	if _, err := NewVerifier(testConfig("none"), nil); err == nil {
+		t.Fatal("verifier accepted alg none")
+	}
+	if _, err := NewVerifier(config.JWTConfig{Algorithms: []string{"HS256"}}, nil); err == nil {
+		t.Fatal("verifier accepted hmac without a secret")
+	}
+}
+
+func TestVerifyClaims(t *testing.T) {
+	rsaKey := testRSAKey(t, 0)
+	keySet, _ := newKeySet(t, rsaJWK("rsa", rsaKey))
+	now := time.Now()
+
+	tests := []struct {
+		name             string
+		mutate           func(c *jwt.RegisteredClaims)
+		requireNotBefore bool
+		wantErr          error
+	}{
+		{name: "valid", mutate: func(c *jwt.RegisteredClaims) {}},
+		{name: "wrong issuer", mutate: func(c *jwt.RegisteredClaims) { c.Issuer = "https://evil.example.com" }, wantErr: jwt.ErrTokenInvalidIssuer},
+		{name: "missing issuer", mutate: func(c *jwt.RegisteredClaims) { c.Issuer = "" }, wantErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "wrong audience", mutate: func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other"} }, wantErr: jwt.ErrTokenInvalidAudience},
+		{name: "one of several audiences", mutate: func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other", testAudience} }},
+		{name: "not valid yet", mutate: func(c *jwt.RegisteredClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour)) }, wantErr: jwt.ErrTokenNotValidYet},
+		{name: "missing nbf allowed", mutate: func(c *jwt.RegisteredClaims) { c.NotBefore = nil }},
+		{name: "missing nbf required", mutate: func(c *jwt.RegisteredClaims) { c.NotBefore = nil }, requireNotBefore: true, wantErr: ErrMissingNotBefore},
+		{name: "expired", mutate: func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) }, wantErr: jwt.ErrTokenExpired},
+		{name: "missing exp", mutate: func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }, wantErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "missing sub", mutate: func(c *jwt.RegisteredClaims) { c.Subject = "" }, wantErr: ErrMissingSubject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("RS256")
+			cfg.RequireNotBefore = tt.requireNotBefore
+			v := newTestVerifier(t, cfg, keySet)
+			claims := validClaims()
+			tt.mutate(&claims)
+
+			_, err := v.Verify(sign(t, jwt.SigningMethodRS256, "rsa", claims, rsaKey))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("verify: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verify err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func mustMarshalPKIX(t *testing.T, key crypto.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
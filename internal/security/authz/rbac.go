@@ -0,0 +1,219 @@
+package authz
+
+import (
+	"context"
+	"errors"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"go.uber.org/zap"
+)
+
+// roles, owner, editor and viewer are granted per file or folder and inherited by everything below
+// a folder. admin is a role of the user itself and covers all data.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+	RoleAdmin  = metadata.UserRoleAdmin
+)
+
+// actions
+const (
+	ActionRead   = "read"   //list, download, view versions
+	ActionWrite  = "write"  //upload into, create, rename, move, restore versions
+	ActionDelete = "delete" //move to and restore from trash
+	ActionShare  = "share"  //create share links, grant and revoke roles
+)
+
+// resource types
+const (
+	TypeFile   = "file"
+	TypeFolder = "folder"
+)
+
+var rolePermissions = map[string]map[string]bool{
+	RoleOwner:  {ActionRead: true, ActionWrite: true, ActionDelete: true, ActionShare: true},
+	RoleEditor: {ActionRead: true, ActionWrite: true, ActionDelete: true},
+	RoleViewer: {ActionRead: true},
+}
+
+// for error
+var (
+	ErrForbidden   = errors.New("permission denied")
+	ErrInvalidRole = errors.New("invalid role")
+	ErrSelfGrant   = errors.New("the owner cannot be granted a role")
+)
+
+// Subject is who acts
+type Subject struct {
+	UserID string
+}
+
+// Resource is what is acted on. OwnerID is the user whose namespace holds the resource,
+// FolderID is the folder holding a file or the parent of a folder.
+type Resource struct {
+	Type     string
+	ID       string //empty for the owner's root folder
+	OwnerID  string
+	FolderID string
+}
+
+func User(userID string) Subject {
+	return Subject{UserID: userID}
+}
+
+func FileResource(file *metadata.FileMetadata) Resource {
+	return Resource{Type: TypeFile, ID: file.FileID, OwnerID: file.UserID, FolderID: file.FolderID}
+}
+
+func FolderResource(folder *metadata.Folder) Resource {
+	return Resource{Type: TypeFolder, ID: folder.FolderID, OwnerID: folder.UserID, FolderID: folder.ParentID}
+}
+
+// RootFolder is the root of ownerID's namespace, only the owner and admins can access it
+func RootFolder(ownerID string) Resource {
+	return Resource{Type: TypeFolder, OwnerID: ownerID}
+}
+
+type Authorizer interface {
+	// Authorize returns nil if subject may perform action on resource, ErrForbidden otherwise
+	Authorize(ctx context.Context, subject Subject, action string, resource Resource) error
+	IsAdmin(ctx context.Context, userID string) (bool, error)
+	// Resolve loads a file or folder as a resource
+	Resolve(ctx context.Context, resourceType, resourceID string) (Resource, error)
+
+	Grant(ctx context.Context, subject Subject, resource Resource, userID, role string) (*metadata.Grant, error)
+	Revoke(ctx context.Context, subject Subject, resource Resource, userID string) error
+	ListGrants(ctx context.Context, subject Subject, resource Resource) ([]*metadata.Grant, error)
+}
+
+type authorizer struct {
+	metadataSvc service.Service
+	logger      *zap.Logger
+}
+
+func NewAuthorizer(metadataSvc service.Service, logger *zap.Logger) Authorizer {
+	return &authorizer{
+		metadataSvc: metadataSvc,
+		logger:      logger,
+	}
+}
+
+func (a *authorizer) Authorize(ctx context.Context, subject Subject, action string, resource Resource) error {
+	if subject.UserID == "" {
+		return ErrForbidden
+	}
+	if subject.UserID == resource.OwnerID {
+		return nil
+	}
+	admin, err := a.IsAdmin(ctx, subject.UserID)
+	if err != nil {
+		return err
+	}
+	if admin {
+		return nil
+	}
+	if resource.ID == "" {
+		return ErrForbidden
+	}
+
+	// grants on a folder cover the folder itself, on a file the folder holding it
+	folderID := resource.FolderID
+	if resource.Type == TypeFolder {
+		folderID = resource.ID
+	}
+	roles, err := a.metadataSvc.ListRoles(ctx, subject.UserID, resource.Type, resource.ID, folderID)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if rolePermissions[role][action] {
+			return nil
+		}
+	}
+	a.logger.Debug("access denied",
+		zap.String("userID", subject.UserID),
+		zap.String("action", action),
+		zap.String("resourceType", resource.Type),
+		zap.String("resourceID", resource.ID))
+	return ErrForbidden
+}
+
+func (a *authorizer) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	user, err := a.metadataSvc.GetUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return user.Role == RoleAdmin, nil
+}
+
+func (a *authorizer) Resolve(ctx context.Context, resourceType, resourceID string) (Resource, error) {
+	switch resourceType {
+	case TypeFile:
+		file, err := a.metadataSvc.GetFileMetadata(ctx, resourceID)
+		if err != nil {
+			return Resource{}, err
+		}
+		return FileResource(file), nil
+	case TypeFolder:
+		folder, err := a.metadataSvc.GetFolder(ctx, resourceID)
+		if err != nil {
+			return Resource{}, err
+		}
+		return FolderResource(folder), nil
+	}
+	return Resource{}, ErrForbidden
+}
+
+// Grant gives userID role on resource, replacing a role granted to userID there before
+func (a *authorizer) Grant(ctx context.Context, subject Subject, resource Resource, userID, role string) (*metadata.Grant, error) {
+	if _, ok := rolePermissions[role]; !ok {
+		return nil, ErrInvalidRole
+	}
+	if resource.ID == "" {
+		return nil, ErrForbidden
+	}
+	if userID == resource.OwnerID {
+		return nil, ErrSelfGrant
+	}
+	if err := a.Authorize(ctx, subject, ActionShare, resource); err != nil {
+		return nil, err
+	}
+	if _, err := a.metadataSvc.GetUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	grant := &metadata.Grant{
+		ResourceType: resource.Type,
+		ResourceID:   resource.ID,
+		UserID:       userID,
+		Role:         role,
+		GrantedBy:    subject.UserID,
+	}
+	if err := a.metadataSvc.SaveGrant(ctx, grant); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+func (a *authorizer) Revoke(ctx context.Context, subject Subject, resource Resource, userID string) error {
+	if err := a.Authorize(ctx, subject, ActionShare, resource); err != nil {
+		return err
+	}
+	return a.metadataSvc.DeleteGrant(ctx, resource.Type, resource.ID, userID)
+}
+
+// ListGrants lists the roles granted directly on resource, grants inherited from folders above are not included
+func (a *authorizer) ListGrants(ctx context.Context, subject Subject, resource Resource) ([]*metadata.Grant, error) {
+	if err := a.Authorize(ctx, subject, ActionShare, resource); err != nil {
+		return nil, err
+	}
+	if resource.ID == "" {
+		return nil, nil
+	}
+	return a.metadataSvc.ListGrants(ctx, resource.Type, resource.ID)
+}
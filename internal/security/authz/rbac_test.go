@@ -0,0 +1,192 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/roamBo/BoCloudStore/internal/metadata"
+	"github.com/roamBo/BoCloudStore/internal/metadata/service"
+	"go.uber.org/zap"
+)
+
+// fakeMetadata resolves roles like the acl query does: grants on the resource itself and on its
+// folder or any folder above it
+type fakeMetadata struct {
+	service.Service
+
+	users   map[string]*metadata.User
+	folders map[string]*metadata.Folder
+	grants  []*metadata.Grant
+}
+
+func (f *fakeMetadata) GetUser(ctx context.Context, userID string) (*metadata.User, error) {
+	user, ok := f.users[userID]
+	if !ok {
+		return nil, service.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeMetadata) SaveGrant(ctx context.Context, grant *metadata.Grant) error {
+	f.grants = append(f.grants, grant)
+	return nil
+}
+
+func (f *fakeMetadata) ListRoles(ctx context.Context, userID, resourceType, resourceID, folderID string) ([]string, error) {
+	ancestors := make(map[string]bool)
+	for id := folderID; id != ""; id = f.folders[id].ParentID {
+		ancestors[id] = true
+	}
+	var roles []string
+	for _, grant := range f.grants {
+		if grant.UserID != userID {
+			continue
+		}
+		if (grant.ResourceType == resourceType && grant.ResourceID == resourceID) ||
+			(grant.ResourceType == TypeFolder && ancestors[grant.ResourceID]) {
+			roles = append(roles, grant.Role)
+		}
+	}
+	return roles, nil
+}
+
+// alice owns docs/reports/2024/report.pdf and private/secret.txt
+func newTestAuthorizer() (Authorizer, *fakeMetadata) {
+	meta := &fakeMetadata{
+		users: map[string]*metadata.User{
+			"alice": {UserID: "alice", Role: metadata.UserRoleUser},
+			"bob":   {UserID: "bob", Role: metadata.UserRoleUser},
+			"carol": {UserID: "carol", Role: metadata.UserRoleUser},
+			"erin":  {UserID: "erin", Role: metadata.UserRoleUser},
+			"root":  {UserID: "root", Role: metadata.UserRoleAdmin},
+		},
+		folders: map[string]*metadata.Folder{
+			"docs":    {FolderID: "docs", UserID: "alice", ParentID: ""},
+			"reports": {FolderID: "reports", UserID: "alice", ParentID: "docs"},
+			"2024":    {FolderID: "2024", UserID: "alice", ParentID: "reports"},
+			"private": {FolderID: "private", UserID: "alice", ParentID: ""},
+		},
+		grants: []*metadata.Grant{
+			{ResourceType: TypeFolder, ResourceID: "docs", UserID: "bob", Role: RoleEditor},
+			{ResourceType: TypeFolder, ResourceID: "reports", UserID: "carol", Role: RoleViewer},
+			{ResourceType: TypeFile, ResourceID: "report", UserID: "erin", Role: RoleViewer},
+		},
+	}
+	return NewAuthorizer(meta, zap.NewNop()), meta
+}
+
+var (
+	reportFile = FileResource(&metadata.FileMetadata{FileID: "report", UserID: "alice", FolderID: "2024"})
+	secretFile = FileResource(&metadata.FileMetadata{FileID: "secret", UserID: "alice", FolderID: "private"})
+)
+
+func folder(t *testing.T, meta *fakeMetadata, folderID string) Resource {
+	t.Helper()
+	f, ok := meta.folders[folderID]
+	if !ok {
+		t.Fatalf("unknown folder %s", folderID)
+	}
+	return FolderResource(f)
+}
+
+func TestAuthorize(t *testing.T) {
+	authorizer, meta := newTestAuthorizer()
+	tests := []struct {
+		name     string
+		userID   string
+		action   string
+		resource Resource
+		allowed  bool
+	}{
+		{name: "owner shares own file", userID: "alice", action: ActionShare, resource: reportFile, allowed: true},
+		{name: "owner writes own root", userID: "alice", action: ActionWrite, resource: RootFolder("alice"), allowed: true},
+		{name: "anonymous", userID: "", action: ActionRead, resource: reportFile},
+		{name: "unknown user", userID: "mallory", action: ActionRead, resource: reportFile},
+
+		// admin override
+		{name: "admin reads ungranted file", userID: "root", action: ActionRead, resource: secretFile, allowed: true},
+		{name: "admin shares ungranted file", userID: "root", action: ActionShare, resource: secretFile, allowed: true},
+		{name: "admin writes another root", userID: "root", action: ActionWrite, resource: RootFolder("alice"), allowed: true},
+
+		// editor on docs, inherited three levels down
+		{name: "editor reads granted folder", userID: "bob", action: ActionRead, resource: folder(t, meta, "docs"), allowed: true},
+		{name: "editor reads nested file", userID: "bob", action: ActionRead, resource: reportFile, allowed: true},
+		{name: "editor writes nested file", userID: "bob", action: ActionWrite, resource: reportFile, allowed: true},
+		{name: "editor deletes nested file", userID: "bob", action: ActionDelete, resource: reportFile, allowed: true},
+		{name: "editor writes nested folder", userID: "bob", action: ActionWrite, resource: folder(t, meta, "2024"), allowed: true},
+		{name: "editor cannot share", userID: "bob", action: ActionShare, resource: reportFile},
+		{name: "editor outside grant", userID: "bob", action: ActionRead, resource: secretFile},
+		{name: "editor on owner root", userID: "bob", action: ActionRead, resource: RootFolder("alice")},
+
+		// viewer on reports
+		{name: "viewer reads nested file", userID: "carol", action: ActionRead, resource: reportFile, allowed: true},
+		{name: "viewer reads nested folder", userID: "carol", action: ActionRead, resource: folder(t, meta, "2024"), allowed: true},
+		{name: "viewer cannot write", userID: "carol", action: ActionWrite, resource: reportFile},
+		{name: "viewer cannot delete", userID: "carol", action: ActionDelete, resource: reportFile},
+		{name: "viewer cannot share", userID: "carol", action: ActionShare, resource: reportFile},
+		{name: "viewer not inherited upwards", userID: "carol", action: ActionRead, resource: folder(t, meta, "docs")},
+
+		// viewer on a single file
+		{name: "file viewer reads file", userID: "erin", action: ActionRead, resource: reportFile, allowed: true},
+		{name: "file viewer cannot write file", userID: "erin", action: ActionWrite, resource: reportFile},
+		{name: "file grant does not cover folder", userID: "erin", action: ActionRead, resource: folder(t, meta, "2024")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizer.Authorize(context.Background(), User(tt.userID), tt.action, tt.resource)
+			if tt.allowed && err != nil {
+				t.Fatalf("authorize = %v, want allowed", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrForbidden) {
+				t.Fatalf("authorize = %v, want %v", err, ErrForbidden)
+			}
+		})
+	}
+}
+
+func TestGrant(t *testing.T) {
+	tests := []struct {
+		name     string
+		granter  string
+		resource func(meta *fakeMetadata) Resource
+		userID   string
+		role     string
+		wantErr  error
+	}{
+		{name: "owner grants viewer", granter: "alice", resource: func(*fakeMetadata) Resource { return secretFile }, userID: "bob", role: RoleViewer},
+		{name: "admin grants editor", granter: "root", resource: func(*fakeMetadata) Resource { return secretFile }, userID: "carol", role: RoleEditor},
+		{name: "editor cannot grant", granter: "bob", resource: func(*fakeMetadata) Resource { return reportFile }, userID: "carol", role: RoleViewer, wantErr: ErrForbidden},
+		{name: "unknown role", granter: "alice", resource: func(*fakeMetadata) Resource { return reportFile }, userID: "bob", role: RoleAdmin, wantErr: ErrInvalidRole},
+		{name: "owner cannot be granted", granter: "root", resource: func(*fakeMetadata) Resource { return reportFile }, userID: "alice", role: RoleViewer, wantErr: ErrSelfGrant},
+		{name: "root folder cannot be granted", granter: "alice", resource: func(*fakeMetadata) Resource { return RootFolder("alice") }, userID: "bob", role: RoleViewer, wantErr: ErrForbidden},
+		{name: "unknown grantee", granter: "alice", resource: func(*fakeMetadata) Resource { return reportFile }, userID: "mallory", role: RoleViewer, wantErr: service.ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorizer, meta := newTestAuthorizer()
+			before := len(meta.grants)
+			grant, err := authorizer.Grant(context.Background(), User(tt.granter), tt.resource(meta), tt.userID, tt.role)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("grant err = %v, want %v", err, tt.wantErr)
+				}
+				if len(meta.grants) != before {
+					t.Fatal("a rejected grant was saved")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("grant: %v", err)
+			}
+			if grant.UserID != tt.userID || grant.Role != tt.role || grant.GrantedBy != tt.granter {
+				t.Fatalf("grant = %+v", grant)
+			}
+			// the grantee holds the role from now on
+			err = authorizer.Authorize(context.Background(), User(tt.userID), ActionRead, tt.resource(meta))
+			if err != nil {
+				t.Fatalf("grantee cannot read: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package authz
+
+import "slices"
+
+// scopes limit what a credential may do on top of the roles of its user. Session tokens hold
+// every scope, api keys only the ones they were created with.
+const (
+	ScopeFilesRead  = "files:read"  //list and download
+	ScopeFilesWrite = "files:write" //upload, change, delete and share
+	ScopeAdmin      = "admin"       //admin endpoints, the user must be an admin as well
+)
+
+// AllScopes are the scopes of a session token
+var AllScopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeAdmin}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(AllScopes, scope)
+}
+
+func HasScope(scopes []string, scope string) bool {
+	return slices.Contains(scopes, scope)
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tmpDir holds objects being written, they are renamed into place once complete
+const tmpDir = ".tmp"
+
+// FileSystemStore is an ObjectStore keeping every object as a file below root,
+// it lets BoCloudStore run on a single box without MinIO
+type FileSystemStore struct {
+	root string
+}
+
+func NewFileSystemStore(root string) (*FileSystemStore, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(root, tmpDir), 0o755); err != nil {
+		return nil, err
+	}
+	return &FileSystemStore{root: root}, nil
+}
+
+func (s *FileSystemStore) Put(ctx context.Context, key string, r io.Reader, size int64) (*ObjectInfo, error) {
+	objPath, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(objPath), 0o755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.root, tmpDir), "put-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, contextReader{ctx: ctx, r: r})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 && written != size {
+		return nil, fmt.Errorf("short write: expected %d bytes, got %d", size, written)
+	}
+	if err := os.Rename(tmp.Name(), objPath); err != nil {
+		return nil, err
+	}
+	return s.Stat(ctx, key)
+}
+
+func (s *FileSystemStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, *ObjectInfo, error) {
+	objPath, err := s.objectPath(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(objPath)
+	if err != nil {
+		return nil, nil, convertFileError(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if offset < 0 || offset > fi.Size() {
+		f.Close()
+		return nil, nil, ErrInvalidRange
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	var r io.Reader = f
+	if length >= 0 {
+		r = io.LimitReader(f, length)
+	}
+	return readCloser{Reader: r, Closer: f}, fileObjectInfo(key, fi), nil
+}
+
+func (s *FileSystemStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	objPath, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(objPath)
+	if err != nil {
+		return nil, convertFileError(err)
+	}
+	if fi.IsDir() {
+		return nil, ErrObjectNotFound
+	}
+	return fileObjectInfo(key, fi), nil
+}
+
+func (s *FileSystemStore) Delete(ctx context.Context, key string) error {
+	objPath, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(objPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List walks only the directory holding prefix, a directory that does not exist lists nothing
+func (s *FileSystemStore) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	start := s.root
+	if dir := path.Dir(prefix); dir != "." {
+		var err error
+		if start, err = s.objectPath(dir); err != nil {
+			return nil, err
+		}
+	}
+	var objects []*ObjectInfo
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		// objects deleted while walking are simply left out
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if key == tmpDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		objects = append(objects, fileObjectInfo(key, fi))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (s *FileSystemStore) Compose(ctx context.Context, dst string, srcs []string) (*ObjectInfo, error) {
+	return composeByCopy(ctx, s, dst, srcs)
+}
+
+func (s *FileSystemStore) PresignGet(ctx context.Context, key string, expiry time.Duration, downloadName string) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+func (s *FileSystemStore) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+func (s *FileSystemStore) Ping(ctx context.Context) error {
+	_, err := os.Stat(s.root)
+	return err
+}
+
+// objectPath maps key below root, rejecting keys that would escape it
+func (s *FileSystemStore) objectPath(key string) (string, error) {
+	if key == "" || path.Clean(key) != key || path.IsAbs(key) ||
+		key == ".." || strings.HasPrefix(key, "../") || key == tmpDir || strings.HasPrefix(key, tmpDir+"/") {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// fileObjectInfo the etag of a file is derived from its modification time and size, like most web servers do
+func fileObjectInfo(key string, fi fs.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ETag:         fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+		LastModified: fi.ModTime(),
+	}
+}
+
+func convertFileError(err error) error {
+	if os.IsNotExist(err) {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// contextReader stops a copy once ctx is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data         []byte
+	etag         string
+	lastModified time.Time
+}
+
+// MemoryStore is an ObjectStore keeping objects in process memory,
+// meant for tests and local development
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		objects: make(map[string]*memoryObject),
+	}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, r io.Reader, size int64) (*ObjectInfo, error) {
+	if key == "" {
+		return nil, ErrInvalidKey
+	}
+	data, err := io.ReadAll(contextReader{ctx: ctx, r: r})
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return nil, fmt.Errorf("short write: expected %d bytes, got %d", size, len(data))
+	}
+	sum := md5.Sum(data)
+	obj := &memoryObject{
+		data:         data,
+		etag:         hex.EncodeToString(sum[:]),
+		lastModified: time.Now(),
+	}
+
+	s.mu.Lock()
+	s.objects[key] = obj
+	s.mu.Unlock()
+	return obj.info(key), nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, *ObjectInfo, error) {
+	s.mu.RLock()
+	obj, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, nil, ErrObjectNotFound
+	}
+
+	size := int64(len(obj.data))
+	if offset < 0 || offset > size {
+		return nil, nil, ErrInvalidRange
+	}
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	// objects are never modified in place, so the slice can be shared with the reader
+	return io.NopCloser(bytes.NewReader(obj.data[offset:end])), obj.info(key), nil
+}
+
+func (s *MemoryStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	s.mu.RLock()
+	obj, ok := s.objects[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return obj.info(key), nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.objects, key)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	s.mu.RLock()
+	objects := make([]*ObjectInfo, 0)
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, obj.info(key))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+func (s *MemoryStore) Compose(ctx context.Context, dst string, srcs []string) (*ObjectInfo, error) {
+	return composeByCopy(ctx, s, dst, srcs)
+}
+
+func (s *MemoryStore) PresignGet(ctx context.Context, key string, expiry time.Duration, downloadName string) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+func (s *MemoryStore) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (o *memoryObject) info(key string) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		ETag:         o.etag,
+		LastModified: o.lastModified,
+	}
+}
@@ -2,17 +2,31 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
+const (
+	// minio rejects compose sources (except the last one) smaller than 5MiB
+	minComposePartSize = 5 * 1024 * 1024
+	maxComposeSources  = 10000
+	// part size for streams of unknown length, minio would otherwise buffer parts sized for a 5TiB object
+	streamPartSize = 16 * 1024 * 1024
+)
+
 type MinioConfig struct {
 	Endpoint  string
 	AccessKey string
 	SecretKey string
 	UseSSL    bool
+	Bucket    string
 }
 
 func NewMinioClient(cfg MinioConfig) (*minio.Client, error) {
@@ -23,18 +37,220 @@ func NewMinioClient(cfg MinioConfig) (*minio.Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	bucketName := "cloudstor"
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	exists, err := client.BucketExists(ctx, bucketName)
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
 	if err != nil {
 		return nil, err
 	}
 	if !exists {
-		if err := client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{}); err != nil {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{}); err != nil {
 			return nil, err
 		}
 	}
 	return client, nil
 }
+
+// MinioStore is an ObjectStore backed by a single MinIO (or any S3 compatible) bucket
+type MinioStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewMinioStore(client *minio.Client, bucket string) *MinioStore {
+	return &MinioStore{
+		client: client,
+		bucket: bucket,
+	}
+}
+
+func (s *MinioStore) Put(ctx context.Context, key string, r io.Reader, size int64) (*ObjectInfo, error) {
+	opts := minio.PutObjectOptions{}
+	if size < 0 {
+		opts.PartSize = streamPartSize
+	}
+	info, err := s.client.PutObject(ctx, s.bucket, key, r, size, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (s *MinioStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, *ObjectInfo, error) {
+	opts := minio.GetObjectOptions{}
+	if offset < 0 {
+		return nil, nil, ErrInvalidRange
+	}
+	if length == 0 {
+		info, err := s.Stat(ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return http.NoBody, info, nil
+	}
+	if length > 0 {
+		if err := opts.SetRange(offset, offset+length-1); err != nil {
+			return nil, nil, ErrInvalidRange
+		}
+	} else if offset > 0 {
+		if err := opts.SetRange(offset, 0); err != nil {
+			return nil, nil, ErrInvalidRange
+		}
+	}
+
+	obj, err := s.client.GetObject(ctx, s.bucket, key, opts)
+	if err != nil {
+		return nil, nil, convertMinioError(err)
+	}
+	objInfo, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, nil, convertMinioError(err)
+	}
+	return obj, toObjectInfo(objInfo), nil
+}
+
+func (s *MinioStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	objInfo, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, convertMinioError(err)
+	}
+	return toObjectInfo(objInfo), nil
+}
+
+func (s *MinioStore) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *MinioStore) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	var objects []*ObjectInfo
+	for objInfo := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if objInfo.Err != nil {
+			return nil, objInfo.Err
+		}
+		objects = append(objects, toObjectInfo(objInfo))
+	}
+	return objects, nil
+}
+
+// Compose lets minio concatenate the sources server-side without moving the bytes through us,
+// sources too small for a server-side compose are streamed into dst instead
+func (s *MinioStore) Compose(ctx context.Context, dst string, srcs []string) (*ObjectInfo, error) {
+	sources := make([]*ObjectInfo, len(srcs))
+	var totalSize int64
+	for i, src := range srcs {
+		info, err := s.Stat(ctx, src)
+		if err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				return nil, ErrComposeSourceMissing
+			}
+			return nil, err
+		}
+		sources[i] = info
+		totalSize += info.Size
+	}
+	if !canCompose(sources) {
+		return s.copySources(ctx, dst, srcs, totalSize)
+	}
+
+	copySrcs := make([]minio.CopySrcOptions, len(srcs))
+	for i, src := range srcs {
+		copySrcs[i] = minio.CopySrcOptions{Bucket: s.bucket, Object: src}
+	}
+	info, err := s.client.ComposeObject(ctx, minio.CopyDestOptions{Bucket: s.bucket, Object: dst}, copySrcs...)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (s *MinioStore) copySources(ctx context.Context, dst string, srcs []string, totalSize int64) (*ObjectInfo, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		for _, src := range srcs {
+			obj, err := s.client.GetObject(ctx, s.bucket, src, minio.GetObjectOptions{})
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(pw, obj)
+			obj.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	info, err := s.Put(ctx, dst, pr, totalSize)
+	pr.CloseWithError(err)
+	return info, err
+}
+
+func (s *MinioStore) PresignGet(ctx context.Context, key string, expiry time.Duration, downloadName string) (string, error) {
+	reqParams := url.Values{}
+	if downloadName != "" {
+		reqParams.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": downloadName}))
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, reqParams)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *MinioStore) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedPutObject(ctx, s.bucket, key, expiry)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *MinioStore) Ping(ctx context.Context) error {
+	_, err := s.client.BucketExists(ctx, s.bucket)
+	return err
+}
+
+func canCompose(sources []*ObjectInfo) bool {
+	if len(sources) == 0 || len(sources) > maxComposeSources {
+		return false
+	}
+	for _, src := range sources[:len(sources)-1] {
+		if src.Size < minComposePartSize {
+			return false
+		}
+	}
+	return true
+}
+
+func toObjectInfo(objInfo minio.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          objInfo.Key,
+		Size:         objInfo.Size,
+		ETag:         objInfo.ETag,
+		LastModified: objInfo.LastModified,
+	}
+}
+
+func convertMinioError(err error) error {
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound {
+		return ErrObjectNotFound
+	}
+	if resp.Code == "InvalidRange" || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return ErrInvalidRange
+	}
+	return err
+}
@@ -1 +1,72 @@
 package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// for error
+var (
+	ErrObjectNotFound       = errors.New("object not found")
+	ErrInvalidKey           = errors.New("invalid object key")
+	ErrPresignNotSupported  = errors.New("presigned urls are not supported by this store")
+	ErrInvalidRange         = errors.New("invalid object range")
+	ErrComposeSourceMissing = errors.New("compose source not found")
+)
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// ObjectStore is the object storage engine used by the business layer,
+// keys are slash separated paths such as {userID}/{fileID}/chunk_{n}
+type ObjectStore interface {
+	// Put stores r under key, size is -1 when unknown
+	Put(ctx context.Context, key string, r io.Reader, size int64) (*ObjectInfo, error)
+	// Get reads length bytes starting at offset, length < 0 reads to the end of the object
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, *ObjectInfo, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Delete removes key, deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]*ObjectInfo, error)
+	// Compose concatenates srcs in order into dst
+	Compose(ctx context.Context, dst string, srcs []string) (*ObjectInfo, error)
+	// PresignGet returns a url to read key without credentials, a non empty downloadName
+	// is sent back as the Content-Disposition file name
+	PresignGet(ctx context.Context, key string, expiry time.Duration, downloadName string) (string, error)
+	PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// Ping checks that the backend is reachable
+	Ping(ctx context.Context) error
+}
+
+// composeByCopy streams srcs one after another into dst, for backends without a native compose
+func composeByCopy(ctx context.Context, store ObjectStore, dst string, srcs []string) (*ObjectInfo, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		for _, src := range srcs {
+			r, _, err := store.Get(ctx, src, 0, -1)
+			if err != nil {
+				if errors.Is(err, ErrObjectNotFound) {
+					err = ErrComposeSourceMissing
+				}
+				pw.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(pw, r)
+			r.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	info, err := store.Put(ctx, dst, pr, -1)
+	pr.CloseWithError(err)
+	return info, err
+}
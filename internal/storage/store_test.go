@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testStores runs every test against the stores that need no service running
+func testStores(t *testing.T) map[string]ObjectStore {
+	t.Helper()
+	fsStore, err := NewFileSystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("create filesystem store: %v", err)
+	}
+	return map[string]ObjectStore{
+		"filesystem": fsStore,
+		"memory":     NewMemoryStore(),
+	}
+}
+
+func putString(t *testing.T, store ObjectStore, key, content string) *ObjectInfo {
+	t.Helper()
+	info, err := store.Put(context.Background(), key, strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("put %s: %v", key, err)
+	}
+	return info
+}
+
+func readString(t *testing.T, r io.ReadCloser) string {
+	t.Helper()
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(data)
+}
+
+func TestGetRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		offset  int64
+		length  int64
+		want    string
+		wantErr error
+	}{
+		{name: "whole object", offset: 0, length: -1, want: "0123456789"},
+		{name: "from offset to end", offset: 4, length: -1, want: "456789"},
+		{name: "inner range", offset: 2, length: 3, want: "234"},
+		{name: "length past end", offset: 8, length: 10, want: "89"},
+		{name: "empty at end", offset: 10, length: -1, want: ""},
+		{name: "offset past end", offset: 11, length: -1, wantErr: ErrInvalidRange},
+		{name: "negative offset", offset: -1, length: -1, wantErr: ErrInvalidRange},
+	}
+	for name, store := range testStores(t) {
+		putString(t, store, "u1/f1/object", "0123456789")
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				r, info, err := store.Get(context.Background(), "u1/f1/object", tt.offset, tt.length)
+				if tt.wantErr != nil {
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("get err = %v, want %v", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("get: %v", err)
+				}
+				if got := readString(t, r); got != tt.want {
+					t.Fatalf("got %q, want %q", got, tt.want)
+				}
+				// the info describes the whole object, not the range
+				if info.Size != 10 {
+					t.Fatalf("info size = %d, want 10", info.Size)
+				}
+			})
+		}
+		t.Run(name+"/missing", func(t *testing.T) {
+			if _, _, err := store.Get(context.Background(), "u1/f1/missing", 0, -1); !errors.Is(err, ErrObjectNotFound) {
+				t.Fatalf("get err = %v, want %v", err, ErrObjectNotFound)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	keys := []string{"u1/f1/chunk_0", "u1/f1/chunk_1", "u1/f1/object_a", "u1/f2/chunk_0", "u10/f1/chunk_0", "u2/f1/chunk_0"}
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "u1/f1/chunk_", want: []string{"u1/f1/chunk_0", "u1/f1/chunk_1"}},
+		{prefix: "u1/f1/", want: []string{"u1/f1/chunk_0", "u1/f1/chunk_1", "u1/f1/object_a"}},
+		{prefix: "u1/", want: []string{"u1/f1/chunk_0", "u1/f1/chunk_1", "u1/f1/object_a", "u1/f2/chunk_0"}},
+		// a prefix ending inside a path segment matches every key continuing it
+		{prefix: "u1", want: []string{"u1/f1/chunk_0", "u1/f1/chunk_1", "u1/f1/object_a", "u1/f2/chunk_0", "u10/f1/chunk_0"}},
+		{prefix: "u1/f", want: []string{"u1/f1/chunk_0", "u1/f1/chunk_1", "u1/f1/object_a", "u1/f2/chunk_0"}},
+		{prefix: "", want: keys},
+		// the directory of a prefix may not exist at all
+		{prefix: "u9/f1/chunk_", want: nil},
+		{prefix: "u1/f1/chunk_0/", want: nil},
+	}
+	for name, store := range testStores(t) {
+		for _, key := range keys {
+			putString(t, store, key, "data")
+		}
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.prefix, func(t *testing.T) {
+				objects, err := store.List(context.Background(), tt.prefix)
+				if err != nil {
+					t.Fatalf("list: %v", err)
+				}
+				var got []string
+				for _, object := range objects {
+					got = append(got, object.Key)
+				}
+				sort.Strings(got)
+				if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+					t.Fatalf("list %q = %v, want %v", tt.prefix, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestCompose(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			putString(t, store, "u1/f1/chunk_0", "hello, ")
+			putString(t, store, "u1/f1/chunk_1", "")
+			putString(t, store, "u1/f1/chunk_2", "world")
+
+			info, err := store.Compose(context.Background(), "u1/f1/object", []string{"u1/f1/chunk_0", "u1/f1/chunk_1", "u1/f1/chunk_2"})
+			if err != nil {
+				t.Fatalf("compose: %v", err)
+			}
+			if info.Size != int64(len("hello, world")) {
+				t.Fatalf("composed size = %d, want %d", info.Size, len("hello, world"))
+			}
+			r, _, err := store.Get(context.Background(), "u1/f1/object", 0, -1)
+			if err != nil {
+				t.Fatalf("get composed: %v", err)
+			}
+			if got := readString(t, r); got != "hello, world" {
+				t.Fatalf("composed content = %q", got)
+			}
+
+			_, err = store.Compose(context.Background(), "u1/f1/other", []string{"u1/f1/chunk_0", "u1/f1/missing"})
+			if !errors.Is(err, ErrComposeSourceMissing) {
+				t.Fatalf("compose err = %v, want %v", err, ErrComposeSourceMissing)
+			}
+			if _, err := store.Stat(context.Background(), "u1/f1/other"); !errors.Is(err, ErrObjectNotFound) {
+				t.Fatalf("failed compose left an object behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestStatETag(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			put := putString(t, store, "u1/f1/object", "first")
+			if put.ETag == "" {
+				t.Fatal("put returned no etag")
+			}
+			stat, err := store.Stat(context.Background(), "u1/f1/object")
+			if err != nil {
+				t.Fatalf("stat: %v", err)
+			}
+			if stat.ETag != put.ETag || stat.Size != 5 {
+				t.Fatalf("stat = %+v, want etag %s and size 5", stat, put.ETag)
+			}
+			r, got, err := store.Get(context.Background(), "u1/f1/object", 1, 2)
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			r.Close()
+			if got.ETag != put.ETag {
+				t.Fatalf("get etag = %s, want %s", got.ETag, put.ETag)
+			}
+
+			replaced := putString(t, store, "u1/f1/object", "second content")
+			if replaced.ETag == put.ETag {
+				t.Fatal("etag did not change with the content")
+			}
+
+			if err := store.Delete(context.Background(), "u1/f1/object"); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			if _, err := store.Stat(context.Background(), "u1/f1/object"); !errors.Is(err, ErrObjectNotFound) {
+				t.Fatalf("stat after delete err = %v, want %v", err, ErrObjectNotFound)
+			}
+			// deleting a missing object is not an error
+			if err := store.Delete(context.Background(), "u1/f1/object"); err != nil {
+				t.Fatalf("delete missing: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileSystemStoreKeys(t *testing.T) {
+	store, err := NewFileSystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("create filesystem store: %v", err)
+	}
+	for _, key := range []string{"", "../escape", "/abs", "u1/../../escape", "u1//f1", ".tmp/put-1", "u1/f1/"} {
+		if _, err := store.Put(context.Background(), key, strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("put %q err = %v, want %v", key, err, ErrInvalidKey)
+		}
+	}
+	if _, err := store.List(context.Background(), "../"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("list outside the root err = %v, want %v", err, ErrInvalidKey)
+	}
+	// objects being written are not listed
+	putString(t, store, "u1/f1/object", "data")
+	objects, err := store.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Key != "u1/f1/object" {
+		t.Fatalf("list = %v, want only u1/f1/object", objects)
+	}
+}
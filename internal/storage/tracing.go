@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/roamBo/BoCloudStore/internal/observability/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedStore runs every call of an object store in a client span. Get only covers opening the
+// object, the body is read after the span ended.
+type tracedStore struct {
+	next    ObjectStore
+	backend string
+}
+
+// NewTracedStore wraps store so its calls are traced, backend names the store in the spans
+func NewTracedStore(store ObjectStore, backend string) ObjectStore {
+	return &tracedStore{next: store, backend: backend}
+}
+
+func (t *tracedStore) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("storage.backend", t.backend))
+	return tracing.Start(ctx, "storage", "storage."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, a missing object is an answer rather than a failure
+func endSpan(span trace.Span, err error) {
+	if errors.Is(err, ErrObjectNotFound) {
+		span.SetAttributes(attribute.Bool("storage.not_found", true))
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+func (t *tracedStore) Put(ctx context.Context, key string, r io.Reader, size int64) (_ *ObjectInfo, err error) {
+	ctx, span := t.start(ctx, "Put", attribute.String("storage.key", key), attribute.Int64("storage.size", size))
+	defer func() { endSpan(span, err) }()
+	return t.next.Put(ctx, key, r, size)
+}
+
+func (t *tracedStore) Get(ctx context.Context, key string, offset, length int64) (_ io.ReadCloser, _ *ObjectInfo, err error) {
+	ctx, span := t.start(ctx, "Get", attribute.String("storage.key", key),
+		attribute.Int64("storage.offset", offset), attribute.Int64("storage.length", length))
+	defer func() { endSpan(span, err) }()
+	return t.next.Get(ctx, key, offset, length)
+}
+
+func (t *tracedStore) Stat(ctx context.Context, key string) (_ *ObjectInfo, err error) {
+	ctx, span := t.start(ctx, "Stat", attribute.String("storage.key", key))
+	defer func() { endSpan(span, err) }()
+	return t.next.Stat(ctx, key)
+}
+
+func (t *tracedStore) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := t.start(ctx, "Delete", attribute.String("storage.key", key))
+	defer func() { endSpan(span, err) }()
+	return t.next.Delete(ctx, key)
+}
+
+func (t *tracedStore) List(ctx context.Context, prefix string) (_ []*ObjectInfo, err error) {
+	ctx, span := t.start(ctx, "List", attribute.String("storage.prefix", prefix))
+	defer func() { endSpan(span, err) }()
+	return t.next.List(ctx, prefix)
+}
+
+func (t *tracedStore) Compose(ctx context.Context, dst string, srcs []string) (_ *ObjectInfo, err error) {
+	ctx, span := t.start(ctx, "Compose", attribute.String("storage.key", dst), attribute.Int("storage.sources", len(srcs)))
+	defer func() { endSpan(span, err) }()
+	return t.next.Compose(ctx, dst, srcs)
+}
+
+func (t *tracedStore) PresignGet(ctx context.Context, key string, expiry time.Duration, downloadName string) (_ string, err error) {
+	ctx, span := t.start(ctx, "PresignGet", attribute.String("storage.key", key))
+	defer func() { endSpan(span, err) }()
+	return t.next.PresignGet(ctx, key, expiry, downloadName)
+}
+
+func (t *tracedStore) PresignPut(ctx context.Context, key string, expiry time.Duration) (_ string, err error) {
+	ctx, span := t.start(ctx, "PresignPut", attribute.String("storage.key", key))
+	defer func() { endSpan(span, err) }()
+	return t.next.PresignPut(ctx, key, expiry)
+}
+
+func (t *tracedStore) Ping(ctx context.Context) (err error) {
+	ctx, span := t.start(ctx, "Ping")
+	defer func() { endSpan(span, err) }()
+	return t.next.Ping(ctx)
+}
@@ -7,11 +7,29 @@ import (
 	"go.uber.org/zap"
 
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Task func(ctx context.Context) error
 
+// TaskOutcome is how a task ended
+type TaskOutcome string
+
+const (
+	TaskSucceeded TaskOutcome = "success"
+	TaskFailed    TaskOutcome = "error"
+	TaskPanicked  TaskOutcome = "panic"
+)
+
+// TaskObserver is called after every task, e.g. to record metrics
+type TaskObserver func(duration time.Duration, outcome TaskOutcome)
+
+// TaskWrapper wraps every task when it is submitted, ctx is the context of the submitter
+// (Background for Submit) so values like the trace can be carried into the task. Wrappers
+// are applied in the order they were added.
+type TaskWrapper func(ctx context.Context, task Task) Task
+
 type WorkerPool struct {
 	workerCount int       //goroutines number
 	queueSize   int       //task queue's size
@@ -20,6 +38,11 @@ type WorkerPool struct {
 	cancel      context.CancelFunc
 	wg          sync.WaitGroup
 	logger      *zap.Logger
+	observer    TaskObserver
+	wrappers    []TaskWrapper
+	active      atomic.Int64 //workers running a task
+	mu          sync.RWMutex //guards closed against sends racing with Shutdown
+	closed      bool
 }
 
 type Option func(*WorkerPool)
@@ -40,6 +63,18 @@ func WithQueueSize(size int) Option {
 	}
 }
 
+func WithTaskObserver(observer TaskObserver) Option {
+	return func(wp *WorkerPool) {
+		wp.observer = observer
+	}
+}
+
+func WithTaskWrapper(wrapper TaskWrapper) Option {
+	return func(wp *WorkerPool) {
+		wp.wrappers = append(wp.wrappers, wrapper)
+	}
+}
+
 func NewWorkerPool(logger *zap.Logger, opts ...Option) *WorkerPool {
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -93,17 +128,25 @@ func (p *WorkerPool) workerLoop(workerID int) {
 
 func (p *WorkerPool) executeTask(workerID int, task Task) {
 	startTime := time.Now()
+	outcome := TaskSucceeded
+	p.active.Add(1)
 	defer func() {
 		if r := recover(); r != nil {
+			outcome = TaskPanicked
 			p.logger.Error("Worker task panic",
 				zap.Int("workerId", workerID),
 				zap.Any("panic", r),
 				zap.Stack("stack"),
 				zap.Duration("time", time.Since(startTime)))
 		}
+		p.active.Add(-1)
+		if p.observer != nil {
+			p.observer(time.Since(startTime), outcome)
+		}
 	}()
 
 	if err := task(p.ctx); err != nil {
+		outcome = TaskFailed
 		p.logger.Warn("Worker task execution failed",
 			zap.Int("workerId", workerID),
 			zap.Error(err),
@@ -119,9 +162,13 @@ func (p *WorkerPool) executeTask(workerID int, task Task) {
 }
 
 func (p *WorkerPool) Submit(task Task) error {
+	task = p.wrap(context.Background(), task)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
 	select {
-	case p.taskQueue <- task:
-		return p.ctx.Err()
 	case p.taskQueue <- task:
 		return nil
 	default:
@@ -129,16 +176,68 @@ func (p *WorkerPool) Submit(task Task) error {
 	}
 }
 
+// SubmitWait blocks until the task is queued, ctx is done or the pool is shut down
+func (p *WorkerPool) SubmitWait(ctx context.Context, task Task) error {
+	task = p.wrap(ctx, task)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.taskQueue <- task:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.ctx.Done():
+		return ErrPoolClosed
+	}
+}
+
+func (p *WorkerPool) wrap(ctx context.Context, task Task) Task {
+	for _, wrapper := range p.wrappers {
+		task = wrapper(ctx, task)
+	}
+	return task
+}
+
+// QueueDepth is the number of tasks waiting for a worker
+func (p *WorkerPool) QueueDepth() int {
+	return len(p.taskQueue)
+}
+
+// ActiveWorkers is the number of workers running a task
+func (p *WorkerPool) ActiveWorkers() int {
+	return int(p.active.Load())
+}
+
+func (p *WorkerPool) WorkerCount() int {
+	return p.workerCount
+}
+
+// Done is closed once the pool is shut down, tasks still queued by then are never run
+func (p *WorkerPool) Done() <-chan struct{} {
+	return p.ctx.Done()
+}
+
+// Shutdown stops the workers, submissions from then on fail with ErrPoolClosed.
+// Cancelling first releases a SubmitWait blocked on a full queue before the queue is closed.
 func (p *WorkerPool) Shutdown() {
-	close(p.taskQueue)
 	p.cancel()
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.taskQueue)
+	}
+	p.mu.Unlock()
 	p.wg.Wait()
 	p.logger.Info("Worker pool shutdown completed")
 }
 
 // for error
 var (
-	ErrQueueFull = errorf("task queue is full")
+	ErrQueueFull  = errorf("task queue is full")
+	ErrPoolClosed = errorf("worker pool is closed")
 )
 
 func errorf(format string, v ...interface{}) error {
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Limit is a token bucket refilled with Rate tokens per second up to Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int64
+}
+
+// PerSecond is a limit of rate tokens per second, a burst below 1 is one second worth of tokens
+func PerSecond(rate float64, burst int64) Limit {
+	if burst < 1 {
+		burst = max(int64(math.Ceil(rate)), 1)
+	}
+	return Limit{Rate: rate, Burst: burst}
+}
+
+// Unlimited reports whether the limit lets everything through
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// idle is how long an unused bucket takes to refill completely
+func (l Limit) idle() time.Duration {
+	return time.Duration(math.Ceil(float64(l.Burst) / l.Rate * float64(time.Second)))
+}
+
+// Limiter keeps token buckets by key
+type Limiter interface {
+	// Take removes n tokens from the bucket of key. If the bucket holds fewer, nothing is taken and
+	// the time until it holds n is returned. n larger than the burst is never granted.
+	Take(ctx context.Context, key string, limit Limit, n int64) (time.Duration, error)
+}
+
+// Wait takes n tokens, blocking until they are available. n is taken in pieces of at most the burst.
+func Wait(ctx context.Context, limiter Limiter, key string, limit Limit, n int64) error {
+	if limit.Unlimited() {
+		return nil
+	}
+	for n > 0 {
+		piece := min(n, limit.Burst)
+		wait, err := limiter.Take(ctx, key, limit, piece)
+		if err != nil {
+			return err
+		}
+		if wait == 0 {
+			n -= piece
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+type fallbackLimiter struct {
+	primary  Limiter
+	fallback Limiter
+	logger   *zap.Logger
+	failing  atomic.Bool
+}
+
+// NewFallbackLimiter uses primary and switches to fallback while primary fails. The buckets of
+// fallback are local to the process, so limits only hold per replica until primary is back.
+func NewFallbackLimiter(primary, fallback Limiter, logger *zap.Logger) Limiter {
+	return &fallbackLimiter{
+		primary:  primary,
+		fallback: fallback,
+		logger:   logger,
+	}
+}
+
+func (f *fallbackLimiter) Take(ctx context.Context, key string, limit Limit, n int64) (time.Duration, error) {
+	wait, err := f.primary.Take(ctx, key, limit, n)
+	if err == nil {
+		if f.failing.CompareAndSwap(true, false) {
+			f.logger.Info("rate limiter recovered")
+		}
+		return wait, nil
+	}
+	if ctx.Err() != nil {
+		return 0, err
+	}
+	if f.failing.CompareAndSwap(false, true) {
+		f.logger.Warn("rate limiter unavailable, using in-process buckets", zap.Error(err))
+	}
+	return f.fallback.Take(ctx, key, limit, n)
+}
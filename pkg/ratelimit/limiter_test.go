@@ -0,0 +1,261 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeClock is a clock that only moves when told to
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestPerSecond(t *testing.T) {
+	tests := []struct {
+		rate  float64
+		burst int64
+		want  Limit
+	}{
+		{rate: 10, burst: 20, want: Limit{Rate: 10, Burst: 20}},
+		{rate: 10, burst: 0, want: Limit{Rate: 10, Burst: 10}},
+		{rate: 2.5, burst: 0, want: Limit{Rate: 2.5, Burst: 3}},
+		{rate: 0.1, burst: 0, want: Limit{Rate: 0.1, Burst: 1}},
+	}
+	for _, tt := range tests {
+		if got := PerSecond(tt.rate, tt.burst); got != tt.want {
+			t.Errorf("PerSecond(%v, %d) = %+v, want %+v", tt.rate, tt.burst, got, tt.want)
+		}
+	}
+	if !PerSecond(0, 5).Unlimited() {
+		t.Error("a zero rate is not unlimited")
+	}
+}
+
+func TestMemoryLimiterTake(t *testing.T) {
+	limit := Limit{Rate: 10, Burst: 5}
+	// each step takes n tokens after advancing the clock by elapsed
+	steps := []struct {
+		name     string
+		elapsed  time.Duration
+		n        int64
+		wantWait time.Duration
+	}{
+		{name: "new bucket starts full", n: 5},
+		{name: "empty bucket", n: 1, wantWait: 100 * time.Millisecond},
+		{name: "refused take keeps tokens", n: 2, wantWait: 200 * time.Millisecond},
+		{name: "partly refilled", elapsed: 150 * time.Millisecond, n: 2, wantWait: 50 * time.Millisecond},
+		{name: "refilled enough", elapsed: 50 * time.Millisecond, n: 2},
+		{name: "refill stops at burst", elapsed: time.Hour, n: 5},
+		{name: "more than the burst is never granted", elapsed: time.Hour, n: 6, wantWait: 100 * time.Millisecond},
+		{name: "burst still there after refused take", n: 5},
+	}
+	clock := newFakeClock()
+	limiter := NewMemoryLimiter(WithClock(clock.Now))
+	for _, step := range steps {
+		clock.Advance(step.elapsed)
+		wait, err := limiter.Take(context.Background(), "key", limit, step.n)
+		if err != nil {
+			t.Fatalf("%s: take: %v", step.name, err)
+		}
+		if wait != step.wantWait {
+			t.Fatalf("%s: wait = %v, want %v", step.name, wait, step.wantWait)
+		}
+	}
+}
+
+func TestMemoryLimiterKeys(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewMemoryLimiter(WithClock(clock.Now))
+	limit := Limit{Rate: 1, Burst: 1}
+	ctx := context.Background()
+
+	if wait, _ := limiter.Take(ctx, "a", limit, 1); wait != 0 {
+		t.Fatalf("first take of a waits %v", wait)
+	}
+	if wait, _ := limiter.Take(ctx, "a", limit, 1); wait != time.Second {
+		t.Fatalf("second take of a waits %v, want 1s", wait)
+	}
+	if wait, _ := limiter.Take(ctx, "b", limit, 1); wait != 0 {
+		t.Fatalf("take of b waits %v, buckets are per key", wait)
+	}
+	if wait, _ := limiter.Take(ctx, "c", Limit{}, 1000); wait != 0 {
+		t.Fatalf("unlimited take waits %v", wait)
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewMemoryLimiter(WithClock(clock.Now)).(*memoryLimiter)
+	ctx := context.Background()
+
+	limiter.Take(ctx, "slow", Limit{Rate: 0.001, Burst: 1}, 1)
+	limiter.Take(ctx, "fast", Limit{Rate: 100, Burst: 1}, 1)
+	clock.Advance(sweepInterval)
+	limiter.Take(ctx, "other", Limit{Rate: 1, Burst: 1}, 1)
+
+	// fast refilled long ago and is dropped, slow is still refilling
+	if _, ok := limiter.buckets["fast"]; ok {
+		t.Error("full bucket was not swept")
+	}
+	if _, ok := limiter.buckets["slow"]; !ok {
+		t.Error("refilling bucket was swept")
+	}
+	if wait, _ := limiter.Take(ctx, "slow", Limit{Rate: 0.001, Burst: 1}, 1); wait == 0 {
+		t.Error("refilling bucket was reset")
+	}
+}
+
+// stubLimiter answers every Take with wait and err and records the tokens asked for
+type stubLimiter struct {
+	mu    sync.Mutex
+	wait  time.Duration
+	err   error
+	taken []int64
+}
+
+func (s *stubLimiter) Take(ctx context.Context, key string, limit Limit, n int64) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taken = append(s.taken, n)
+	return s.wait, s.err
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	clock := newFakeClock()
+	primary := &stubLimiter{}
+	fallback := NewMemoryLimiter(WithClock(clock.Now))
+	limiter := NewFallbackLimiter(primary, fallback, zap.NewNop())
+	limit := Limit{Rate: 1, Burst: 1}
+	ctx := context.Background()
+
+	primary.wait = 3 * time.Second
+	if wait, err := limiter.Take(ctx, "key", limit, 1); err != nil || wait != 3*time.Second {
+		t.Fatalf("take = %v, %v, want the answer of the primary", wait, err)
+	}
+
+	// the primary fails, the in-process buckets take over
+	primary.err = errors.New("redis down")
+	if wait, err := limiter.Take(ctx, "key", limit, 1); err != nil || wait != 0 {
+		t.Fatalf("take = %v, %v, want granted by the fallback", wait, err)
+	}
+	if wait, err := limiter.Take(ctx, "key", limit, 1); err != nil || wait != time.Second {
+		t.Fatalf("take = %v, %v, want limited by the fallback", wait, err)
+	}
+
+	// a cancelled request is not retried on the fallback
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := limiter.Take(cancelled, "key", limit, 1); err == nil {
+		t.Fatal("take with a cancelled context succeeded")
+	}
+
+	primary.err = nil
+	primary.wait = 0
+	if wait, err := limiter.Take(ctx, "key", limit, 1); err != nil || wait != 0 {
+		t.Fatalf("take = %v, %v, want the primary back", wait, err)
+	}
+}
+
+func TestWaitTakesBurstSizedPieces(t *testing.T) {
+	stub := &stubLimiter{}
+	if err := Wait(context.Background(), stub, "key", Limit{Rate: 100, Burst: 40}, 100); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if want := []int64{40, 40, 20}; !slices.Equal(stub.taken, want) {
+		t.Fatalf("taken = %v, want %v", stub.taken, want)
+	}
+
+	stub = &stubLimiter{wait: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Wait(ctx, stub, "key", Limit{Rate: 1, Burst: 1}, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	stub := &stubLimiter{}
+	content := strings.Repeat("x", 100)
+	r := NewReader(context.Background(), io.NopCloser(strings.NewReader(content)), stub, "key", Limit{Rate: 10, Burst: 30})
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got) != content {
+		t.Fatalf("read %d bytes, want %d", len(got), len(content))
+	}
+	var total int64
+	for _, n := range stub.taken {
+		if n > 30 {
+			t.Fatalf("took %d tokens at once, more than the burst", n)
+		}
+		total += n
+	}
+	if total != 100 {
+		t.Fatalf("took %d tokens for 100 bytes", total)
+	}
+}
+
+func TestWriteThrottled(t *testing.T) {
+	stub := &stubLimiter{}
+	var buf bytes.Buffer
+	content := []byte(strings.Repeat("y", 70))
+
+	n, err := WriteThrottled(context.Background(), &buf, stub, "key", Limit{Rate: 10, Burst: 30}, content)
+	if err != nil || n != len(content) {
+		t.Fatalf("write = %d, %v", n, err)
+	}
+	if buf.String() != string(content) {
+		t.Fatal("written content differs")
+	}
+	if want := []int64{30, 30, 10}; !slices.Equal(stub.taken, want) {
+		t.Fatalf("taken = %v, want %v", stub.taken, want)
+	}
+
+	// nothing is written without tokens
+	stub = &stubLimiter{err: errors.New("limiter down")}
+	buf.Reset()
+	if n, err := WriteThrottled(context.Background(), &buf, stub, "key", Limit{Rate: 10, Burst: 30}, content); err == nil || n != 0 || buf.Len() != 0 {
+		t.Fatalf("write = %d, %v with %d bytes written, want an error before writing", n, err, buf.Len())
+	}
+}
+
+func TestWriteThrottledPacesBytes(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Now()
+	// the first burst goes out at once, the remaining 200 bytes take 200ms at 1000 bytes per second
+	_, err := WriteThrottled(context.Background(), &buf, NewMemoryLimiter(), "key", Limit{Rate: 1000, Burst: 100}, make([]byte, 300))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("300 bytes were written in %v, faster than the limit", elapsed)
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that refilled completely are dropped
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	idle   time.Duration
+}
+
+type memoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type MemoryOption func(*memoryLimiter)
+
+// WithClock makes the limiter read the time from now instead of time.Now
+func WithClock(now func() time.Time) MemoryOption {
+	return func(m *memoryLimiter) {
+		m.now = now
+	}
+}
+
+// NewMemoryLimiter keeps the buckets in process
+func NewMemoryLimiter(opts ...MemoryOption) Limiter {
+	m := &memoryLimiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.lastSweep = m.now()
+	return m
+}
+
+func (m *memoryLimiter) Take(_ context.Context, key string, limit Limit, n int64) (time.Duration, error) {
+	if limit.Unlimited() {
+		return 0, nil
+	}
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = b
+	}
+	b.idle = limit.idle()
+	b.tokens = min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return 0, nil
+	}
+	return time.Duration((float64(n) - b.tokens) / limit.Rate * float64(time.Second)), nil
+}
+
+// sweep drops buckets that are full again, a new bucket starts full as well
+func (m *memoryLimiter) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if now.Sub(b.last) >= b.idle {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// takeScript refills and takes from a bucket stored as a hash of tokens and the time of the last
+// take. The redis clock is used so replicas with skewed clocks share buckets correctly.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local wait = 0
+if tokens >= n then
+	tokens = tokens - n
+else
+	wait = (n - tokens) / rate
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return tostring(wait)
+`)
+
+type redisLimiter struct {
+	client *redis.Client
+}
+
+// NewRedisLimiter keeps the buckets in redis so they are shared by all replicas
+func NewRedisLimiter(client *redis.Client) Limiter {
+	return &redisLimiter{client: client}
+}
+
+func (r *redisLimiter) Take(ctx context.Context, key string, limit Limit, n int64) (time.Duration, error) {
+	if limit.Unlimited() {
+		return 0, nil
+	}
+	result, err := takeScript.Run(ctx, r.client, []string{"ratelimit:" + key}, limit.Rate, limit.Burst, n).Text()
+	if err != nil {
+		return 0, fmt.Errorf("failed to take tokens: %w", err)
+	}
+	seconds, err := strconv.ParseFloat(result, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse token wait: %w", err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
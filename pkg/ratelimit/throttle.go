@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+)
+
+type throttledReader struct {
+	ctx     context.Context
+	r       io.ReadCloser
+	limiter Limiter
+	key     string
+	limit   Limit
+}
+
+// NewReader throttles reads from r to the bytes per second of limit
+func NewReader(ctx context.Context, r io.ReadCloser, limiter Limiter, key string, limit Limit) io.ReadCloser {
+	return &throttledReader{ctx: ctx, r: r, limiter: limiter, key: key, limit: limit}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > t.limit.Burst {
+		p = p[:t.limit.Burst]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := Wait(t.ctx, t.limiter, t.key, t.limit, int64(n)); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (t *throttledReader) Close() error {
+	return t.r.Close()
+}
+
+// WriteThrottled writes p to w, waiting for tokens before each piece of at most the burst
+func WriteThrottled(ctx context.Context, w io.Writer, limiter Limiter, key string, limit Limit, p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		piece := p[:min(int64(len(p)), limit.Burst)]
+		if err := Wait(ctx, limiter, key, limit, int64(len(piece))); err != nil {
+			return written, err
+		}
+		n, err := w.Write(piece)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/roamBo/BoCloudStore/pkg/pool"
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// WithLogger returns a copy of ctx carrying logger
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFrom returns the logger carried by ctx, or fallback. Services log through it so their
+// lines carry the request id of the request they serve.
+func LoggerFrom(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// WithRequestID returns a copy of ctx carrying the id of the request it serves
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFrom returns the request id carried by ctx, empty outside of a request
+func RequestIDFrom(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// CarryLogger hands the logger and request id of the submitter to a pooled task,
+// it is a pool.TaskWrapper
+func CarryLogger(ctx context.Context, task pool.Task) pool.Task {
+	logger, ok := ctx.Value(loggerKey{}).(*zap.Logger)
+	if !ok {
+		return task
+	}
+	requestID := RequestIDFrom(ctx)
+	return func(taskCtx context.Context) error {
+		return task(WithRequestID(WithLogger(taskCtx, logger), requestID))
+	}
+}